@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sensorsgo/sensor"
+	"sensorsgo/sink"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiMaxBody limita el tamaño de las peticiones a la API local
+const apiMaxBody = 64 << 10
+
+// sensorsEditMutex evita que dos peticiones editen la lista de sensores
+// a la vez
+var sensorsEditMutex sync.Mutex
+
+// sensorInfo es un sensor autorizado en las respuestas de la API
+type sensorInfo struct {
+	MAC          string     `json:"mac"`
+	Name         string     `json:"name"`
+	RegisteredAt time.Time  `json:"registered_at"`
+	Disabled     bool       `json:"disabled"`
+	Online       bool       `json:"online"`
+	LastSeen     *time.Time `json:"last_seen"` // null si no se ha visto nunca
+}
+
+// latestResponse es la respuesta de GET /sensors/{mac}/latest
+type latestResponse struct {
+	sensorInfo
+	readingData
+}
+
+// readingData es una lectura en la API local y en los eventos reading
+type readingData struct {
+	RSSI    int16        `json:"rssi"`
+	Reading sink.Payload `json:"reading"`
+}
+
+// sinkInfo es el estado de un destino en GET /status y en los eventos sync
+type sinkInfo struct {
+	Name                string     `json:"name"`
+	State               sink.State `json:"state"`
+	Pending             int        `json:"pending"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Writes              int        `json:"writes"`
+	WriteErrors         int        `json:"write_errors"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastSuccess         *time.Time `json:"last_success"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+// statusResponse es la respuesta de GET /status
+type statusResponse struct {
+	Hostname         string       `json:"hostname"`
+	StartedAt        time.Time    `json:"started_at"`
+	SensorsOnline    int          `json:"sensors_online"`
+	SensorsTotal     int          `json:"sensors_total"` // Sin contar los desactivados
+	LastSync         *time.Time   `json:"last_sync"`     // null antes de la primera sincronización
+	LastSyncReadings int          `json:"last_sync_readings"`
+	SyncOK           bool         `json:"sync_ok"` // Ningún destino está fallando
+	Pending          int          `json:"pending"`
+	Sinks            []sinkInfo   `json:"sinks"`
+	Scanners         []scanStatus `json:"scanners"`
+}
+
+// handleSensors atiende GET /sensors (lista de sensores autorizados con
+// su estado) y POST /sensors (autorizar un sensor)
+func handleSensors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sensors := currentSensors.Load().config.Sensors
+		infos := make([]sensorInfo, len(sensors))
+		for i, s := range sensors {
+			infos[i] = newSensorInfo(s, time.Now())
+		}
+		writeJSON(w, http.StatusOK, infos)
+	case http.MethodPost:
+		if !authorizeAPI(w, r) {
+			return
+		}
+		var body struct {
+			MAC  string `json:"mac"`
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBody)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("JSON inválido: %v", err))
+			return
+		}
+		mac, ok := changeSensors(w, body.MAC, addSensor(body.Name))
+		if !ok {
+			return
+		}
+		for _, s := range currentSensors.Load().config.Sensors {
+			if s.MAC == mac {
+				writeJSON(w, http.StatusCreated, newSensorInfo(s, time.Now()))
+				return
+			}
+		}
+		// Guardado, pero el monitor mantiene su lista (ver reloadSensors)
+		writeJSON(w, http.StatusCreated, sensorInfo{MAC: mac, Name: body.Name, RegisteredAt: time.Now()})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "método no permitido")
+	}
+}
+
+// handleSensor atiende GET /sensors/{mac}/latest (última lectura del
+// sensor) y DELETE /sensors/{mac} (retirar el sensor)
+func handleSensor(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/sensors/"), "/")
+	switch {
+	case len(parts) == 2 && parts[1] == "latest":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeError(w, http.StatusMethodNotAllowed, "método no permitido")
+			return
+		}
+		handleLatest(w, parts[0])
+	case len(parts) == 1 && parts[0] != "":
+		if r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "DELETE")
+			writeError(w, http.StatusMethodNotAllowed, "método no permitido")
+			return
+		}
+		if !authorizeAPI(w, r) {
+			return
+		}
+		if _, ok := changeSensors(w, parts[0], removeSensor); ok {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "ruta desconocida")
+	}
+}
+
+// handleLatest responde con la última lectura de un sensor autorizado
+func handleLatest(w http.ResponseWriter, address string) {
+	mac, err := parseMAC(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var info *sensorInfo
+	for _, s := range currentSensors.Load().config.Sensors {
+		if s.MAC == mac {
+			i := newSensorInfo(s, time.Now())
+			info = &i
+			break
+		}
+	}
+	if info == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("el sensor %s no está autorizado", mac))
+		return
+	}
+
+	latestMutex.Lock()
+	data := latestReadings[mac]
+	latestMutex.Unlock()
+	if data == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("todavía no hay lecturas de %s", mac))
+		return
+	}
+	writeJSON(w, http.StatusOK, latestResponse{sensorInfo: *info, readingData: newReadingData(data)})
+}
+
+// handleStatus responde con el estado del monitor: sensores online,
+// última sincronización, colas de los destinos y estado del escaneo
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "método no permitido")
+		return
+	}
+
+	now := time.Now()
+	status := statusResponse{Hostname: hostname, StartedAt: startedAt, SyncOK: true, Sinks: []sinkInfo{}, Scanners: []scanStatus{}}
+	for _, s := range currentSensors.Load().config.Sensors {
+		if s.Disabled {
+			continue
+		}
+		status.SensorsTotal++
+		if newSensorInfo(s, now).Online {
+			status.SensorsOnline++
+		}
+	}
+
+	lastSyncMutex.Lock()
+	status.LastSync = optionalTime(lastSyncAt)
+	status.LastSyncReadings = lastSyncReadings
+	lastSyncMutex.Unlock()
+
+	for _, runner := range sinkRunners {
+		info := newSinkInfo(runner.Status())
+		if info.LastError != "" {
+			status.SyncOK = false
+		}
+		status.Pending += info.Pending
+		status.Sinks = append(status.Sinks, info)
+	}
+
+	scanMutex.Lock()
+	for _, s := range scanStatuses {
+		status.Scanners = append(status.Scanners, *s)
+	}
+	scanMutex.Unlock()
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// newSinkInfo describe el estado de un destino
+func newSinkInfo(s sink.Status) sinkInfo {
+	info := sinkInfo{
+		Name:                s.Name,
+		State:               s.State,
+		Pending:             s.Pending,
+		ConsecutiveFailures: s.Failures,
+		Writes:              s.Writes,
+		WriteErrors:         s.WriteErrors,
+		LastSuccess:         optionalTime(s.LastSuccess),
+		RetryAt:             optionalTime(s.RetryAt),
+	}
+	if s.LastError != nil {
+		info.LastError = s.LastError.Error()
+	}
+	return info
+}
+
+// newSensorInfo describe un sensor con su estado online según lastSeenMap
+func newSensorInfo(s AuthorizedSensor, now time.Time) sensorInfo {
+	info := sensorInfo{MAC: s.MAC, Name: s.Name, RegisteredAt: s.RegisteredAt, Disabled: s.Disabled}
+
+	lastSeenMutex.Lock()
+	seen, ok := lastSeenMap[s.MAC]
+	lastSeenMutex.Unlock()
+	if ok {
+		info.LastSeen = &seen
+		info.Online = !s.Disabled && now.Sub(seen) < time.Duration(onlineTimeout.Load())
+	}
+	return info
+}
+
+// changeSensors aplica edit a la lista de sensores y la recarga en el
+// monitor. Si falla responde con el error y devuelve false.
+func changeSensors(w http.ResponseWriter, address string, edit sensorEdit) (string, bool) {
+	mac, err := parseMAC(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+
+	sensorsEditMutex.Lock()
+	defer sensorsEditMutex.Unlock()
+
+	message, _, err := updateSensors(mac, edit)
+	switch {
+	case errors.Is(err, errSensorExists), errors.Is(err, errLastSensor):
+		writeError(w, http.StatusConflict, err.Error())
+		return "", false
+	case errors.Is(err, errSensorMissing):
+		writeError(w, http.StatusNotFound, err.Error())
+		return "", false
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return "", false
+	}
+
+	addLog(fmt.Sprintf("🌐 API local: %s", message))
+	reloadSensors(cfg.SensorsFile)
+	return mac, true
+}
+
+// authorizeAPI comprueba el token de las peticiones que cambian la lista
+// de sensores. Sin http.token la API es de solo lectura.
+func authorizeAPI(w http.ResponseWriter, r *http.Request) bool {
+	if cfg.HTTP.Token == "" {
+		writeError(w, http.StatusForbidden, "la gestión de sensores está desactivada (configura http.token)")
+		return false
+	}
+	expected := "Bearer " + cfg.HTTP.Token
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "token inválido")
+		return false
+	}
+	return true
+}
+
+// newReadingData describe una lectura en el formato que se envía a la API
+func newReadingData(data *sensor.RuuviData) readingData {
+	return readingData{RSSI: data.RSSI, Reading: sink.NewPayload(data, hostname)}
+}
+
+// optionalTime devuelve nil para el instante cero, que se serializa como null
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// writeJSON responde con v en JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responde con {"error": message}
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sensorsgo/queue"
+	"sensorsgo/sensor"
+	"sensorsgo/sink"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useAPI starts the local API over a sensors file with an online sensor
+// that has a reading, an offline one and a disabled one
+func useAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+	useSensorsFile(t)
+	cfg.HTTP.Token = "secreto"
+
+	config := &Config{Sensors: []AuthorizedSensor{
+		{MAC: "C4:7C:8D:6A:1B:2E", Name: "Bandeja 1"},
+		{MAC: "AA:BB:CC:DD:EE:FF", Name: "Bandeja 2"},
+		{MAC: "11:22:33:44:55:66", Name: "Bandeja 3", Disabled: true},
+	}}
+	if err := saveConfig(config); err != nil {
+		t.Fatalf("saveConfig: %v", err)
+	}
+	currentSensors.Store(newSensorSet(config))
+	onlineTimeout.Store(int64(2 * time.Minute))
+	temperature := 21.5
+	lastSeenMap = map[string]time.Time{"C4:7C:8D:6A:1B:2E": time.Now(), "11:22:33:44:55:66": time.Now()}
+	latestReadings = map[string]*sensor.RuuviData{
+		"C4:7C:8D:6A:1B:2E": {Model: "ruuvi", Temperature: &temperature, RSSI: -60, Timestamp: time.Now()},
+	}
+	sinkRunners, scanStatuses = nil, nil
+	t.Cleanup(func() { sinkRunners, scanStatuses = nil, nil })
+
+	mux := http.NewServeMux()
+	handleAPI(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// apiRequest sends a request to the local API, with the token if not
+// empty, and returns the status and the body
+func apiRequest(t *testing.T, server *httptest.Server, method, path, token, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// TestAPISensors tests the routes, the token and the error codes of the
+// sensor management API. The requests run in order over the same list.
+func TestAPISensors(t *testing.T) {
+	server := useAPI(t)
+
+	tests := []struct {
+		method, path, token, body string
+		status                    int
+		want                      string // Part of the response
+	}{
+		{"GET", "/sensors", "", "", 200, `"mac":"AA:BB:CC:DD:EE:FF","name":"Bandeja 2","registered_at":"0001-01-01T00:00:00Z","disabled":false,"online":false,"last_seen":null`},
+		{"POST", "/sensors", "", `{"mac":"77:88:99:AA:BB:CC"}`, 401, "token inválido"},
+		{"POST", "/sensors", "otro", `{"mac":"77:88:99:AA:BB:CC"}`, 401, "token inválido"},
+		{"POST", "/sensors", "secreto", `{"mac":`, 400, "JSON inválido"},
+		{"POST", "/sensors", "secreto", `{"mac":"bandeja"}`, 400, "error"},
+		{"POST", "/sensors", "secreto", `{"mac":"c4-7c-8d-6a-1b-2e"}`, 409, "ya está autorizado"},
+		{"POST", "/sensors", "secreto", `{"mac":"77:88:99:aa:bb:cc","name":"Bandeja 4"}`, 201, `"mac":"77:88:99:AA:BB:CC","name":"Bandeja 4"`},
+		{"PUT", "/sensors", "secreto", "", 405, "método no permitido"},
+		{"DELETE", "/sensors/00:00:00:00:00:01", "secreto", "", 404, "no está en la lista"},
+		{"DELETE", "/sensors/77-88-99-AA-BB-CC", "", "", 401, "token inválido"},
+		{"DELETE", "/sensors/77-88-99-AA-BB-CC", "secreto", "", 204, ""},
+		{"GET", "/sensors/77:88:99:AA:BB:CC", "", "", 405, "método no permitido"},
+		{"GET", "/sensors/c4:7c:8d:6a:1b:2e/latest", "", "", 200, `"online":true`},
+		{"GET", "/sensors/C4:7C:8D:6A:1B:2E/latest", "", "", 200, `"rssi":-60,"reading":{"model":"ruuvi","temperature":21.5`},
+		{"GET", "/sensors/AA:BB:CC:DD:EE:FF/latest", "", "", 404, "todavía no hay lecturas"},
+		{"GET", "/sensors/77:88:99:AA:BB:CC/latest", "", "", 404, "no está autorizado"},
+		{"GET", "/sensors/bandeja/latest", "", "", 400, "error"},
+		{"POST", "/sensors/C4:7C:8D:6A:1B:2E/latest", "secreto", "", 405, "método no permitido"},
+		{"GET", "/sensors/C4:7C:8D:6A:1B:2E/history", "", "", 404, "ruta desconocida"},
+		{"GET", "/sensors/", "", "", 404, "ruta desconocida"},
+		// Bandeja 3 is disabled, so Bandeja 1 is the last enabled sensor
+		{"DELETE", "/sensors/AA:BB:CC:DD:EE:FF", "secreto", "", 204, ""},
+		{"DELETE", "/sensors/C4:7C:8D:6A:1B:2E", "secreto", "", 409, "último sensor activo"},
+		{"GET", "/sensors", "", "", 200, `"mac":"C4:7C:8D:6A:1B:2E","name":"Bandeja 1"`},
+	}
+	for _, tt := range tests {
+		status, body := apiRequest(t, server, tt.method, tt.path, tt.token, tt.body)
+		if status != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("%s %s = %d %s, want %d with %s", tt.method, tt.path, status, body, tt.status, tt.want)
+		}
+	}
+
+	// Every change is saved and applied to the monitor's list
+	config, err := readSensors(cfg.SensorsFile)
+	if err != nil || len(config.Sensors) != 2 || config.Sensors[0].MAC != "C4:7C:8D:6A:1B:2E" {
+		t.Errorf("sensors file = %+v, %v", config, err)
+	}
+	if current := currentSensors.Load().config.Sensors; len(current) != 2 {
+		t.Errorf("monitor sensors = %+v", current)
+	}
+
+	// Without a token the API is read-only
+	cfg.HTTP.Token = ""
+	for _, method := range []string{"POST", "DELETE"} {
+		path := "/sensors"
+		if method == "DELETE" {
+			path += "/C4:7C:8D:6A:1B:2E"
+		}
+		if status, body := apiRequest(t, server, method, path, "secreto", `{"mac":"77:88:99:AA:BB:CC"}`); status != 403 {
+			t.Errorf("%s without http.token = %d %s, want 403", method, status, body)
+		}
+	}
+	if status, _ := apiRequest(t, server, "GET", "/sensors", "", ""); status != 200 {
+		t.Errorf("GET without http.token = %d, want 200", status)
+	}
+}
+
+// stubSink fails every write with err, or accepts every reading if err is
+// nil
+type stubSink struct {
+	name string
+	err  error
+}
+
+func (s *stubSink) Name() string { return s.name }
+
+func (s *stubSink) Write(ctx context.Context, readings []sink.Reading) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return len(readings), nil
+}
+
+// stubRunner creates a runner of a stubSink with pending readings in its
+// queue. A failing runner makes one write attempt before returning.
+func stubRunner(t *testing.T, name string, err error, pending int) *sink.Runner {
+	t.Helper()
+	q, qerr := queue.Open(t.TempDir())
+	if qerr != nil {
+		t.Fatalf("queue.Open: %v", qerr)
+	}
+	runner := sink.NewRunner(&stubSink{name: name, err: err}, q, 10)
+	t.Cleanup(func() { runner.Close() })
+	for i := 0; i < pending; i++ {
+		if err := runner.Enqueue(sink.Reading{MAC: "C4:7C:8D:6A:1B:2E"}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	if err == nil {
+		return runner
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner.Run(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for runner.Status().LastError == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	return runner
+}
+
+// TestAPIStatus tests that /status adds up the sensors, the sinks and the
+// scanners
+func TestAPIStatus(t *testing.T) {
+	server := useAPI(t)
+
+	status, body := apiRequest(t, server, "GET", "/status", "", "")
+	var got statusResponse
+	if err := json.Unmarshal([]byte(body), &got); status != 200 || err != nil {
+		t.Fatalf("GET /status = %d %s (%v)", status, body, err)
+	}
+	if got.SensorsTotal != 2 || got.SensorsOnline != 1 || !got.SyncOK || got.Pending != 0 || len(got.Sinks) != 0 || len(got.Scanners) != 0 {
+		t.Errorf("status without sinks = %+v", got)
+	}
+
+	sinkRunners = []*sink.Runner{
+		stubRunner(t, "csv", nil, 2),
+		stubRunner(t, "api", errors.New("connection refused"), 3),
+	}
+	seen := time.Now()
+	scanStatuses = []*scanStatus{{Source: "tinygo", State: "scanning", LastAdvertisement: &seen}}
+	lastSyncMutex.Lock()
+	lastSyncAt, lastSyncReadings = time.Now(), 4
+	lastSyncMutex.Unlock()
+	t.Cleanup(func() {
+		lastSyncMutex.Lock()
+		lastSyncAt, lastSyncReadings = time.Time{}, 0
+		lastSyncMutex.Unlock()
+	})
+
+	status, body = apiRequest(t, server, "GET", "/status", "", "")
+	got = statusResponse{}
+	if err := json.Unmarshal([]byte(body), &got); status != 200 || err != nil {
+		t.Fatalf("GET /status = %d %s (%v)", status, body, err)
+	}
+	if got.SyncOK || got.Pending != 5 || got.LastSync == nil || got.LastSyncReadings != 4 {
+		t.Errorf("status = %+v", got)
+	}
+	if len(got.Sinks) != 2 || got.Sinks[0].Name != "csv" || got.Sinks[0].LastError != "" || got.Sinks[0].Pending != 2 {
+		t.Errorf("sinks = %+v", got.Sinks)
+	} else if s := got.Sinks[1]; s.Name != "api" || s.State != sink.StateRetrying || s.ConsecutiveFailures != 1 || s.LastError != "connection refused" || s.RetryAt == nil {
+		t.Errorf("failing sink = %+v", s)
+	}
+	if len(got.Scanners) != 1 || got.Scanners[0].Source != "tinygo" || got.Scanners[0].LastAdvertisement == nil {
+		t.Errorf("scanners = %+v", got.Scanners)
+	}
+}
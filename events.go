@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sensorsgo/sensor"
+	"sensorsgo/sink"
+	"sensorsgo/stream"
+	"time"
+)
+
+// Tipos de los eventos de /stream
+const (
+	eventReading = "reading" // Lectura decodificada de un sensor autorizado
+	eventOnline  = "online"  // Un sensor pasa a online u offline
+	eventSync    = "sync"    // Resultado de un envío a un destino
+)
+
+// onlineData son los datos de un evento online
+type onlineData struct {
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen"`
+}
+
+// publishReading publica una lectura en /stream
+func publishReading(mac, name string, data *sensor.RuuviData) {
+	if eventHub.Clients() == 0 {
+		return
+	}
+	eventHub.Publish(stream.Event{Type: eventReading, MAC: mac, Name: name, Data: newReadingData(data)})
+}
+
+// publishOnline publica en /stream el paso de un sensor a online u offline
+func publishOnline(s AuthorizedSensor, online bool, lastSeen time.Time) {
+	eventHub.Publish(stream.Event{
+		Type: eventOnline,
+		MAC:  s.MAC,
+		Name: s.Name,
+		Data: onlineData{Online: online, LastSeen: optionalTime(lastSeen)},
+	})
+}
+
+// publishSync publica en /stream el estado de un destino tras un envío
+func publishSync(status sink.Status) {
+	eventHub.Publish(stream.Event{Type: eventSync, Data: newSinkInfo(status)})
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "sync"
+
+// fileLocks sustituye a flock donde no existe: solo ordena las ediciones
+// del mismo proceso
+var fileLocks sync.Mutex
+
+// lockFile toma el bloqueo de las ediciones y devuelve la función que lo
+// libera
+func lockFile(path string) (func(), error) {
+	fileLocks.Lock()
+	return fileLocks.Unlock, nil
+}
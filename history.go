@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sensorsgo/history"
+	"sensorsgo/sensor"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyAggregates son las funciones que se pueden pedir por intervalo
+var historyAggregates = []string{"min", "max", "avg", "count", "stddev"}
+
+// historyRow es una fila del resultado: un intervalo de un sensor
+type historyRow struct {
+	mac   string
+	name  string
+	point history.Point
+}
+
+// runHistoryCommand ejecuta el subcomando history y devuelve el código
+// de salida
+func runHistoryCommand(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	sensors := fs.String("sensor", "", "Sensores a consultar, por nombre o MAC, separados por comas (por defecto todos)")
+	from := fs.String("from", "", "Inicio: 2006-01-02, \"2006-01-02 15:04\" o RFC 3339 (por defecto ahora menos -last)")
+	to := fs.String("to", "", "Fin, con el mismo formato que -from (por defecto ahora)")
+	last := fs.Duration("last", 24*time.Hour, "Periodo hasta -to si no se indica -from")
+	resolution := fs.String("res", "", "Resolución de los datos guardados: raw, 1m o 1h (por defecto la más gruesa que encaje con -bucket, o 1m)")
+	bucket := fs.Duration("bucket", 0, "Agrupar en intervalos de esta duración (ej. 15m, 1h, 24h); 0 = sin agrupar")
+	fields := fs.String("fields", "", "Campos a mostrar separados por comas, ej. temperature,humidity (por defecto todos)")
+	aggregates := fs.String("agg", "avg", "Funciones por intervalo separadas por comas: "+strings.Join(historyAggregates, ", "))
+	format := fs.String("format", "table", "Formato de salida: table, csv o jsonl")
+	fs.Usage = func() {
+		fmt.Println("Uso: insectius-monitor [flags] history [opciones]")
+		fmt.Println()
+		fmt.Println("Consulta el histórico local de lecturas. Ejemplo:")
+		fmt.Println("  insectius-monitor history -sensor \"Bandeja 3\" -from \"2026-03-01 20:00\" -to \"2026-03-02 08:00\" -bucket 1h -fields humidity -agg min,max,avg")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "table" && *format != "csv" && *format != "jsonl" {
+		fmt.Printf("❌ Error: -format: formato desconocido %q (table, csv o jsonl)\n", *format)
+		return 2
+	}
+
+	q, err := newHistoryQuery(*sensors, *from, *to, *last, *resolution, *bucket, *fields, *aggregates)
+	if err == nil {
+		err = q.run(os.Stdout, *format)
+	}
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// historyQuery es una consulta ya validada
+type historyQuery struct {
+	macs       []string
+	names      map[string]string
+	from, to   time.Time
+	resolution history.Resolution
+	bucket     time.Duration
+	fields     []string
+	aggregates []string
+}
+
+func newHistoryQuery(sensors, from, to string, last time.Duration, resolution string, bucket time.Duration, fields, aggregates string) (*historyQuery, error) {
+	q := &historyQuery{names: make(map[string]string), bucket: bucket}
+
+	var err error
+	q.to = time.Now()
+	if to != "" {
+		if q.to, err = parseHistoryTime(to); err != nil {
+			return nil, fmt.Errorf("-to: %w", err)
+		}
+	}
+	q.from = q.to.Add(-last)
+	if from != "" {
+		if q.from, err = parseHistoryTime(from); err != nil {
+			return nil, fmt.Errorf("-from: %w", err)
+		}
+	}
+	if !q.from.Before(q.to) {
+		return nil, errors.New("-from debe ser anterior a -to")
+	}
+
+	if bucket < 0 {
+		return nil, errors.New("-bucket no puede ser negativo")
+	}
+	switch {
+	case resolution != "":
+		if q.resolution, err = history.ParseResolution(resolution); err != nil {
+			return nil, fmt.Errorf("-res: %w", err)
+		}
+	case bucket > 0:
+		// La resolución más gruesa cuyos intervalos caben enteros en -bucket
+		q.resolution = history.Raw
+		for _, r := range history.Resolutions {
+			if r.Step() > 0 && bucket%r.Step() == 0 {
+				q.resolution = r
+			}
+		}
+	default:
+		q.resolution = history.Minute
+	}
+	if bucket > 0 && bucket < q.resolution.Step() {
+		return nil, fmt.Errorf("-bucket %v es menor que la resolución %s", bucket, q.resolution)
+	}
+
+	for _, agg := range splitList(aggregates) {
+		known := false
+		for _, a := range historyAggregates {
+			known = known || a == agg
+		}
+		if !known {
+			return nil, fmt.Errorf("-agg: función desconocida %q (disponibles: %s)", agg, strings.Join(historyAggregates, ", "))
+		}
+		q.aggregates = append(q.aggregates, agg)
+	}
+	if len(q.aggregates) == 0 {
+		return nil, errors.New("-agg: indica al menos una función")
+	}
+
+	for _, field := range splitList(fields) {
+		known := false
+		for _, f := range sensor.Fields {
+			known = known || f.Key == field
+		}
+		if !known {
+			return nil, fmt.Errorf("-fields: campo desconocido %q", field)
+		}
+		q.fields = append(q.fields, field)
+	}
+
+	return q, q.resolveSensors(sensors)
+}
+
+// resolveSensors busca los sensores por nombre (sin distinguir
+// mayúsculas) o MAC; sin ninguno se consultan todos los del histórico
+func (q *historyQuery) resolveSensors(list string) error {
+	config, err := readSensors(cfg.SensorsFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if config != nil {
+		for _, s := range config.Sensors {
+			q.names[s.MAC] = s.Name
+		}
+	}
+
+	if list == "" {
+		q.macs, err = history.Sensors(cfg.HistoryDir())
+		if err != nil {
+			return err
+		}
+		sort.Slice(q.macs, func(i, j int) bool {
+			return q.names[q.macs[i]]+q.macs[i] < q.names[q.macs[j]]+q.macs[j]
+		})
+		return nil
+	}
+
+	for _, wanted := range splitList(list) {
+		if mac, err := parseMAC(wanted); err == nil {
+			q.macs = append(q.macs, mac)
+			continue
+		}
+		found := ""
+		if config != nil {
+			for _, s := range config.Sensors {
+				if strings.EqualFold(s.Name, wanted) {
+					found = s.MAC
+					break
+				}
+			}
+		}
+		if found == "" {
+			return fmt.Errorf("no hay ningún sensor llamado %q en %s", wanted, cfg.SensorsFile)
+		}
+		q.macs = append(q.macs, found)
+	}
+	return nil
+}
+
+// run consulta el histórico y escribe el resultado en w
+func (q *historyQuery) run(w io.Writer, format string) error {
+	var rows []historyRow
+	present := make(map[string]bool)
+	for _, mac := range q.macs {
+		points, err := history.Query(cfg.HistoryDir(), mac, q.resolution, q.from, q.to)
+		if err != nil {
+			return fmt.Errorf("%s: %w", mac, err)
+		}
+		if q.bucket > 0 {
+			points = history.Rollup(points, q.bucket, time.Local)
+		}
+		for _, p := range points {
+			rows = append(rows, historyRow{mac: mac, name: q.names[mac], point: p})
+			for key := range p.Values {
+				present[key] = true
+			}
+		}
+	}
+
+	// Sin -fields, todos los campos con datos en el orden de sensor.Fields
+	fields := q.fields
+	if len(fields) == 0 {
+		for _, f := range sensor.Fields {
+			if present[f.Key] && f.Key != "measurement_sequence" {
+				fields = append(fields, f.Key)
+			}
+		}
+	}
+
+	switch format {
+	case "table":
+		if len(rows) == 0 {
+			fmt.Fprintf(w, "Sin datos entre %s y %s (resolución %s)\n", q.from.Format("2006-01-02 15:04"), q.to.Format("2006-01-02 15:04"), q.resolution)
+			return nil
+		}
+		return q.writeTable(w, rows, fields)
+	case "csv":
+		return q.writeCSV(w, rows, fields)
+	default:
+		return q.writeJSONL(w, rows, fields)
+	}
+}
+
+// columns devuelve los nombres de columna de cada campo y función
+func (q *historyQuery) columns(fields []string) []string {
+	var columns []string
+	for _, field := range fields {
+		for _, agg := range q.aggregates {
+			if len(q.aggregates) == 1 && agg == "avg" {
+				columns = append(columns, field)
+				continue
+			}
+			columns = append(columns, field+"_"+agg)
+		}
+	}
+	return columns
+}
+
+// aggregateValue devuelve el resultado de una función sobre las
+// estadísticas de un campo
+func aggregateValue(stats sensor.Stats, agg string) float64 {
+	switch agg {
+	case "min":
+		return stats.Min
+	case "max":
+		return stats.Max
+	case "count":
+		return float64(stats.Count)
+	case "stddev":
+		return stats.StdDev
+	}
+	return stats.Mean
+}
+
+func (q *historyQuery) writeTable(w io.Writer, rows []historyRow, fields []string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := append([]string{"HORA", "SENSOR"}, q.columns(fields)...)
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+	for _, row := range rows {
+		cells := []string{row.point.Time.Local().Format("2006-01-02 15:04:05"), sensorLabel(row.mac, row.name)}
+		for _, field := range fields {
+			stats, ok := row.point.Values[field]
+			for _, agg := range q.aggregates {
+				switch {
+				case !ok:
+					cells = append(cells, "-")
+				case agg == "count":
+					cells = append(cells, strconv.Itoa(stats.Count))
+				default:
+					cells = append(cells, strconv.FormatFloat(aggregateValue(stats, agg), 'f', 2, 64))
+				}
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+func (q *historyQuery) writeCSV(w io.Writer, rows []historyRow, fields []string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"time", "mac", "name"}, q.columns(fields)...))
+	for _, row := range rows {
+		record := []string{row.point.Time.Format(time.RFC3339), row.mac, row.name}
+		for _, field := range fields {
+			stats, ok := row.point.Values[field]
+			for _, agg := range q.aggregates {
+				if !ok {
+					record = append(record, "")
+					continue
+				}
+				record = append(record, strconv.FormatFloat(aggregateValue(stats, agg), 'f', -1, 64))
+			}
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (q *historyQuery) writeJSONL(w io.Writer, rows []historyRow, fields []string) error {
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		values := make(map[string]map[string]float64)
+		for _, field := range fields {
+			stats, ok := row.point.Values[field]
+			if !ok {
+				continue
+			}
+			values[field] = make(map[string]float64, len(q.aggregates))
+			for _, agg := range q.aggregates {
+				values[field][agg] = aggregateValue(stats, agg)
+			}
+		}
+		err := enc.Encode(struct {
+			Time   string                        `json:"time"`
+			MAC    string                        `json:"mac"`
+			Name   string                        `json:"name,omitempty"`
+			Values map[string]map[string]float64 `json:"values"`
+		}{row.point.Time.Format(time.RFC3339), row.mac, row.name, values})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseHistoryTime interpreta una fecha en hora local o RFC 3339
+func parseHistoryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("fecha inválida %q (formatos: 2006-01-02, \"2006-01-02 15:04\", RFC 3339)", s)
+}
+
+// sensorLabel muestra el nombre de un sensor, o su MAC si no tiene
+func sensorLabel(mac, name string) string {
+	if name == "" {
+		return mac
+	}
+	return name
+}
+
+// splitList separa una lista separada por comas, sin elementos vacíos
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
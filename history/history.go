@@ -0,0 +1,303 @@
+// Package history keeps the readings of every sensor on disk, together
+// with 1-minute and 1-hour rollups that are kept for longer than the raw
+// readings. Each sensor and resolution is a directory of append-only
+// segment files, one per day (one per month for hourly rollups).
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sensorsgo/internal/frame"
+	"sensorsgo/sensor"
+	"sort"
+	"strings"
+	"time"
+)
+
+const segmentExt = ".seg"
+
+// Resolution is the time step of a series
+type Resolution string
+
+// Resolutions of the store: every reading, and rollups per minute and
+// per hour
+const (
+	Raw    Resolution = "raw"
+	Minute Resolution = "1m"
+	Hour   Resolution = "1h"
+)
+
+// Resolutions lists the resolutions from finest to coarsest
+var Resolutions = []Resolution{Raw, Minute, Hour}
+
+// ParseResolution parses "raw", "1m" or "1h"
+func ParseResolution(s string) (Resolution, error) {
+	for _, r := range Resolutions {
+		if string(r) == s {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("unknown resolution %q (available: raw, 1m, 1h)", s)
+}
+
+// Step returns the length of a rollup bucket, 0 for Raw
+func (r Resolution) Step() time.Duration {
+	switch r {
+	case Minute:
+		return time.Minute
+	case Hour:
+		return time.Hour
+	}
+	return 0
+}
+
+// segmentLayout is the time layout of segment file names
+func (r Resolution) segmentLayout() string {
+	if r == Hour {
+		return "2006-01"
+	}
+	return "2006-01-02"
+}
+
+// segmentName returns the name of the segment holding time t
+func (r Resolution) segmentName(t time.Time) string {
+	return t.UTC().Format(r.segmentLayout()) + segmentExt
+}
+
+// segmentSpan returns the time range covered by a segment file
+func (r Resolution) segmentSpan(name string) (start, end time.Time, err error) {
+	start, err = time.Parse(r.segmentLayout(), strings.TrimSuffix(name, segmentExt))
+	if err != nil {
+		return start, end, err
+	}
+	if r == Hour {
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	return start, start.AddDate(0, 0, 1), nil
+}
+
+// Point is a reading, or a rollup bucket starting at Time. Raw readings
+// have a Count of 1 in every field.
+type Point struct {
+	Time   time.Time
+	Values map[string]sensor.Stats
+}
+
+// record is how a Point is stored: raw readings keep plain values
+type record struct {
+	Time   int64                   `json:"t"` // Unix milliseconds
+	Values map[string]float64      `json:"v,omitempty"`
+	Stats  map[string]sensor.Stats `json:"s,omitempty"`
+}
+
+func (rec record) point() Point {
+	p := Point{Time: time.UnixMilli(rec.Time).UTC(), Values: rec.Stats}
+	if rec.Values != nil {
+		p.Values = make(map[string]sensor.Stats, len(rec.Values))
+		for key, v := range rec.Values {
+			p.Values[key] = sensor.Stats{Count: 1, Min: v, Max: v, Mean: v}
+		}
+	}
+	return p
+}
+
+// sensorDir returns the directory name of a sensor: its address without
+// separators, so that it is a valid file name everywhere
+func sensorDir(mac string) string {
+	return strings.NewReplacer(":", "", "/", "", "\\", "").Replace(strings.ToUpper(mac))
+}
+
+// Sensors returns the addresses of the sensors with history in dir
+func Sensors(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var macs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			macs = append(macs, dirMAC(entry.Name()))
+		}
+	}
+	return macs, nil
+}
+
+// dirMAC reverses sensorDir for MAC addresses
+func dirMAC(name string) string {
+	if len(name) != 12 {
+		return name
+	}
+	parts := make([]string, 6)
+	for i := range parts {
+		parts[i] = name[2*i : 2*i+2]
+	}
+	return strings.Join(parts, ":")
+}
+
+// Query returns the points of a sensor at resolution res with from <= Time
+// < to, oldest first. Rollups of the same bucket written twice are merged.
+// Query only reads the files, so it may be used while another process is
+// writing to the store.
+func Query(dir, mac string, res Resolution, from, to time.Time) ([]Point, error) {
+	seriesDir := filepath.Join(dir, sensorDir(mac), string(res))
+	names, err := segments(seriesDir, res)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []Point
+	for _, name := range names {
+		start, end, _ := res.segmentSpan(name)
+		if !end.After(from) || !start.Before(to) {
+			continue
+		}
+		err := readSegment(filepath.Join(seriesDir, name), func(rec record) {
+			if t := time.UnixMilli(rec.Time); !t.Before(from) && t.Before(to) {
+				points = append(points, rec.point())
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	if res == Raw {
+		return points, nil
+	}
+
+	merged := points[:0]
+	for _, p := range points {
+		if n := len(merged); n > 0 && merged[n-1].Time.Equal(p.Time) {
+			merged[n-1].Values = mergeValues(merged[n-1].Values, p.Values)
+			continue
+		}
+		merged = append(merged, p)
+	}
+	return merged, nil
+}
+
+// mergeValues merges the statistics of b into a copy of a
+func mergeValues(a, b map[string]sensor.Stats) map[string]sensor.Stats {
+	merged := make(map[string]sensor.Stats, len(a))
+	for key, stats := range a {
+		merged[key] = stats
+	}
+	for key, stats := range b {
+		merged[key] = merged[key].Merge(stats)
+	}
+	return merged
+}
+
+// segments lists the segment files of a series, oldest first
+func segments(dir string, res Resolution) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history dir: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if _, _, err := res.segmentSpan(entry.Name()); err == nil && strings.HasSuffix(entry.Name(), segmentExt) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readSegment calls fn for every record of a segment. Reading stops at a
+// torn or corrupt record, which is how a record being appended looks.
+func readSegment(path string, fn func(record)) error {
+	_, err := scanSegment(path, fn)
+	return err
+}
+
+// scanSegment is readSegment returning the offset after the last valid
+// record
+func scanSegment(path string, fn func(record)) (int64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Removed by retention while listing
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("opening segment: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	off := int64(0)
+	for {
+		data, next, err := frame.Read(r, off)
+		if err == io.EOF || err == frame.ErrCorrupt {
+			return off, nil
+		}
+		if err != nil {
+			return off, err
+		}
+		off = next
+
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			continue
+		}
+		fn(rec)
+	}
+}
+
+// encodeRecord frames rec for appending to a segment
+func encodeRecord(rec record) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return frame.Encode(data), nil
+}
+
+// Rollup merges points into buckets of length step, aligned to midnight
+// in loc, keeping the order of points
+func Rollup(points []Point, step time.Duration, loc *time.Location) []Point {
+	var buckets []Point
+	for _, p := range points {
+		start := bucketStart(p.Time, step, loc)
+
+		if n := len(buckets); n > 0 && buckets[n-1].Time.Equal(start) {
+			buckets[n-1].Values = mergeValues(buckets[n-1].Values, p.Values)
+			continue
+		}
+		buckets = append(buckets, Point{Time: start, Values: mergeValues(nil, p.Values)})
+	}
+	return buckets
+}
+
+// bucketStart returns the start of the bucket of length step holding t.
+// Buckets of a day or more start at local midnight, so that a day with a
+// daylight saving change is still one bucket; shorter ones follow the
+// local wall clock.
+func bucketStart(t time.Time, step time.Duration, loc *time.Location) time.Time {
+	local := t.In(loc)
+	if step >= 24*time.Hour {
+		days := int(step / (24 * time.Hour))
+		y, m, d := local.Date()
+		// Count days since 1970 so that multi-day buckets do not depend
+		// on the first point
+		n := int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+		return time.Date(y, m, d-n%days, 0, 0, 0, 0, loc)
+	}
+
+	_, offset := local.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(step).Add(-shift)
+}
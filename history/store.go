@@ -0,0 +1,413 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sensorsgo/sensor"
+	"sync"
+	"time"
+)
+
+// Options sets how long each resolution is kept; 0 keeps it forever
+type Options struct {
+	RawRetention    time.Duration
+	MinuteRetention time.Duration
+	HourRetention   time.Duration
+}
+
+// retention returns the retention of res
+func (o Options) retention(res Resolution) time.Duration {
+	switch res {
+	case Minute:
+		return o.MinuteRetention
+	case Hour:
+		return o.HourRetention
+	}
+	return o.RawRetention
+}
+
+// Store records readings and their rollups. It is safe for concurrent use.
+//
+// A rollup bucket is written once a reading of a later bucket arrives, or
+// by Maintain once its time is over. Open buckets only live in memory:
+// after a crash they are rebuilt from the raw readings and the minute
+// rollups on disk, so no rollup is lost while the raw readings are kept.
+type Store struct {
+	dir  string
+	opts Options
+
+	mu     sync.Mutex
+	series map[string]*series // By sensor directory
+}
+
+// series is the state of one sensor
+type series struct {
+	dir     string
+	files   map[Resolution]*os.File // Segment open for appending
+	names   map[Resolution]string   // Name of that segment
+	minute  *bucket
+	hour    *bucket
+	lastSeq *uint32 // Sequence of the last raw reading, to skip rebroadcasts
+}
+
+// bucket accumulates a rollup
+type bucket struct {
+	start time.Time
+	stats map[string]sensor.Stats
+}
+
+// Open opens (or creates) the store in dir, truncating records torn by a
+// crash and rebuilding the open rollup buckets of every sensor
+func Open(dir string, opts Options) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating history dir: %w", err)
+	}
+	s := &Store{dir: dir, opts: opts, series: make(map[string]*series)}
+
+	macs, err := Sensors(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading history dir: %w", err)
+	}
+	for _, mac := range macs {
+		if _, err := s.open(mac); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("history of %s: %w", mac, err)
+		}
+	}
+	return s, nil
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Add records a decoded reading of the sensor mac. A rebroadcast of the
+// previous measurement (same sequence number) is not recorded again.
+func (s *Store) Add(mac string, data *sensor.RuuviData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sr, err := s.open(mac)
+	if err != nil {
+		return err
+	}
+	if data.Sequence != nil && sr.lastSeq != nil && *data.Sequence == *sr.lastSeq {
+		return nil
+	}
+
+	t := data.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	values := data.Values()
+	if err := sr.write(Raw, record{Time: t.UnixMilli(), Values: values}); err != nil {
+		return err
+	}
+	sr.lastSeq = data.Sequence
+
+	return sr.addMinute(t, rawStats(values))
+}
+
+// Maintain writes the rollup buckets whose time is over, removes segments
+// older than their retention and flushes the open segments to disk. It
+// should be called periodically, e.g. every minute.
+func (s *Store) Maintain(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, sr := range s.series {
+		if sr.minute != nil && !sr.minute.start.Add(time.Minute).After(now) {
+			keep(sr.flushMinute())
+		}
+		if sr.hour != nil && !sr.hour.start.Add(time.Hour).After(now) {
+			keep(sr.flushHour())
+		}
+
+		for _, res := range Resolutions {
+			if retention := s.opts.retention(res); retention > 0 {
+				keep(sr.expire(res, now.Add(-retention)))
+			}
+			if f := sr.files[res]; f != nil {
+				keep(f.Sync())
+			}
+		}
+	}
+	return firstErr
+}
+
+// Close flushes and closes the open segments. Open rollup buckets are not
+// written; they are rebuilt when the store is opened again.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for _, sr := range s.series {
+		for res, f := range sr.files {
+			if err := f.Sync(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if err := f.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			delete(sr.files, res)
+		}
+	}
+	return firstErr
+}
+
+// open returns the series of mac, recovering its state from disk the
+// first time
+func (s *Store) open(mac string) (*series, error) {
+	name := sensorDir(mac)
+	if sr := s.series[name]; sr != nil {
+		return sr, nil
+	}
+
+	sr := &series{
+		dir:   filepath.Join(s.dir, name),
+		files: make(map[Resolution]*os.File),
+		names: make(map[Resolution]string),
+	}
+	if err := sr.recover(); err != nil {
+		return nil, err
+	}
+	s.series[name] = sr
+	return sr, nil
+}
+
+// recover rebuilds the open buckets: the hour bucket from the minute
+// rollups written after the last hourly one, then the minute bucket from
+// the raw readings after the last minute rollup. Buckets completed while
+// replaying are written.
+func (sr *series) recover() error {
+	lastHour, err := sr.last(Hour)
+	if err != nil {
+		return err
+	}
+	minutes, err := sr.after(Minute, lastHour, time.Hour)
+	if err != nil {
+		return err
+	}
+	for _, p := range minutes {
+		if err := sr.addHour(p.Time, p.Values); err != nil {
+			return err
+		}
+	}
+
+	lastMinute, err := sr.last(Minute)
+	if err != nil {
+		return err
+	}
+	readings, err := sr.after(Raw, lastMinute, time.Minute)
+	if err != nil {
+		return err
+	}
+	for _, p := range readings {
+		values := make(map[string]float64, len(p.Values))
+		for key, stats := range p.Values {
+			values[key] = stats.Mean
+		}
+		if err := sr.addMinute(p.Time, rawStats(values)); err != nil {
+			return err
+		}
+	}
+
+	// Skip a rebroadcast of the last reading recorded before restarting
+	if n := len(readings); n > 0 {
+		if seq, ok := readings[n-1].Values["measurement_sequence"]; ok {
+			last := uint32(seq.Mean)
+			sr.lastSeq = &last
+		}
+	}
+	return nil
+}
+
+// last returns the time of the newest point of res, zero if there is none
+func (sr *series) last(res Resolution) (time.Time, error) {
+	dir := filepath.Join(sr.dir, string(res))
+	names, err := segments(dir, res)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		var last time.Time
+		err := readSegment(filepath.Join(dir, names[i]), func(rec record) {
+			if t := time.UnixMilli(rec.Time); t.After(last) {
+				last = t
+			}
+		})
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !last.IsZero() {
+			return last, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// after returns the points of res after the bucket of length step that
+// starts at t (every point if t is zero)
+func (sr *series) after(res Resolution, t time.Time, step time.Duration) ([]Point, error) {
+	from := time.Time{}
+	if !t.IsZero() {
+		from = t.Add(step)
+	}
+	return Query(filepath.Dir(sr.dir), dirMAC(filepath.Base(sr.dir)), res, from, farFuture)
+}
+
+// farFuture is the end of open time ranges
+var farFuture = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// addMinute adds values measured at t to the minute rollups
+func (sr *series) addMinute(t time.Time, values map[string]sensor.Stats) error {
+	start := t.UTC().Truncate(time.Minute)
+	if sr.minute != nil && start.After(sr.minute.start) {
+		if err := sr.flushMinute(); err != nil {
+			return err
+		}
+	}
+	if sr.minute == nil {
+		sr.minute = &bucket{start: start, stats: make(map[string]sensor.Stats)}
+	}
+	sr.minute.add(values)
+	return nil
+}
+
+// addHour adds a minute rollup starting at t to the hour rollups
+func (sr *series) addHour(t time.Time, values map[string]sensor.Stats) error {
+	start := t.UTC().Truncate(time.Hour)
+	if sr.hour != nil && start.After(sr.hour.start) {
+		if err := sr.flushHour(); err != nil {
+			return err
+		}
+	}
+	if sr.hour == nil {
+		sr.hour = &bucket{start: start, stats: make(map[string]sensor.Stats)}
+	}
+	sr.hour.add(values)
+	return nil
+}
+
+// flushMinute writes the open minute bucket and adds it to the hour
+func (sr *series) flushMinute() error {
+	b := sr.minute
+	sr.minute = nil
+	if err := sr.write(Minute, record{Time: b.start.UnixMilli(), Stats: b.stats}); err != nil {
+		return err
+	}
+	return sr.addHour(b.start, b.stats)
+}
+
+// flushHour writes the open hour bucket
+func (sr *series) flushHour() error {
+	b := sr.hour
+	sr.hour = nil
+	return sr.write(Hour, record{Time: b.start.UnixMilli(), Stats: b.stats})
+}
+
+func (b *bucket) add(values map[string]sensor.Stats) {
+	for key, stats := range values {
+		b.stats[key] = b.stats[key].Merge(stats)
+	}
+}
+
+// rawStats turns the values of a reading into rollup statistics. The
+// sequence number identifies measurements, it is not aggregated.
+func rawStats(values map[string]float64) map[string]sensor.Stats {
+	stats := make(map[string]sensor.Stats, len(values))
+	for key, v := range values {
+		if key == "measurement_sequence" {
+			continue
+		}
+		stats[key] = sensor.Stats{Count: 1, Min: v, Max: v, Mean: v}
+	}
+	return stats
+}
+
+// write appends rec to the segment of its time
+func (sr *series) write(res Resolution, rec record) error {
+	framed, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	name := res.segmentName(time.UnixMilli(rec.Time))
+	if sr.names[res] != name {
+		if err := sr.openSegment(res, name); err != nil {
+			return err
+		}
+	}
+	if _, err := sr.files[res].Write(framed); err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	return nil
+}
+
+// openSegment opens a segment for appending, truncating a record torn by
+// a crash at its end
+func (sr *series) openSegment(res Resolution, name string) error {
+	if f := sr.files[res]; f != nil {
+		f.Close()
+		delete(sr.files, res)
+		delete(sr.names, res)
+	}
+
+	dir := filepath.Join(sr.dir, string(res))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating history dir: %w", err)
+	}
+	path := filepath.Join(dir, name)
+
+	valid, err := scanSegment(path, func(record) {})
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > valid {
+		if err := os.Truncate(path, valid); err != nil {
+			return fmt.Errorf("truncating segment: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening segment: %w", err)
+	}
+	sr.files[res] = f
+	sr.names[res] = name
+	return nil
+}
+
+// expire removes the segments of res that end before cutoff
+func (sr *series) expire(res Resolution, cutoff time.Time) error {
+	dir := filepath.Join(sr.dir, string(res))
+	names, err := segments(dir, res)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, end, _ := res.segmentSpan(name)
+		if end.After(cutoff) {
+			break
+		}
+		if sr.names[res] == name {
+			sr.files[res].Close()
+			delete(sr.files, res)
+			delete(sr.names, res)
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing segment: %w", err)
+		}
+	}
+	return nil
+}
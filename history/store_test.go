@@ -0,0 +1,200 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"sensorsgo/sensor"
+	"testing"
+	"time"
+)
+
+const testMAC = "C4:7C:8D:6A:1B:2E"
+
+func reading(t time.Time, temperature float64, sequence uint32) *sensor.RuuviData {
+	return &sensor.RuuviData{
+		DataFormat:  sensor.FormatRAWv2,
+		Temperature: &temperature,
+		Sequence:    &sequence,
+		Timestamp:   t,
+	}
+}
+
+// TestStoreRollups tests that readings are kept raw and rolled up per
+// minute and per hour, and that rebroadcasts are recorded once
+func TestStoreRollups(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	adds := []*sensor.RuuviData{
+		reading(start, 20, 1),
+		reading(start.Add(time.Second), 20, 1), // Rebroadcast
+		reading(start.Add(30*time.Second), 22, 2),
+		reading(start.Add(90*time.Second), 30, 3),
+		reading(start.Add(61*time.Minute), 10, 4),
+	}
+	for _, d := range adds {
+		if err := s.Add(testMAC, d); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if err := s.Maintain(start.Add(3 * time.Hour)); err != nil {
+		t.Fatalf("Maintain: %v", err)
+	}
+
+	raw, err := Query(dir, testMAC, Raw, start, farFuture)
+	if err != nil || len(raw) != 4 {
+		t.Fatalf("raw = %d points, %v; want 4", len(raw), err)
+	}
+
+	minutes, _ := Query(dir, testMAC, Minute, start, farFuture)
+	if len(minutes) != 3 {
+		t.Fatalf("1m = %d points, want 3", len(minutes))
+	}
+	if got := minutes[0].Values["temperature"]; got.Count != 2 || got.Mean != 21 || got.Min != 20 || got.Max != 22 {
+		t.Errorf("first minute = %+v", got)
+	}
+	if _, ok := minutes[0].Values["measurement_sequence"]; ok {
+		t.Error("measurement_sequence should not be rolled up")
+	}
+
+	hours, _ := Query(dir, testMAC, Hour, start, farFuture)
+	if len(hours) != 2 {
+		t.Fatalf("1h = %d points, want 2", len(hours))
+	}
+	if got := hours[0].Values["temperature"]; got.Count != 3 || got.Max != 30 || !hours[0].Time.Equal(start) {
+		t.Errorf("first hour = %v %+v", hours[0].Time, got)
+	}
+
+	if macs, _ := Sensors(dir); len(macs) != 1 || macs[0] != testMAC {
+		t.Errorf("Sensors = %v", macs)
+	}
+}
+
+// TestStoreRecovery tests that a torn record is dropped and that the
+// minute being accumulated when the process stopped is rebuilt from the
+// raw readings
+func TestStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	s.Add(testMAC, reading(start, 20, 1))
+	s.Add(testMAC, reading(start.Add(10*time.Second), 24, 2))
+	s.Close()
+
+	// A crash in the middle of appending a record
+	segment := filepath.Join(dir, sensorDir(testMAC), string(Raw), Raw.segmentName(start))
+	f, _ := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{0, 0, 0, 40, 1, 2})
+	f.Close()
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	if err := s.Add(testMAC, reading(start.Add(20*time.Second), 22, 2)); err != nil { // Rebroadcast
+		t.Fatalf("Add: %v", err)
+	}
+	if err := s.Add(testMAC, reading(start.Add(time.Minute), 30, 3)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	raw, _ := Query(dir, testMAC, Raw, start, farFuture)
+	if len(raw) != 3 {
+		t.Errorf("raw = %d points, want 3", len(raw))
+	}
+	minutes, _ := Query(dir, testMAC, Minute, start, farFuture)
+	if len(minutes) != 1 {
+		t.Fatalf("1m = %d points, want 1", len(minutes))
+	}
+	if got := minutes[0].Values["temperature"]; got.Count != 2 || got.Mean != 22 {
+		t.Errorf("recovered minute = %+v", got)
+	}
+}
+
+// TestStoreRetention tests that each resolution is removed after its own
+// retention
+func TestStoreRetention(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{RawRetention: 48 * time.Hour, MinuteRetention: 30 * 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.Add(testMAC, reading(start, 20, 1))
+	s.Add(testMAC, reading(start.Add(3*24*time.Hour), 21, 2))
+	if err := s.Maintain(start.Add(3*24*time.Hour + 2*time.Hour)); err != nil {
+		t.Fatalf("Maintain: %v", err)
+	}
+
+	raw, _ := Query(dir, testMAC, Raw, time.Time{}, farFuture)
+	if len(raw) != 1 || !raw[0].Time.Equal(start.Add(3*24*time.Hour)) {
+		t.Errorf("raw after retention = %v", raw)
+	}
+	minutes, _ := Query(dir, testMAC, Minute, time.Time{}, farFuture)
+	hours, _ := Query(dir, testMAC, Hour, time.Time{}, farFuture)
+	if len(minutes) != 2 || len(hours) != 2 {
+		t.Errorf("rollups after retention: %d minutes, %d hours; want 2 and 2", len(minutes), len(hours))
+	}
+}
+
+// TestRollup tests that points are merged into buckets aligned to local
+// midnight
+func TestRollup(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	start := time.Date(2026, 3, 1, 23, 30, 0, 0, time.UTC) // 00:30 in loc
+	stats := func(v float64) map[string]sensor.Stats {
+		return map[string]sensor.Stats{"humidity": {Count: 1, Min: v, Max: v, Mean: v}}
+	}
+	points := []Point{
+		{Time: start, Values: stats(60)},
+		{Time: start.Add(20 * time.Minute), Values: stats(70)},
+		{Time: start.Add(40 * time.Minute), Values: stats(80)},
+	}
+
+	got := Rollup(points, 24*time.Hour, loc)
+	if len(got) != 1 || !got[0].Time.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, loc)) {
+		t.Fatalf("Rollup(24h) = %v", got)
+	}
+	if h := got[0].Values["humidity"]; h.Count != 3 || h.Min != 60 || h.Max != 80 || h.Mean != 70 {
+		t.Errorf("humidity = %+v", h)
+	}
+
+	if got := Rollup(points, 30*time.Minute, loc); len(got) != 2 || got[0].Values["humidity"].Mean != 65 || got[1].Values["humidity"].Mean != 80 {
+		t.Errorf("Rollup(30m) = %v", got)
+	}
+
+	// A day with a daylight saving change is still one bucket
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	for _, day := range []time.Time{
+		time.Date(2026, 3, 29, 0, 0, 0, 0, madrid),  // 02:00 CET → 03:00 CEST
+		time.Date(2026, 10, 25, 0, 0, 0, 0, madrid), // 03:00 CEST → 02:00 CET
+	} {
+		points := []Point{
+			{Time: day.Add(30 * time.Minute), Values: stats(60)},
+			{Time: day.Add(12 * time.Hour), Values: stats(70)},
+			{Time: day.Add(22*time.Hour + 30*time.Minute), Values: stats(80)},
+		}
+		got := Rollup(points, 24*time.Hour, madrid)
+		if len(got) != 1 || !got[0].Time.Equal(day) {
+			t.Errorf("Rollup(24h) on %s = %v, want one bucket at midnight", day.Format("2006-01-02"), got)
+		}
+	}
+	if got := Rollup([]Point{{Time: time.Date(2026, 3, 31, 10, 0, 0, 0, madrid)}}, 7*24*time.Hour, madrid); got[0].Time.In(madrid).Hour() != 0 {
+		t.Errorf("Rollup(7d) starts at %v, want local midnight", got[0].Time)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sensorsgo/history"
+	"sensorsgo/sensor"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useHistory sets up a sensors file with two sensors, a history with
+// three readings of the first one, and a fixed local time zone
+func useHistory(t *testing.T) {
+	t.Helper()
+	useSensorsFile(t)
+	local := time.Local
+	time.Local = time.FixedZone("CET", 3600)
+	t.Cleanup(func() { time.Local = local })
+
+	err := saveConfig(&Config{Sensors: []AuthorizedSensor{
+		{MAC: "C4:7C:8D:6A:1B:2E", Name: "Bandeja 1"},
+		{MAC: "AA:BB:CC:DD:EE:FF", Name: "Bandeja 2"},
+	}})
+	if err != nil {
+		t.Fatalf("saveConfig: %v", err)
+	}
+
+	store, err := history.Open(cfg.HistoryDir(), history.Options{})
+	if err != nil {
+		t.Fatalf("history.Open: %v", err)
+	}
+	defer store.Close()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, r := range []struct {
+		at                    time.Duration
+		temperature, humidity float64
+	}{
+		{0, 20, 50},
+		{30 * time.Second, 22, 60},
+		{10 * time.Minute, 24, 0}, // Without humidity
+	} {
+		data := &sensor.RuuviData{Temperature: &r.temperature, Timestamp: start.Add(r.at)}
+		if r.humidity != 0 {
+			data.Humidity = &r.humidity
+		}
+		if err := store.Add("C4:7C:8D:6A:1B:2E", data); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+}
+
+// TestHistoryQuery tests the validation of the history options, the
+// choice of resolution and the resolution of sensor names
+func TestHistoryQuery(t *testing.T) {
+	useHistory(t)
+
+	tests := []struct {
+		resolution string
+		bucket     time.Duration
+		want       string // Resolution, or the start of the error
+	}{
+		{"", 0, "1m"},
+		{"", 15 * time.Minute, "1m"},
+		{"", 2 * time.Hour, "1h"},
+		{"", 24 * time.Hour, "1h"},
+		{"", 90 * time.Second, "raw"},
+		{"raw", time.Hour, "raw"},
+		{"1h", 30 * time.Minute, "-bucket"},
+		{"", -time.Minute, "-bucket"},
+		{"5m", 0, "-res"},
+	}
+	for _, tt := range tests {
+		q, err := newHistoryQuery("Bandeja 1", "", "", time.Hour, tt.resolution, tt.bucket, "", "avg")
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = string(q.resolution)
+		}
+		if !strings.HasPrefix(got, tt.want) {
+			t.Errorf("-res %q -bucket %v: got %q, want %q", tt.resolution, tt.bucket, got, tt.want)
+		}
+	}
+
+	// Times without a zone are local
+	q, err := newHistoryQuery("bandeja 2, c4-7c-8d-6a-1b-2e", "2026-03-01 13:00", "2026-03-02", time.Hour, "", 0, "", "avg")
+	if err != nil {
+		t.Fatalf("newHistoryQuery: %v", err)
+	}
+	if !q.from.Equal(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)) || !q.to.Equal(time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("from %v, to %v", q.from, q.to)
+	}
+	if fmt.Sprint(q.macs) != "[AA:BB:CC:DD:EE:FF C4:7C:8D:6A:1B:2E]" {
+		t.Errorf("macs = %v", q.macs)
+	}
+	q, err = newHistoryQuery("", "", "2026-03-01T14:00:00+01:00", 2*time.Hour, "", 0, "", "avg")
+	if err != nil || !q.from.Equal(time.Date(2026, 3, 1, 11, 0, 0, 0, time.UTC)) || fmt.Sprint(q.macs) != "[C4:7C:8D:6A:1B:2E]" {
+		t.Errorf("-last with every sensor: %+v, %v", q, err)
+	}
+
+	for _, args := range [][]string{
+		{"Bandeja 9", "", "", "", ""},
+		{"", "ayer", "", "", ""},
+		{"", "2026-03-02", "2026-03-01", "", ""},
+		{"", "", "", "co2x", "avg"},
+		{"", "", "", "", "median"},
+		{"", "", "", "", ""},
+	} {
+		if _, err := newHistoryQuery(args[0], args[1], args[2], time.Hour, "", 0, args[3], args[4]); err == nil {
+			t.Errorf("newHistoryQuery(%q): expected error", args)
+		}
+	}
+}
+
+// TestHistoryColumns tests that a lone average keeps the field name
+func TestHistoryColumns(t *testing.T) {
+	q := &historyQuery{aggregates: []string{"avg"}}
+	if got := fmt.Sprint(q.columns([]string{"temperature", "humidity"})); got != "[temperature humidity]" {
+		t.Errorf("columns(avg) = %s", got)
+	}
+	q.aggregates = []string{"min", "avg"}
+	if got := fmt.Sprint(q.columns([]string{"temperature"})); got != "[temperature_min temperature_avg]" {
+		t.Errorf("columns(min,avg) = %s", got)
+	}
+}
+
+// TestHistoryFormats tests the table, CSV and JSON lines output
+func TestHistoryFormats(t *testing.T) {
+	useHistory(t)
+
+	tests := []struct {
+		format string
+		bucket time.Duration
+		agg    string
+		want   string
+	}{
+		{"csv", 0, "avg", `time,mac,name,temperature,humidity
+2026-03-01T12:00:00Z,C4:7C:8D:6A:1B:2E,Bandeja 1,20,50
+2026-03-01T12:00:30Z,C4:7C:8D:6A:1B:2E,Bandeja 1,22,60
+2026-03-01T12:10:00Z,C4:7C:8D:6A:1B:2E,Bandeja 1,24,
+`},
+		{"jsonl", time.Hour, "min,max,count", `{"time":"2026-03-01T12:00:00Z","mac":"C4:7C:8D:6A:1B:2E","name":"Bandeja 1","values":{"humidity":{"count":2,"max":60,"min":50},"temperature":{"count":3,"max":24,"min":20}}}
+`},
+		// Columns of the table compared without their padding
+		{"table", time.Hour, "avg,count", `HORA|SENSOR|temperature_avg|temperature_count|humidity_avg|humidity_count
+2026-03-01|13:00:00|Bandeja|1|22.00|3|55.00|2
+`},
+	}
+	for _, tt := range tests {
+		q, err := newHistoryQuery("C4:7C:8D:6A:1B:2E", "2026-03-01T12:00:00Z", "2026-03-01T13:00:00Z", 0, "raw", tt.bucket, "", tt.agg)
+		if err != nil {
+			t.Fatalf("newHistoryQuery: %v", err)
+		}
+		var out bytes.Buffer
+		if err := q.run(&out, tt.format); err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		got := out.String()
+		if tt.format == "table" {
+			got = ""
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				got += strings.Join(strings.Fields(line), "|") + "\n"
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s =\n%s\nwant\n%s", tt.format, got, tt.want)
+		}
+	}
+
+	// Only the requested fields, and a message instead of an empty table
+	q, _ := newHistoryQuery("Bandeja 2", "2026-03-01T12:00:00Z", "2026-03-01T13:00:00Z", 0, "raw", 0, "humidity", "avg")
+	var out bytes.Buffer
+	q.run(&out, "table")
+	if !strings.HasPrefix(out.String(), "Sin datos") {
+		t.Errorf("empty table = %q", out.String())
+	}
+	q, _ = newHistoryQuery("Bandeja 1", "2026-03-01T12:00:00Z", "2026-03-01T13:00:00Z", 0, "raw", 0, "humidity", "avg")
+	out.Reset()
+	q.run(&out, "csv")
+	if got := strings.Split(out.String(), "\n")[0]; got != "time,mac,name,humidity" {
+		t.Errorf("CSV header with -fields humidity = %q", got)
+	}
+}
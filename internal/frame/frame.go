@@ -0,0 +1,63 @@
+// Package frame implements the length and CRC framing of the records in
+// the segment files of the queue and the history. A record is a 4-byte
+// big-endian length, the CRC-32 (IEEE) of the payload and the payload.
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// HeaderLen is the length of the header before each payload
+	HeaderLen = 8
+	// MaxSize guards against reading garbage lengths from a damaged file
+	MaxSize = 16 << 20
+)
+
+// ErrCorrupt is returned for a torn or damaged record, which is also how a
+// record being appended looks
+var ErrCorrupt = errors.New("corrupt record")
+
+// Encode frames data for appending to a segment
+func Encode(data []byte) []byte {
+	framed := make([]byte, HeaderLen+len(data))
+	binary.BigEndian.PutUint32(framed[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(framed[4:8], crc32.ChecksumIEEE(data))
+	copy(framed[HeaderLen:], data)
+	return framed
+}
+
+// Read decodes the record at offset off of r, returning its payload and
+// the offset of the following record. It returns io.EOF at the end of r.
+func Read(r io.Reader, off int64) ([]byte, int64, error) {
+	var header [HeaderLen]byte
+	n, err := io.ReadFull(r, header[:])
+	if err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		if n > 0 {
+			return nil, 0, ErrCorrupt
+		}
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	if size > MaxSize {
+		return nil, 0, ErrCorrupt
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, ErrCorrupt
+	}
+	if crc32.ChecksumIEEE(data) != sum {
+		return nil, 0, ErrCorrupt
+	}
+
+	return data, off + HeaderLen + int64(size), nil
+}
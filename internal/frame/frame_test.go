@@ -0,0 +1,42 @@
+package frame
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestReadEncode tests reading records back and detecting damaged ones
+func TestReadEncode(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(Encode([]byte("first")))
+	buf.Write(Encode(nil))
+	r := bytes.NewReader(buf.Bytes())
+
+	data, next, err := Read(r, 0)
+	if err != nil || string(data) != "first" || next != HeaderLen+5 {
+		t.Fatalf("Read = %q, %d, %v", data, next, err)
+	}
+	if data, next, err = Read(r, next); err != nil || len(data) != 0 || next != 2*HeaderLen+5 {
+		t.Fatalf("Read empty record = %q, %d, %v", data, next, err)
+	}
+	if _, _, err := Read(r, next); err != io.EOF {
+		t.Errorf("Read at the end = %v, want io.EOF", err)
+	}
+
+	framed := Encode([]byte("second"))
+	damaged := append([]byte(nil), framed...)
+	damaged[len(damaged)-1] ^= 0xFF
+	huge := append([]byte(nil), framed...)
+	huge[0] = 0xFF
+	for name, b := range map[string][]byte{
+		"torn header":  framed[:HeaderLen-2],
+		"torn payload": framed[:len(framed)-1],
+		"bad checksum": damaged,
+		"huge length":  huge,
+	} {
+		if _, _, err := Read(bytes.NewReader(b), 0); err != ErrCorrupt {
+			t.Errorf("%s: err = %v, want ErrCorrupt", name, err)
+		}
+	}
+}
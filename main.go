@@ -1,50 +1,59 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/binary"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"sensorsgo/history"
+	"sensorsgo/mqtt"
+	"sensorsgo/scanner"
+	"sensorsgo/sensor"
+	"sensorsgo/settings"
+	"sensorsgo/sink"
+	"sensorsgo/stream"
 	"sensorsgo/ui"
+	"sort"
+	"strings"
 	"sync"
 	"time"
-
-	"tinygo.org/x/bluetooth"
-)
-
-const (
-	configFile   = "authorized_sensors.json"
-	apiURL       = "https://go.larvai.com/api/v1/sensors"
-	sendInterval = 5 * time.Minute
 )
 
 var (
 	terminalUI    *ui.TerminalUI
+	console       io.Writer = os.Stdout // Mensajes y UI del monitor; stderr si el destino stdout usa la salida estándar
 	lastSeenMap   map[string]time.Time
 	lastSeenMutex sync.Mutex
-	onlineTimeout = 2 * time.Minute // Sensor offline si no se ve en 2 minutos
-	apiKey        string            // API key para autenticación
+	cfg           *settings.Settings         // Configuración efectiva (archivo, entorno y flags)
+	apiKey        string                     // API key para autenticación
+	hostname      string                     // Hostname incluido en cada lectura
+	sinkRunners   []*sink.Runner             // Destinos de las lecturas, cada uno con su cola
+	decoders      = sensor.DefaultRegistry() // Decoders de sensores BLE soportados
+	mqttPublisher *mqtt.Publisher            // Publicación MQTT (nil si está desactivada)
+	historyStore  *history.Store             // Histórico local de lecturas (nil si está desactivado)
+	sensorOnline  = make(map[string]bool)    // Último estado online de cada sensor, para avisar de los cambios
+	eventHub      = stream.NewHub()          // Eventos en vivo para /stream
+
+	latestReadings = make(map[string]*sensor.RuuviData) // Última lectura de cada sensor
+	latestMutex    sync.Mutex
+
+	startedAt        = time.Now()
+	lastSyncAt       time.Time // Último cierre de un intervalo de envío
+	lastSyncReadings int       // Lecturas encoladas en ese cierre
+	lastSyncMutex    sync.Mutex
 )
 
-// RuuviData contiene los datos parseados del sensor
-type RuuviData struct {
-	Temperature float64
-	Humidity    float64
-	Pressure    float64
-	Battery     uint16
-	TxPower     int8
-	MAC         string
-}
-
 // AuthorizedSensor representa un sensor autorizado
 type AuthorizedSensor struct {
-	MAC         string    `json:"mac"`
-	Name        string    `json:"name"`
+	MAC          string    `json:"mac"`
+	Name         string    `json:"name"`
 	RegisteredAt time.Time `json:"registered_at"`
+	Disabled     bool      `json:"disabled,omitempty"` // Registrado pero sin leer
 }
 
 // Config contiene la configuración de sensores autorizados
@@ -52,159 +61,258 @@ type Config struct {
 	Sensors []AuthorizedSensor `json:"authorized_sensors"`
 }
 
-// SensorPayload representa los datos a enviar a la API
-type SensorPayload struct {
-	Temperature float64 `json:"temperature"`
-	Humidity    float64 `json:"humidity"`
-	Battery     uint16  `json:"battery"`
-	Hostname    string  `json:"hostname"`
-}
-
 func main() {
-	// Flags de línea de comandos
+	// Flags de línea de comandos; los ajustes también pueden venir del
+	// archivo de configuración y de variables SENSORGO_*
+	configPath := flag.String("config", "", fmt.Sprintf("Archivo de configuración TOML (por defecto $%s o %s si existe)", settings.EnvConfig, settings.DefaultFile))
 	reregister := flag.Bool("reregister", false, "Re-registrar sensores (sobrescribe la lista actual)")
+	backfillFile := flag.String("backfill", "", "Enviar a la API las lecturas de un archivo JSON lines en orden cronológico y salir")
+	cmdline := settings.Bind(flag.CommandLine)
 	flag.Parse()
 
-	// Cargar API key
-	err := loadAPIKey()
+	// Precedencia: valores por defecto < archivo < entorno < flags
+	path, required := *configPath, true
+	if path == "" {
+		path = os.Getenv(settings.EnvConfig)
+	}
+	if path == "" {
+		path, required = settings.DefaultFile, false
+	}
+	var err error
+	cfg, err = settings.Load(path, required, os.Environ())
+	if err == nil {
+		err = cmdline.Apply(cfg)
+	}
+	if err == nil {
+		if cfg.Scanner.Replay != "" {
+			cfg.Scanner.Backend = "replay"
+		}
+		err = cfg.Validate()
+	}
 	if err != nil {
-		fmt.Printf("❌ Error: %v\n", err)
-		fmt.Println("\n💡 Crea un archivo ~/.insectius-monitor con tu API key:")
-		fmt.Println("   echo 'tu-api-key-aqui' > ~/.insectius-monitor")
-		fmt.Println("   chmod 600 ~/.insectius-monitor")
+		fmt.Printf("❌ Configuración inválida:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	// config print: mostrar la configuración efectiva y salir
+	if flag.Arg(0) == "config" {
+		if flag.Arg(1) != "print" {
+			fmt.Println("Uso: insectius-monitor [flags] config print")
+			os.Exit(2)
+		}
+		cfg.Write(os.Stdout)
 		return
 	}
 
-	adapter := bluetooth.DefaultAdapter
+	// sensors list|add|remove|rename|disable|enable: editar la lista de
+	// sensores autorizados y salir
+	if flag.Arg(0) == "sensors" {
+		os.Exit(runSensorsCommand(flag.Args()[1:]))
+	}
 
-	fmt.Println("🔍 DEBUG: Intentando habilitar adaptador Bluetooth...")
+	// history: consultar el histórico local y salir
+	if flag.Arg(0) == "history" {
+		os.Exit(runHistoryCommand(flag.Args()[1:]))
+	}
 
-	// Intentar habilitar Bluetooth con reintentos más largos
-	maxRetries := 10
-	for i := 0; i < maxRetries; i++ {
-		fmt.Printf("   Intento %d/%d...\n", i+1, maxRetries)
-		err = adapter.Enable()
-		if err == nil {
-			fmt.Println("✅ DEBUG: adapter.Enable() exitoso")
+	// Con el destino stdout la salida estándar queda para las lecturas; los
+	// mensajes y la UI van a stderr
+	for _, sinkConfig := range cfg.Sinks {
+		if sinkConfig.Type == "stdout" {
+			console = os.Stderr
 			break
 		}
-		fmt.Printf("   Error: %v\n", err)
-		if i < maxRetries-1 {
-			fmt.Printf("⚠️  Reintentando en 3 segundos...\n")
-			time.Sleep(3 * time.Second)
-		}
 	}
+
+	hostname, _ = os.Hostname()
+	if hostname == "" {
+		hostname = "unknown"
+	}
+
+	// Cargar API key
+	err = loadAPIKey()
 	if err != nil {
-		fmt.Printf("❌ Error habilitando Bluetooth después de %d intentos: %v\n", maxRetries, err)
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		fmt.Fprintln(console, "\n💡 Crea un archivo ~/.insectius-monitor con tu API key:")
+		fmt.Fprintln(console, "   echo 'tu-api-key-aqui' > ~/.insectius-monitor")
+		fmt.Fprintln(console, "   chmod 600 ~/.insectius-monitor")
 		return
 	}
 
-	// Esperar más tiempo a que Bluetooth esté completamente listo
-	fmt.Println("⏳ Esperando 10 segundos para que Bluetooth esté listo...")
-	time.Sleep(10 * time.Second)
-	fmt.Println("✅ Espera completada")
+	// Modo backfill: no necesita Bluetooth
+	if *backfillFile != "" {
+		if err := runBackfill(*backfillFile); err != nil {
+			fmt.Printf("❌ Error en backfill: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	scanOpts := scanner.Options{
+		HCIDevice:      cfg.Scanner.HCIDevice,
+		HCIUserChannel: cfg.Scanner.HCIUserChannel,
+		ReplayFile:     cfg.Scanner.Replay,
+		ReplaySpeed:    cfg.Scanner.ReplaySpeed,
+		IngestFile:     cfg.Scanner.Ingest,
+		EnableRetries:  cfg.Bluetooth.EnableRetries,
+		ScanRetries:    cfg.Bluetooth.ScanRetries,
+		ReadyDelay:     cfg.Bluetooth.ReadyDelay,
+	}
+	var sc scanner.Scanner
+	sc, err = scanner.New(cfg.Scanner.Backend, scanOpts)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		os.Exit(2)
+	}
+	fmt.Fprintf(console, "📡 Backend de escaneo: %s\n", cfg.Scanner.Backend)
+
+	// Grabar la sesión para poder reproducirla con -replay
+	if cfg.Scanner.Record != "" {
+		recorder, err := scanner.Record(sc, cfg.Scanner.Record)
+		if err != nil {
+			fmt.Fprintf(console, "❌ Error abriendo archivo de grabación: %v\n", err)
+			os.Exit(1)
+		}
+		defer recorder.Close()
+		sc = recorder
+		fmt.Fprintf(console, "⏺️  Grabando anuncios en %s\n", cfg.Scanner.Record)
+	}
+	sources := []scanner.Scanner{sc}
+
+	// Receptor HTTP para Ruuvi Gateways fuera del alcance Bluetooth
+	if cfg.Gateway.Listen != "" {
+		sources = append(sources, scanner.NewGatewayScanner(cfg.Gateway.Listen, cfg.Gateway.Token))
+		fmt.Fprintf(console, "🌐 Escuchando Ruuvi Gateway en %s\n", cfg.Gateway.Listen)
+	}
+	for _, source := range sources {
+		scanner.SetLog(source, console)
+	}
 
 	// Verificar si existe el archivo de configuración
 	config, firstRun := loadConfig()
 
 	if *reregister {
-		fmt.Println("🔄 Modo re-registro activado. Se sobrescribirá la lista actual de sensores.")
+		fmt.Fprintln(console, "🔄 Modo re-registro activado. Se sobrescribirá la lista actual de sensores.")
 		firstRun = true
 		config = &Config{Sensors: []AuthorizedSensor{}}
 	}
 
 	if firstRun {
-		fmt.Println("🆕 Primera ejecución detectada.")
-		fmt.Println("🔍 Escaneando sensores RuuviTag para registrarlos...")
-		fmt.Println("⏱️  Escaneando durante 10 segundos...")
+		fmt.Fprintln(console, "🆕 Primera ejecución detectada.")
 
-		foundSensors := make(map[string]AuthorizedSensor)
-
-		// Timer para detener el escaneo después de 10 segundos
-		go func() {
-			time.Sleep(10 * time.Second)
-			adapter.StopScan()
-		}()
-
-		// Escanear hasta que se detenga
-		err = adapter.Scan(func(adapter *bluetooth.Adapter, device bluetooth.ScanResult) {
-			if isRuuviTag(device) {
-				mac := device.Address.String()
-				if _, exists := foundSensors[mac]; !exists {
-					sensor := AuthorizedSensor{
-						MAC:         mac,
-						Name:        device.LocalName(),
-						RegisteredAt: time.Now(),
-					}
-					foundSensors[mac] = sensor
-					fmt.Printf("✅ Sensor registrado: %s (%s)\n", sensor.Name, sensor.MAC)
-				}
-			}
-		})
-
-		if err != nil {
-			fmt.Printf("❌ Error escaneando: %v\n", err)
-			return
-		}
-
-		// Guardar sensores encontrados
-		for _, sensor := range foundSensors {
-			config.Sensors = append(config.Sensors, sensor)
-		}
+		// Escanear y elegir los sensores según registration_mode
+		config.Sensors = append(config.Sensors, registerSensors(sources)...)
 
 		if len(config.Sensors) == 0 {
-			fmt.Println("❌ No se encontraron sensores RuuviTag. Asegúrate de que estén encendidos y cerca.")
+			fmt.Fprintln(console, "❌ No se encontraron sensores compatibles. Asegúrate de que estén encendidos y cerca.")
 			return
 		}
 
 		err = saveConfig(config)
 		if err != nil {
-			fmt.Printf("❌ Error guardando configuración: %v\n", err)
+			fmt.Fprintf(console, "❌ Error guardando configuración: %v\n", err)
 			return
 		}
 
-		fmt.Printf("\n✅ Registro completado. %d sensores autorizados guardados en %s\n", len(config.Sensors), configFile)
-		fmt.Println("\n📋 Sensores autorizados:")
+		fmt.Fprintf(console, "\n✅ Registro completado. %d sensores autorizados guardados en %s\n", len(config.Sensors), cfg.SensorsFile)
+		fmt.Fprintln(console, "\n📋 Sensores autorizados:")
 		for i, sensor := range config.Sensors {
-			fmt.Printf("   %d. %s (%s)\n", i+1, sensor.Name, sensor.MAC)
+			fmt.Fprintf(console, "   %d. %s (%s)\n", i+1, sensor.Name, sensor.MAC)
 		}
-		fmt.Println("\n🔒 A partir de ahora, solo se leerán datos de estos sensores.")
-		fmt.Println("💡 Para re-registrar sensores, ejecuta: go run main.go -reregister")
+		fmt.Fprintln(console, "\n🔒 A partir de ahora, solo se leerán datos de estos sensores.")
+		fmt.Fprintln(console, "💡 Para re-registrar sensores, ejecuta: go run main.go -reregister")
 		return
 	}
 
-	// Modo normal: iniciar terminal UI y escaneo
-	startMonitoring(adapter, config)
+	// Modo normal: iniciar terminal UI y escaneo; la configuración se
+	// recarga en caliente con SIGHUP o al cambiar los archivos
+	reload := &reloader{path: path, required: required, cmdline: cmdline}
+	startMonitoring(sources, config, reload)
 }
 
 // startMonitoring inicia el monitoreo de sensores y la GUI
-func startMonitoring(adapter *bluetooth.Adapter, config *Config) {
-	fmt.Printf("🔒 Modo seguro: solo se leerán %d sensores autorizados\n", len(config.Sensors))
-	fmt.Println("📋 Sensores autorizados:")
+func startMonitoring(sources []scanner.Scanner, config *Config, reload *reloader) {
+	fmt.Fprintf(console, "🔒 Modo seguro: solo se leerán %d sensores autorizados\n", enabledSensors(config))
+	fmt.Fprintln(console, "📋 Sensores autorizados:")
 	for i, sensor := range config.Sensors {
-		fmt.Printf("   %d. %s (%s)\n", i+1, sensor.Name, sensor.MAC)
+		if sensor.Disabled {
+			fmt.Fprintf(console, "   %d. %s (%s) ⏸️  desactivado\n", i+1, sensor.Name, sensor.MAC)
+			continue
+		}
+		fmt.Fprintf(console, "   %d. %s (%s)\n", i+1, sensor.Name, sensor.MAC)
 	}
-	fmt.Printf("\n🔍 Escaneando sensores y enviando datos a la API cada %v...\n", sendInterval)
+	fmt.Fprintf(console, "\n🔍 Escaneando sensores y enviando datos cada %v...\n", cfg.SendInterval)
 
-	// Inicializar mapa de última vez visto
-	lastSeenMap = make(map[string]time.Time)
+	// Abrir los destinos: cada uno guarda las lecturas en su propia cola
+	// persistente antes de enviarlas
+	var err error
+	env := sink.Env{APIURL: cfg.APIURL, APIKey: apiKey, Hostname: hostname, Stdout: os.Stdout, Console: console}
+	sinkRunners, err = sink.Open(cfg.Sinks, env, cfg.DataDir)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error abriendo destinos: %v\n", err)
+		return
+	}
+	for _, runner := range sinkRunners {
+		fmt.Fprintf(console, "📤 Destino: %s\n", runner.Name())
+		if pending := runner.Status().Pending; pending > 0 {
+			fmt.Fprintf(console, "📦 %s: %d lectura(s) pendientes de envío recuperadas de %s\n", runner.Name(), pending, cfg.DataDir)
+		}
+		runner.SetPolicy(cfg.RetryPolicy())
+		runner.Logf = func(format string, args ...interface{}) {
+			addLog(fmt.Sprintf(format, args...))
+		}
+		runner.OnStatus = func(status sink.Status) {
+			updateSinkStatus()
+			publishSync(status)
+		}
+	}
+	// Histórico local de todas las lecturas
+	if cfg.History.Enabled {
+		historyStore, err = history.Open(cfg.HistoryDir(), history.Options{
+			RawRetention:    cfg.History.RawRetention,
+			MinuteRetention: cfg.History.MinuteRetention,
+			HourRetention:   cfg.History.HourRetention,
+		})
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  Histórico local desactivado: %v\n", err)
+			historyStore = nil
+		} else {
+			fmt.Fprintf(console, "🗄️  Guardando el histórico de lecturas en %s\n", cfg.HistoryDir())
+		}
+	}
 
-	// Crear mapa de sensores autorizados para búsqueda rápida
-	authorizedMACs := make(map[string]bool)
-	for _, sensor := range config.Sensors {
-		authorizedMACs[sensor.MAC] = true
+	// Publicación MQTT para Home Assistant / Node-RED
+	if cfg.MQTT.Broker != "" {
+		node, err := os.Hostname()
+		if err != nil {
+			node = "sensorgo"
+		}
+		mqttPublisher = mqtt.NewPublisher(cfg.MQTT.TopicPrefix, cfg.MQTT.DiscoveryPrefix, node)
+		mqttPublisher.Connect(mqtt.Options{Broker: cfg.MQTT.Broker, Username: cfg.MQTT.User, Password: cfg.MQTT.Password})
+		fmt.Fprintf(console, "📨 Publicando lecturas en MQTT %s (%s/...)\n", cfg.MQTT.Broker, cfg.MQTT.TopicPrefix)
+	}
+
+	// Inicializar mapa de última vez visto, partiendo de lo guardado en
+	// la ejecución anterior
+	lastSeenMap = make(map[string]time.Time)
+	if _, seen := readLastSeen(); seen != nil {
+		lastSeenMap = seen
 	}
 
-	// Mapa para almacenar las últimas lecturas de cada sensor
-	var lastReadings = make(map[string]*RuuviData)
+	// Sensores autorizados y ajustes que se pueden recargar sin reiniciar
+	currentSensors.Store(newSensorSet(config))
+	onlineTimeout.Store(int64(cfg.OnlineTimeout))
+
+	// Lecturas acumuladas de cada sensor desde la última sincronización
+	var intervals = make(map[string]*sensor.Aggregate)
 	var mu sync.Mutex
 
 	// Variable para controlar si es la primera sincronización
 	firstSync := true
 
-	// Goroutine para enviar datos (inmediato y luego cada 5 minutos)
+	// Goroutine para enviar datos (al poco de arrancar y luego cada intervalo)
 	go func() {
-		addLog(fmt.Sprintf("⏰ Sincronización automática cada %v", sendInterval))
+		addLog(fmt.Sprintf("⏰ Sincronización automática cada %v", cfg.SendInterval))
 
 		// Función para sincronizar
 		syncData := func() {
@@ -215,112 +323,181 @@ func startMonitoring(adapter *bluetooth.Adapter, config *Config) {
 				addLog("🔄 Iniciando sincronización programada...")
 			}
 
+			// Cerrar el intervalo actual y empezar uno nuevo
 			mu.Lock()
-			count := 0
-			for mac, data := range lastReadings {
-				if data != nil {
-					count++
-					go sendToAPI(mac, data)
+			completed := intervals
+			intervals = make(map[string]*sensor.Aggregate)
+			mu.Unlock()
+
+			// Todas las lecturas se encolan juntas para enviarlas en un solo
+			// lote; los sensores retirados en una recarga ya no se envían
+			sensors := currentSensors.Load()
+			readings := make([]sink.Reading, 0, len(completed))
+			for mac, agg := range completed {
+				if !sensors.authorized[mac] {
+					continue
 				}
+				readings = append(readings, sink.Reading{
+					MAC:     mac,
+					Name:    sensors.names[mac],
+					Payload: sink.NewAggregatePayload(agg, hostname),
+				})
+			}
+			lastSyncMutex.Lock()
+			lastSyncAt, lastSyncReadings = time.Now(), len(readings)
+			lastSyncMutex.Unlock()
+
+			if err := enqueueReadings(readings); err != nil {
+				fmt.Fprintf(console, "⚠️  Error guardando lecturas en la cola: %v\n", err)
+				addLog("❌ Error guardando lecturas en la cola")
 			}
-			mu.Unlock()
 
-			if count == 0 {
+			if count := len(readings); count == 0 {
 				addLog("⚠️  No hay datos para sincronizar")
 			} else {
 				addLog(fmt.Sprintf("📤 Sincronizando %d sensor(es)", count))
 			}
 		}
 
-		// Esperar 10 segundos para recolectar datos, luego primera sincronización
-		time.Sleep(10 * time.Second)
+		// Esperar a recolectar datos, luego primera sincronización
+		time.Sleep(cfg.FirstSyncDelay)
 		syncData()
 
-		// Luego continuar cada 5 minutos
-		ticker := time.NewTicker(sendInterval)
+		// Luego continuar cada intervalo de envío, que puede cambiar al
+		// recargar la configuración
+		ticker := time.NewTicker(cfg.SendInterval)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			syncData()
+		for {
+			select {
+			case <-ticker.C:
+				syncData()
+			case interval := <-sendIntervalChanges:
+				ticker.Reset(interval)
+			}
 		}
 	}()
 
-	// Goroutine para verificar estado de sensores cada 5 minutos
+	// Goroutines que vacían la cola de cada destino en orden
+	for _, runner := range sinkRunners {
+		go runner.Run(context.Background())
+	}
+
+	// Goroutine para verificar estado de sensores; cada 30 segundos para
+	// que el paso a offline (UI y MQTT) no tarde más de lo necesario
 	go func() {
-		ticker := time.NewTicker(sendInterval)
+		ticker := time.NewTicker(30 * time.Second)
 		defer ticker.Stop()
 
 		for range ticker.C {
-			updateSensorStatus(config)
+			updateSensorStatus()
+			saveLastSeen()
 		}
 	}()
 
-	// Goroutine para escanear dispositivos
-	go func() {
-		addLog("🔍 Iniciando escaneo de sensores...")
-		fmt.Println("🔍 DEBUG: Iniciando goroutine de escaneo...")
+	// Goroutine para cerrar los agregados del histórico y aplicar la
+	// retención
+	if historyStore != nil {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
 
-		// Intentar escaneo con reintentos
-		maxScanRetries := 5
-		for attempt := 0; attempt < maxScanRetries; attempt++ {
-			fmt.Printf("🔍 DEBUG: Intento de escaneo %d/%d\n", attempt+1, maxScanRetries)
+			for range ticker.C {
+				if err := historyStore.Maintain(time.Now()); err != nil {
+					addLog(fmt.Sprintf("❌ Error en el histórico: %v", err))
+				}
+			}
+		}()
+	}
 
-			err := adapter.Scan(func(adapter *bluetooth.Adapter, device bluetooth.ScanResult) {
-			// Buscar RuuviTag en el nombre o en manufacturer data
-			if isRuuviTag(device) {
-				mac := device.Address.String()
+	// Goroutine para recargar la configuración con SIGHUP o al cambiar
+	// los archivos
+	go reload.run(context.Background())
 
-				// Verificar si el sensor está autorizado
-				if !authorizedMACs[mac] {
-					// Sensor no autorizado, ignorar
-					return
-				}
+	// Servidor HTTP local con las métricas para Prometheus, la API local
+	// y los eventos en vivo
+	if cfg.HTTP.Listen != "" {
+		go serveHTTP(context.Background(), cfg.HTTP.Listen)
+		fmt.Fprintf(console, "📈 Servidor HTTP local en %s (/metrics, /sensors, /status, /stream)\n", cfg.HTTP.Listen)
+	}
 
-				// Parsear datos del manufacturer data
-				if data := parseRuuviData(device); data != nil {
-					// Marcar sensor como online
-					markSensorOnline(mac)
+	// Procesar cada anuncio recibido por el backend de escaneo
+	handleAdvertisement := func(adv *sensor.Advertisement) {
+		mac := adv.Address
+		sensors := currentSensors.Load()
 
-					// Actualizar última lectura
-					mu.Lock()
-					lastReadings[mac] = data
-					mu.Unlock()
+		advertisementsSeen.Inc()
 
-					sensorName := device.LocalName()
-					if sensorName == "" {
-						sensorName = mac[:17] // Usar MAC si no hay nombre
-					}
+		// Verificar si el sensor está autorizado
+		if !sensors.authorized[mac] {
+			// Sensor no autorizado, ignorar; los desactivados no cuentan
+			if _, registered := sensors.names[mac]; !registered && isSupportedSensor(adv) {
+				unauthorizedSeen.Inc()
+			}
+			return
+		}
 
-					addLog(fmt.Sprintf("📡 %s detectado", sensorName))
-					addLog(fmt.Sprintf("📊 Datos: %.1f°C, %.1f%% humedad, %dmV", data.Temperature, data.Humidity, data.Battery))
+		// Decodificar con el decoder que corresponda (Ruuvi, BTHome, ATC, ...)
+		data, err := decoders.Decode(adv)
+		if err != nil {
+			parseFailures.Inc()
+			return
+		}
 
-					// Actualizar estado de sensores
-					updateSensorStatus(config)
+		// Marcar sensor como online
+		markSensorOnline(mac)
 
-					fmt.Printf("\n📡 Sensor: %s\n", device.LocalName())
-					fmt.Printf("   🌡️  Temperatura: %.2f °C\n", data.Temperature)
-					fmt.Printf("   💧 Humedad: %.2f %%\n", data.Humidity)
-					fmt.Printf("   📊 Presión: %.2f hPa\n", data.Pressure)
-					fmt.Printf("   🔋 Batería: %d mV\n", data.Battery)
-				}
+		// Guardar la lectura en el histórico local
+		if historyStore != nil {
+			if err := historyStore.Add(mac, data); err != nil {
+				addLog(fmt.Sprintf("❌ Error guardando en el histórico: %v", err))
 			}
-			})
+		}
 
-			if err != nil {
-				fmt.Printf("❌ Error escaneando (intento %d): %v\n", attempt+1, err)
-				addLog(fmt.Sprintf("❌ Error en escaneo: %v", err))
+		// Actualizar última lectura
+		latestMutex.Lock()
+		previous := latestReadings[mac]
+		latestReadings[mac] = data
+		latestMutex.Unlock()
 
-				if attempt < maxScanRetries-1 {
-					fmt.Printf("⏳ Esperando 5 segundos antes de reintentar...\n")
-					time.Sleep(5 * time.Second)
-					continue
-				}
-			} else {
-				// Scan terminó sin error (no debería pasar normalmente)
-				break
+		mu.Lock()
+		if intervals[mac] == nil {
+			intervals[mac] = sensor.NewAggregate()
+		}
+		intervals[mac].Add(data)
+		mu.Unlock()
+
+		sensorName := adv.LocalName
+		if sensorName == "" {
+			sensorName = mac // Usar MAC si no hay nombre
+		}
+
+		addLog(fmt.Sprintf("📡 %s detectado", sensorName))
+		logCounterChanges(sensorName, previous, data)
+		addLog(fmt.Sprintf("📊 Datos: %s", formatValues(data.Temperature, data.Humidity, data.Battery)))
+		if air := formatAirQuality(data); air != "" {
+			addLog(fmt.Sprintf("🌬️  Aire: %s", air))
+		}
+
+		// Publicar en MQTT con el nombre registrado del sensor
+		if mqttPublisher != nil {
+			if err := mqttPublisher.PublishReading(mac, sensors.names[mac], data); err != nil {
+				addLog(fmt.Sprintf("❌ Error publicando en MQTT: %v", err))
 			}
 		}
-		fmt.Println("❌ DEBUG: Todos los intentos de escaneo fallaron")
+		publishReading(mac, sensors.names[mac], data)
+
+		// Actualizar estado de sensores
+		updateSensorStatus()
+
+		fmt.Fprintf(console, "\n📡 Sensor: %s (%s)\n", adv.LocalName, data.Model)
+		printReading(data)
+	}
+
+	// Goroutine para escanear dispositivos
+	go func() {
+		addLog("🔍 Iniciando escaneo de sensores...")
+		scanSources(context.Background(), sources, handleAdvertisement)
 	}()
 
 	// Iniciar terminal UI
@@ -329,105 +506,237 @@ func startMonitoring(adapter *bluetooth.Adapter, config *Config) {
 
 // startTerminalUI inicia la interfaz de terminal
 func startTerminalUI() {
-	terminalUI = ui.NewTerminalUI()
+	terminalUI = ui.NewTerminalUI(console)
 	terminalUI.Start()
 
 	// Block forever
 	select {}
 }
 
-// sendToAPI envía los datos del sensor a la API
-func sendToAPI(sensorUUID string, data *RuuviData) {
-	addLog(fmt.Sprintf("📤 Enviando datos a la API (Temp: %.1f°C, Hum: %.1f%%, Bat: %dmV)", data.Temperature, data.Humidity, data.Battery))
+// scanStatus es el estado de una fuente de anuncios, para /status
+type scanStatus struct {
+	Source            string     `json:"source"`
+	State             string     `json:"state"` // starting, scanning, restarting o finished
+	LastError         string     `json:"last_error,omitempty"`
+	Restarts          int        `json:"restarts"`
+	LastAdvertisement *time.Time `json:"last_advertisement"`
+}
+
+var (
+	scanStatuses []*scanStatus // Una por fuente, en el orden de scanSources
+	scanMutex    sync.Mutex
+)
 
-	// Obtener hostname del sistema
-	hostname, err := os.Hostname()
+// setScanState cambia el estado de una fuente; err se guarda si no es nil
+func setScanState(status *scanStatus, state string, err error) {
+	scanMutex.Lock()
+	defer scanMutex.Unlock()
+	status.State = state
 	if err != nil {
-		hostname = "unknown"
+		status.LastError = err.Error()
 	}
+	if state == "restarting" {
+		status.Restarts++
+	}
+}
 
-	payload := SensorPayload{
-		Temperature: data.Temperature,
-		Humidity:    data.Humidity,
-		Battery:     data.Battery,
-		Hostname:    hostname,
+// sourceName nombra una fuente de anuncios en /status
+func sourceName(sc scanner.Scanner) string {
+	if _, ok := sc.(*scanner.GatewayScanner); ok {
+		return "gateway"
 	}
+	return cfg.Scanner.Backend
+}
 
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("⚠️  Error serializando datos para %s: %v\n", sensorUUID, err)
-		addLog("❌ Error serializando datos")
-		updateGUIStatus(false)
-		return
+// scanSources recibe los anuncios de todas las fuentes (escáner BLE,
+// Ruuvi Gateway) hasta que ctx termina. Cada fuente se reinicia por
+// separado si su escaneo se detiene.
+func scanSources(ctx context.Context, sources []scanner.Scanner, handle func(*sensor.Advertisement)) {
+	statuses := make([]*scanStatus, len(sources))
+	for i, sc := range sources {
+		statuses[i] = &scanStatus{Source: sourceName(sc), State: "starting"}
+	}
+	scanMutex.Lock()
+	scanStatuses = statuses
+	scanMutex.Unlock()
+
+	var wg sync.WaitGroup
+	for i, sc := range sources {
+		wg.Add(1)
+		go func(sc scanner.Scanner, status *scanStatus) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				ads, err := sc.Start(ctx)
+				if err == nil {
+					setScanState(status, "scanning", nil)
+					for adv := range ads {
+						now := time.Now()
+						scanMutex.Lock()
+						status.LastAdvertisement = &now
+						scanMutex.Unlock()
+						handle(adv)
+					}
+					err = sc.Err()
+				}
+
+				// Las fuentes finitas (-replay, JSON por stdin) terminan al agotarse
+				if errors.Is(err, scanner.ErrExhausted) {
+					setScanState(status, "finished", nil)
+					fmt.Fprintln(console, "⏹️  Fin de los datos de entrada")
+					addLog("⏹️  Fin de los datos de entrada")
+					return
+				}
+				if err != nil {
+					fmt.Fprintf(console, "❌ Error escaneando: %v\n", err)
+					addLog(fmt.Sprintf("❌ Error en escaneo: %v", err))
+				}
+				if ctx.Err() != nil {
+					return
+				}
+
+				scanRestarts.Inc()
+				setScanState(status, "restarting", err)
+				fmt.Fprintln(console, "⏳ Reiniciando escaneo en 5 segundos...")
+				select {
+				case <-time.After(5 * time.Second):
+				case <-ctx.Done():
+				}
+			}
+		}(sc, statuses[i])
+	}
+	wg.Wait()
+}
+
+// enqueueReadings guarda los resúmenes de un intervalo (última lectura y
+// estadísticas) en la cola de cada destino
+func enqueueReadings(readings []sink.Reading) error {
+	var errs []error
+	for _, runner := range sinkRunners {
+		if err := runner.Enqueue(readings...); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", runner.Name(), err))
+		}
 	}
 
-	url := fmt.Sprintf("%s/%s", apiURL, sensorUUID)
+	updatePendingStatus()
+	return errors.Join(errs...)
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+// loadBackfill lee lecturas en formato JSON lines ({"mac": ..., "payload": {...}})
+// y las devuelve ordenadas cronológicamente por measured_at
+func loadBackfill(path string) ([]sink.Reading, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		fmt.Printf("⚠️  Error creando request para %s: %v\n", sensorUUID, err)
-		addLog("❌ Error creando request HTTP")
-		updateGUIStatus(false)
-		return
+		return nil, fmt.Errorf("error abriendo %s: %w", path, err)
+	}
+	defer f.Close()
+
+	type timedReading struct {
+		reading    sink.Reading
+		measuredAt time.Time
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	var readings []timedReading
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+		var reading sink.Reading
+		if err := json.Unmarshal(text, &reading); err != nil {
+			return nil, fmt.Errorf("línea %d: %w", line, err)
+		}
+		if reading.MAC == "" {
+			return nil, fmt.Errorf("línea %d: falta la MAC del sensor", line)
+		}
+
+		measuredAt, err := time.Parse(time.RFC3339, reading.Payload.MeasuredAt)
+		if err != nil {
+			return nil, fmt.Errorf("línea %d: measured_at inválido: %w", line, err)
+		}
+
+		readings = append(readings, timedReading{reading: reading, measuredAt: measuredAt})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error leyendo %s: %w", path, err)
+	}
+
+	sort.SliceStable(readings, func(i, j int) bool {
+		return readings[i].measuredAt.Before(readings[j].measuredAt)
+	})
+
+	result := make([]sink.Reading, len(readings))
+	for i, r := range readings {
+		result[i] = r.reading
+	}
+	return result, nil
+}
+
+// runBackfill reenvía lecturas históricas a la API en orden cronológico,
+// reintentando con backoff los errores temporales
+func runBackfill(path string) error {
+	readings, err := loadBackfill(path)
 	if err != nil {
-		fmt.Printf("⚠️  Error enviando datos para %s: %v\n", sensorUUID, err)
-		addLog(fmt.Sprintf("❌ Error de conexión: %v", err))
-		updateGUIStatus(false)
-		return
+		return err
 	}
-	defer resp.Body.Close()
 
-	// Leer el response body
-	bodyBytes, err := io.ReadAll(resp.Body)
+	fmt.Printf("📼 Backfill: %d lectura(s) a enviar desde %s\n", len(readings), path)
+
+	api := sink.NewAPISink("api", cfg.APIURL, apiKey)
+	sent, discarded, err := sendBackfill(context.Background(), api, readings, sink.MinBackoff)
 	if err != nil {
-		fmt.Printf("⚠️  Error leyendo response body: %v\n", err)
+		return fmt.Errorf("%w (%d enviadas, %d descartadas, %d sin enviar)", err, sent, discarded, len(readings)-sent-discarded)
 	}
-	bodyString := string(bodyBytes)
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		fmt.Printf("✅ Datos enviados para sensor %s (Temp: %.2f°C, Hum: %.2f%%, Bat: %dmV)\n",
-			sensorUUID, data.Temperature, data.Humidity, data.Battery)
-		addLog(fmt.Sprintf("✅ Datos enviados exitosamente (HTTP %d)", resp.StatusCode))
+	fmt.Printf("✅ Backfill completado: %d enviadas, %d descartadas\n", sent, discarded)
+	return nil
+}
 
-		// Mostrar response si hay contenido
-		if len(bodyString) > 0 && bodyString != "{}" {
-			fmt.Printf("   Response: %s\n", bodyString)
-		}
+// sendBackfill envía las lecturas una a una en el orden dado. Las que la
+// API rechaza de forma permanente se descartan; las demás se reintentan,
+// empezando a esperar backoff, hasta que se envían o ctx termina. Si la
+// API rechaza la key se detiene con un error.
+func sendBackfill(ctx context.Context, api *sink.APISink, readings []sink.Reading, backoff time.Duration) (sent, discarded int, err error) {
+	minBackoff := backoff
+	for i, reading := range readings {
+		backoff = minBackoff
+		for {
+			err := api.Send(ctx, reading.MAC, reading.Payload)
+			if err == nil {
+				sent++
+				break
+			}
 
-		updateGUIStatus(true)
-	} else {
-		// Error HTTP - mostrar detalles completos
-		fmt.Printf("\n❌ Error HTTP %d al enviar datos para %s\n", resp.StatusCode, sensorUUID)
-		fmt.Printf("   URL: %s\n", url)
-		fmt.Printf("   Payload enviado: %s\n", string(jsonData))
-		fmt.Printf("   Response body: %s\n", bodyString)
+			if sink.IsPermanent(err) {
+				fmt.Printf("🗑️  Lectura %d descartada: %v\n", i+1, err)
+				discarded++
+				break
+			}
+			if sink.IsMisconfigured(err) {
+				return sent, discarded, fmt.Errorf("la API rechaza la key: %w", err)
+			}
 
-		// Intentar parsear el error como JSON para mostrarlo mejor
-		var errorResponse map[string]interface{}
-		if err := json.Unmarshal(bodyBytes, &errorResponse); err == nil {
-			if msg, ok := errorResponse["message"].(string); ok {
-				addLog(fmt.Sprintf("❌ HTTP %d: %s", resp.StatusCode, msg))
-			} else {
-				addLog(fmt.Sprintf("❌ HTTP %d - Ver consola para detalles", resp.StatusCode))
+			// Respetar el Retry-After del servidor (429/503)
+			delay := backoff
+			if after, ok := sink.RetryAfter(err); ok {
+				delay = after
 			}
-		} else {
-			// No es JSON, mostrar el body tal cual (truncado si es muy largo)
-			truncated := bodyString
-			if len(truncated) > 100 {
-				truncated = truncated[:100] + "..."
+			fmt.Printf("⏳ Reintentando lectura %d en %v...\n", i+1, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return sent, discarded, ctx.Err()
+			}
+			backoff *= 2
+			if backoff > sink.MaxBackoff {
+				backoff = sink.MaxBackoff
 			}
-			addLog(fmt.Sprintf("❌ HTTP %d: %s", resp.StatusCode, truncated))
 		}
-
-		updateGUIStatus(false)
 	}
+	return sent, discarded, nil
 }
 
 // updateGUIStatus actualiza el estado visual de la UI
@@ -438,6 +747,52 @@ func updateGUIStatus(success bool) {
 	}
 }
 
+// updatePendingStatus muestra en la UI cuántas lecturas esperan en las
+// colas y el estado de cada destino
+func updatePendingStatus() {
+	if terminalUI == nil {
+		return
+	}
+
+	pending := 0
+	states := make([]ui.SinkState, len(sinkRunners))
+	for i, runner := range sinkRunners {
+		status := runner.Status()
+		pending += status.Pending
+		states[i] = sinkState(status)
+	}
+	terminalUI.UpdatePending(pending)
+	terminalUI.UpdateSinks(states)
+}
+
+// sinkState resume el estado de un destino para la UI
+func sinkState(status sink.Status) ui.SinkState {
+	state := ui.SinkState{Name: status.Name, OK: status.LastError == nil}
+	switch status.State {
+	case sink.StateRetrying:
+		state.Note = "reintento " + status.RetryAt.Format("15:04:05")
+	case sink.StatePaused:
+		state.Paused = true
+		state.Note = "pausa hasta " + status.RetryAt.Format("15:04")
+	case sink.StateProbing:
+		state.Note = "probando"
+	}
+	return state
+}
+
+// updateSinkStatus refleja en la UI el resultado del último envío: la
+// sincronización es exitosa solo si ningún destino está fallando
+func updateSinkStatus() {
+	success := true
+	for _, runner := range sinkRunners {
+		if runner.Status().LastError != nil {
+			success = false
+		}
+	}
+	updateGUIStatus(success)
+	updatePendingStatus()
+}
+
 // addLog añade un mensaje al log de actividad
 func addLog(message string) {
 	timestamp := time.Now().Format("15:04:05")
@@ -448,27 +803,45 @@ func addLog(message string) {
 	}
 }
 
-// updateSensorStatus actualiza el widget de estado de sensores
-func updateSensorStatus(config *Config) {
+// updateSensorStatus actualiza el widget de estado de sensores y publica
+// por MQTT y en /stream los cambios de online/offline
+func updateSensorStatus() {
 	lastSeenMutex.Lock()
 	defer lastSeenMutex.Unlock()
 
-	if terminalUI == nil {
-		return
-	}
-
+	config := currentSensors.Load().config
+	timeout := time.Duration(onlineTimeout.Load())
 	now := time.Now()
 	online := 0
 
+	total := 0
 	for _, sensor := range config.Sensors {
+		if sensor.Disabled {
+			continue
+		}
+		total++
+
+		isOnline := false
 		if lastSeen, exists := lastSeenMap[sensor.MAC]; exists {
-			if now.Sub(lastSeen) < onlineTimeout {
+			if now.Sub(lastSeen) < timeout {
 				online++
+				isOnline = true
 			}
 		}
+
+		if previous, known := sensorOnline[sensor.MAC]; !known || previous != isOnline {
+			sensorOnline[sensor.MAC] = isOnline
+			if mqttPublisher != nil {
+				mqttPublisher.PublishAvailability(sensor.MAC, isOnline)
+			}
+			publishOnline(sensor, isOnline, lastSeenMap[sensor.MAC])
+		}
+	}
+
+	if terminalUI == nil {
+		return
 	}
 
-	total := len(config.Sensors)
 	terminalUI.UpdateSensors(online, total)
 }
 
@@ -482,14 +855,17 @@ func markSensorOnline(mac string) {
 
 // loadAPIKey carga la API key desde el archivo ~/.insectius-monitor
 func loadAPIKey() error {
-	// Obtener el directorio home del usuario
-	homeDir, err := os.UserHomeDir()
+	// La API key de la configuración (api_key, SENSORGO_API_KEY) tiene prioridad
+	if cfg.APIKey != "" {
+		apiKey = cfg.APIKey
+		return nil
+	}
+
+	apiKeyPath, err := cfg.KeyFile()
 	if err != nil {
 		return fmt.Errorf("error obteniendo directorio home: %w", err)
 	}
 
-	apiKeyPath := homeDir + "/.insectius-monitor"
-
 	data, err := os.ReadFile(apiKeyPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -505,45 +881,63 @@ func loadAPIKey() error {
 		return fmt.Errorf("el archivo %s está vacío. Añade tu API key", apiKeyPath)
 	}
 
-	fmt.Printf("✅ API key cargada desde %s\n", apiKeyPath)
+	fmt.Fprintf(console, "✅ API key cargada desde %s\n", apiKeyPath)
 	return nil
 }
 
 // loadConfig carga la configuración desde el archivo JSON
 // Retorna la configuración y un booleano indicando si es la primera ejecución
 func loadConfig() (*Config, bool) {
-	data, err := os.ReadFile(configFile)
+	config, err := readSensors(cfg.SensorsFile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Primera ejecución
 			return &Config{Sensors: []AuthorizedSensor{}}, true
 		}
-		fmt.Printf("⚠️  Error leyendo archivo de configuración: %v\n", err)
+		fmt.Fprintf(console, "⚠️  %v\n", err)
 		return &Config{Sensors: []AuthorizedSensor{}}, true
 	}
 
-	var config Config
-	err = json.Unmarshal(data, &config)
-	if err != nil {
-		fmt.Printf("⚠️  Error parseando configuración: %v\n", err)
-		return &Config{Sensors: []AuthorizedSensor{}}, true
+	if len(config.Sensors) == 0 {
+		return config, true
 	}
 
-	if len(config.Sensors) == 0 {
-		return &config, true
+	return config, false
+}
+
+// readSensors lee la lista de sensores autorizados de path
+func readSensors(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error leyendo archivo de configuración: %w", err)
 	}
 
-	return &config, false
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parseando configuración: %w", err)
+	}
+	return &config, nil
 }
 
-// saveConfig guarda la configuración en el archivo JSON
+// saveConfig guarda la configuración en el archivo JSON. La versión
+// anterior se conserva como copia de seguridad (.bak) y el archivo se
+// reemplaza de una vez, nunca queda a medio escribir.
 func saveConfig(config *Config) error {
 	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		return fmt.Errorf("error serializando configuración: %w", err)
 	}
 
-	err = os.WriteFile(configFile, data, 0644)
+	if previous, err := os.ReadFile(cfg.SensorsFile); err == nil {
+		if err := writeFileAtomic(cfg.SensorsFile+".bak", previous, 0644); err != nil {
+			return fmt.Errorf("error guardando copia de seguridad: %w", err)
+		}
+	}
+
+	err = writeFileAtomic(cfg.SensorsFile, data, 0644)
 	if err != nil {
 		return fmt.Errorf("error guardando archivo: %w", err)
 	}
@@ -551,74 +945,116 @@ func saveConfig(config *Config) error {
 	return nil
 }
 
-// isRuuviTag verifica si el dispositivo es un RuuviTag
-func isRuuviTag(device bluetooth.ScanResult) bool {
+// isSupportedSensor verifica si el dispositivo es un RuuviTag o cualquier
+// otro sensor que sepamos decodificar
+func isSupportedSensor(adv *sensor.Advertisement) bool {
 	// Verificar por nombre
-	name := device.LocalName()
-	if len(name) >= 5 && name[:5] == "Ruuvi" {
+	if strings.HasPrefix(adv.LocalName, "Ruuvi") {
 		return true
 	}
 
-	// Verificar por Manufacturer ID (0x0499 para Ruuvi Innovations Ltd)
-	if device.ManufacturerData() != nil {
-		for _, mfg := range device.ManufacturerData() {
-			if mfg.CompanyID == 0x0499 {
-				return true
-			}
-		}
-	}
-
-	return false
+	// Verificar si algún decoder registrado entiende el anuncio
+	_, err := decoders.Decode(adv)
+	return err == nil
 }
 
-// parseRuuviData parsea los datos del formato RAWv2 (más común)
-func parseRuuviData(device bluetooth.ScanResult) *RuuviData {
-	mfgData := device.ManufacturerData()
-	if mfgData == nil {
-		return nil
+// logCounterChanges avisa de movimientos del sensor y de paquetes perdidos
+// comparando contadores con la lectura anterior
+func logCounterChanges(name string, previous, current *sensor.RuuviData) {
+	if previous == nil {
+		return
 	}
 
-	for _, mfg := range mfgData {
-		if mfg.CompanyID == 0x0499 && len(mfg.Data) >= 24 {
-			data := mfg.Data
-
-			// Verificar formato RAWv2 (0x05)
-			if data[0] != 0x05 {
-				continue
-			}
-
-			result := &RuuviData{}
-
-			// Temperatura (bytes 1-2): signed int16, escala 0.005
-			tempRaw := int16(binary.BigEndian.Uint16(data[1:3]))
-			result.Temperature = float64(tempRaw) * 0.005
-
-			// Humedad (bytes 3-4): uint16, escala 0.0025
-			humRaw := binary.BigEndian.Uint16(data[3:5])
-			result.Humidity = float64(humRaw) * 0.0025
-
-			// Presión (bytes 5-6): uint16, offset 50000 Pa
-			pressRaw := binary.BigEndian.Uint16(data[5:7])
-			result.Pressure = (float64(pressRaw) + 50000.0) / 100.0 // Convertir a hPa
-
-			// Batería (bytes 13-14): primeros 11 bits en mV
-			battRaw := binary.BigEndian.Uint16(data[13:15])
-			result.Battery = (battRaw >> 5) + 1600
+	if previous.MovementCounter != nil && current.MovementCounter != nil &&
+		*previous.MovementCounter != *current.MovementCounter {
+		addLog(fmt.Sprintf("🏃 Movimiento detectado en %s", name))
+	}
 
-			// TX Power (byte 14): últimos 5 bits, signed
-			txPowerRaw := int8(data[14] & 0x1F)
-			if txPowerRaw&0x10 != 0 {
-				txPowerRaw |= ^0x1F
-			}
-			result.TxPower = txPowerRaw * 2
+	if missed := current.MissedSince(previous); missed > 0 {
+		fmt.Fprintf(console, "⚠️  %s: %d paquete(s) perdidos\n", name, missed)
+	}
+}
 
-			// MAC address (bytes 18-23)
-			result.MAC = fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X",
-				data[18], data[19], data[20], data[21], data[22], data[23])
+// formatValues resume temperatura, humedad y batería omitiendo los valores no disponibles
+func formatValues(temperature, humidity *float64, battery *uint16) string {
+	var parts []string
+	if temperature != nil {
+		parts = append(parts, fmt.Sprintf("%.1f°C", *temperature))
+	}
+	if humidity != nil {
+		parts = append(parts, fmt.Sprintf("%.1f%% humedad", *humidity))
+	}
+	if battery != nil {
+		parts = append(parts, fmt.Sprintf("%dmV", *battery))
+	}
+	if len(parts) == 0 {
+		return "sin valores"
+	}
+	return strings.Join(parts, ", ")
+}
 
-			return result
-		}
+// printReading muestra en consola todos los valores disponibles de una lectura
+func printReading(data *sensor.RuuviData) {
+	if data.Temperature != nil {
+		fmt.Fprintf(console, "   🌡️  Temperatura: %.2f °C\n", *data.Temperature)
+	}
+	if data.Humidity != nil {
+		fmt.Fprintf(console, "   💧 Humedad: %.2f %%\n", *data.Humidity)
+	}
+	if data.Pressure != nil {
+		fmt.Fprintf(console, "   📊 Presión: %.2f hPa\n", *data.Pressure)
+	}
+	if data.Battery != nil {
+		fmt.Fprintf(console, "   🔋 Batería: %d mV\n", *data.Battery)
 	}
+	if data.BatteryPercent != nil {
+		fmt.Fprintf(console, "   🔋 Batería: %d %%\n", *data.BatteryPercent)
+	}
+	if data.TxPower != nil {
+		fmt.Fprintf(console, "   📶 TX Power: %d dBm\n", *data.TxPower)
+	}
+	if data.AccelerationX != nil && data.AccelerationY != nil && data.AccelerationZ != nil {
+		fmt.Fprintf(console, "   📐 Aceleración: X=%.3f g, Y=%.3f g, Z=%.3f g\n",
+			*data.AccelerationX, *data.AccelerationY, *data.AccelerationZ)
+	}
+	if data.MovementCounter != nil {
+		fmt.Fprintf(console, "   🏃 Movimientos: %d\n", *data.MovementCounter)
+	}
+	if data.Sequence != nil {
+		fmt.Fprintf(console, "   🔢 Secuencia: %d\n", *data.Sequence)
+	}
+	if air := formatAirQuality(data); air != "" {
+		fmt.Fprintf(console, "   🌬️  Calidad del aire: %s\n", air)
+	}
+	if data.Luminosity != nil {
+		fmt.Fprintf(console, "   💡 Luminosidad: %.0f lux\n", *data.Luminosity)
+	}
+	if data.SoundLevel != nil {
+		fmt.Fprintf(console, "   🔊 Sonido: %.1f dBA\n", *data.SoundLevel)
+	}
+	if data.DataFormat != 0 {
+		fmt.Fprintf(console, "   🏷️  Formato de datos: %#x\n", data.DataFormat)
+	}
+}
 
-	return nil
+// formatAirQuality resume los valores de calidad del aire (Ruuvi Air);
+// devuelve "" si la lectura no tiene ninguno
+func formatAirQuality(data *sensor.RuuviData) string {
+	var parts []string
+	if data.CO2 != nil {
+		parts = append(parts, fmt.Sprintf("CO2 %dppm", *data.CO2))
+	}
+	if data.PM2_5 != nil {
+		parts = append(parts, fmt.Sprintf("PM2.5 %.1f", *data.PM2_5))
+	}
+	if data.PM10_0 != nil {
+		parts = append(parts, fmt.Sprintf("PM10 %.1f", *data.PM10_0))
+	}
+	if data.VOCIndex != nil {
+		parts = append(parts, fmt.Sprintf("VOC %d", *data.VOCIndex))
+	}
+	if data.NOxIndex != nil {
+		parts = append(parts, fmt.Sprintf("NOx %d", *data.NOxIndex))
+	}
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sensorsgo/sink"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeFile writes content to a file in a temporary directory
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+// TestLoadBackfill tests that readings are sorted by measured_at and that
+// invalid lines are reported with their number
+func TestLoadBackfill(t *testing.T) {
+	path := writeFile(t, "backfill.jsonl", `{"mac": "B", "payload": {"measured_at": "2026-03-01T12:00:00+01:00"}}
+
+{"mac": "C", "payload": {"measured_at": "2026-03-01T12:00:00Z"}}
+{"mac": "A", "payload": {"measured_at": "2026-03-01T10:30:00Z"}}
+{"mac": "D", "payload": {"measured_at": "2026-03-01T12:00:00Z"}}
+`)
+	readings, err := loadBackfill(path)
+	if err != nil {
+		t.Fatalf("loadBackfill: %v", err)
+	}
+	var macs []string
+	for _, r := range readings {
+		macs = append(macs, r.MAC)
+	}
+	// B is 11:00 UTC; C and D keep their order in the file
+	if got := strings.Join(macs, " "); got != "A B C D" {
+		t.Errorf("order = %s, want A B C D", got)
+	}
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`{"mac": "A", "payload": {"measured_at": "2026-03-01T10:30:00Z"}}` + "\n{", "línea 2"},
+		{`{"payload": {"measured_at": "2026-03-01T10:30:00Z"}}`, "línea 1: falta la MAC"},
+		{`{"mac": "A", "payload": {"measured_at": "ayer"}}`, "línea 1: measured_at inválido"},
+	}
+	for _, tt := range tests {
+		_, err := loadBackfill(writeFile(t, "backfill.jsonl", tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadBackfill(%q) = %v, want %q", tt.content, err, tt.want)
+		}
+	}
+}
+
+// TestSendBackfill tests that temporary errors are retried and permanent
+// ones discard only that reading
+func TestSendBackfill(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	attempts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mac := strings.TrimPrefix(r.URL.Path, "/")
+		mu.Lock()
+		requests = append(requests, mac)
+		attempts[mac]++
+		n := attempts[mac]
+		mu.Unlock()
+
+		switch {
+		case mac == "B" && n < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case mac == "C":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	var readings []sink.Reading
+	for _, mac := range []string{"A", "B", "C", "D"} {
+		readings = append(readings, sink.Reading{MAC: mac})
+	}
+	api := sink.NewAPISink("api", server.URL, "key")
+	sent, discarded, err := sendBackfill(context.Background(), api, readings, time.Millisecond)
+	if sent != 3 || discarded != 1 || err != nil {
+		t.Errorf("sent %d, discarded %d, %v; want 3, 1 and no error", sent, discarded, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := fmt.Sprint(requests); got != "[A B B B C D]" {
+		t.Errorf("requests = %s, want [A B B B C D]", got)
+	}
+}
+
+// TestSendBackfillCancel tests that a cancelled backfill stops retrying
+func TestSendBackfillCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	api := sink.NewAPISink("api", server.URL, "key")
+	sent, discarded, err := sendBackfill(ctx, api, []sink.Reading{{MAC: "A"}, {MAC: "B"}}, time.Millisecond)
+	if sent != 0 || discarded != 0 || err == nil {
+		t.Errorf("sent %d, discarded %d, %v; want 0, 0 and cancelled", sent, discarded, err)
+	}
+}
+
+// TestSendBackfillUnauthorized tests that a rejected key stops the
+// backfill without discarding readings
+func TestSendBackfillUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	api := sink.NewAPISink("api", server.URL, "expired")
+	sent, discarded, err := sendBackfill(context.Background(), api, []sink.Reading{{MAC: "A"}, {MAC: "B"}}, time.Millisecond)
+	if sent != 0 || discarded != 0 || !sink.IsMisconfigured(err) {
+		t.Errorf("sent %d, discarded %d, %v; want 0, 0 and the rejected key", sent, discarded, err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"sensorsgo/metrics"
+	"sensorsgo/sensor"
+	"time"
+)
+
+// Contadores internos expuestos en /metrics
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	advertisementsSeen = metricsRegistry.Counter("sensorgo_advertisements_total", "Anuncios BLE recibidos de todas las fuentes")
+	parseFailures      = metricsRegistry.Counter("sensorgo_parse_failures_total", "Anuncios de sensores autorizados que no se pudieron decodificar")
+	unauthorizedSeen   = metricsRegistry.Counter("sensorgo_unauthorized_advertisements_total", "Anuncios de sensores compatibles no registrados")
+	scanRestarts       = metricsRegistry.Counter("sensorgo_scan_restarts_total", "Reinicios del escaneo tras un error")
+)
+
+// sensorGauge es una métrica de la última lectura de cada sensor
+type sensorGauge struct {
+	name  string
+	help  string
+	value func(*sensor.RuuviData) (float64, bool)
+}
+
+var sensorGauges = []sensorGauge{
+	{"sensorgo_temperature_celsius", "Temperatura (°C)", fieldValue("temperature", 1)},
+	{"sensorgo_humidity_percent", "Humedad relativa (%)", fieldValue("humidity", 1)},
+	{"sensorgo_pressure_hectopascals", "Presión atmosférica (hPa)", fieldValue("pressure", 1)},
+	{"sensorgo_battery_volts", "Voltaje de la batería (V)", fieldValue("battery", 0.001)},
+	{"sensorgo_battery_percent", "Nivel de batería de los sensores que lo envían (%)", fieldValue("battery_percent", 1)},
+	{"sensorgo_rssi_dbm", "Intensidad de la señal recibida (dBm)", func(d *sensor.RuuviData) (float64, bool) {
+		return float64(d.RSSI), d.RSSI != 0
+	}},
+	{"sensorgo_tx_power_dbm", "Potencia de transmisión del sensor (dBm)", fieldValue("tx_power", 1)},
+	{"sensorgo_movement_counter", "Contador de movimiento del sensor (vuelve a 0 tras 255)", fieldValue("movement_counter", 1)},
+}
+
+// fieldValue devuelve el campo key de una lectura multiplicado por scale
+func fieldValue(key string, scale float64) func(*sensor.RuuviData) (float64, bool) {
+	for _, f := range sensor.Fields {
+		if f.Key == key {
+			value := f.Value
+			return func(d *sensor.RuuviData) (float64, bool) {
+				v, ok := value(d)
+				return v * scale, ok
+			}
+		}
+	}
+	panic("unknown field " + key)
+}
+
+// Además de los contadores, /metrics incluye las lecturas de los
+// sensores y el estado de los destinos. Se registran una sola vez: cada
+// registro repetiría las familias en la salida.
+func init() {
+	metricsRegistry.Collect(collectSensorMetrics)
+	metricsRegistry.Collect(collectSinkMetrics)
+}
+
+// metricsHandler devuelve el handler de /metrics
+func metricsHandler() http.Handler {
+	return metricsRegistry.Handler()
+}
+
+// collectSensorMetrics devuelve la última lectura y el tiempo desde la
+// última vez visto de cada sensor autorizado, etiquetados con su MAC y
+// su nombre
+func collectSensorMetrics() []metrics.Family {
+	sensors := currentSensors.Load()
+	if sensors == nil {
+		return nil
+	}
+
+	latestMutex.Lock()
+	readings := make(map[string]*sensor.RuuviData, len(latestReadings))
+	for mac, data := range latestReadings {
+		readings[mac] = data
+	}
+	latestMutex.Unlock()
+
+	families := make([]metrics.Family, len(sensorGauges))
+	for i, gauge := range sensorGauges {
+		families[i] = metrics.Family{Name: gauge.name, Help: gauge.help, Type: metrics.TypeGauge}
+	}
+	age := metrics.Family{
+		Name: "sensorgo_last_seen_age_seconds",
+		Help: "Segundos desde la última lectura del sensor",
+		Type: metrics.TypeGauge,
+	}
+
+	now := time.Now()
+	lastSeenMutex.Lock()
+	defer lastSeenMutex.Unlock()
+	for _, s := range sensors.config.Sensors {
+		if s.Disabled {
+			continue
+		}
+		labels := []metrics.Label{{Name: "mac", Value: s.MAC}, {Name: "name", Value: s.Name}}
+
+		if seen, ok := lastSeenMap[s.MAC]; ok {
+			age.Samples = append(age.Samples, metrics.Sample{Labels: labels, Value: now.Sub(seen).Seconds()})
+		}
+		data := readings[s.MAC]
+		if data == nil {
+			continue
+		}
+		for i, gauge := range sensorGauges {
+			if v, ok := gauge.value(data); ok {
+				families[i].Samples = append(families[i].Samples, metrics.Sample{Labels: labels, Value: v})
+			}
+		}
+	}
+	return append(families, age)
+}
+
+// collectSinkMetrics devuelve los envíos correctos y fallidos y las
+// lecturas pendientes de cada destino
+func collectSinkMetrics() []metrics.Family {
+	successes := metrics.Family{Name: "sensorgo_upload_successes_total", Help: "Envíos correctos a cada destino", Type: metrics.TypeCounter}
+	failures := metrics.Family{Name: "sensorgo_upload_failures_total", Help: "Envíos fallidos o rechazados de cada destino", Type: metrics.TypeCounter}
+	depth := metrics.Family{Name: "sensorgo_queue_depth", Help: "Lecturas pendientes de envío en la cola de cada destino", Type: metrics.TypeGauge}
+
+	for _, runner := range sinkRunners {
+		status := runner.Status()
+		labels := []metrics.Label{{Name: "sink", Value: status.Name}}
+		successes.Samples = append(successes.Samples, metrics.Sample{Labels: labels, Value: float64(status.Writes)})
+		failures.Samples = append(failures.Samples, metrics.Sample{Labels: labels, Value: float64(status.WriteErrors)})
+		depth.Samples = append(depth.Samples, metrics.Sample{Labels: labels, Value: float64(status.Pending)})
+	}
+	return []metrics.Family{successes, failures, depth}
+}
@@ -0,0 +1,166 @@
+// Package metrics exposes counters and gauges in the Prometheus text
+// exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Type is the type of a metric family
+type Type string
+
+// Metric types
+const (
+	TypeCounter Type = "counter"
+	TypeGauge   Type = "gauge"
+)
+
+// Label is a label of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one value of a family
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Family is a named metric with its samples. A family without samples
+// is not written.
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// Counter is a monotonic counter. It is safe for concurrent use.
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Inc adds one to c
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Value returns the current count
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+// Registry holds the metrics to expose. It is safe for concurrent use.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []func() []Family
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers and returns a counter without labels
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.Collect(func() []Family {
+		return []Family{{Name: name, Help: help, Type: TypeCounter, Samples: []Sample{{Value: float64(c.Value())}}}}
+	})
+	return c
+}
+
+// Collect registers fn, called on every scrape to return families whose
+// samples change, such as one gauge per sensor
+func (r *Registry) Collect(fn func() []Family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, fn)
+}
+
+// Write writes every family in the text exposition format, in the order
+// they were registered
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]func() []Family(nil), r.collectors...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, collect := range collectors {
+		for _, f := range collect() {
+			writeFamily(bw, f)
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry, e.g. on /metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		if req.Method == http.MethodGet {
+			r.Write(w)
+		}
+	})
+}
+
+func writeFamily(w *bufio.Writer, f Family) {
+	if len(f.Samples) == 0 {
+		return
+	}
+	if f.Help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.Name, helpEscaper.Replace(f.Help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.Name, f.Type)
+	for _, s := range f.Samples {
+		w.WriteString(f.Name)
+		if len(s.Labels) > 0 {
+			w.WriteByte('{')
+			for i, l := range s.Labels {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				fmt.Fprintf(w, "%s=\"%s\"", l.Name, labelEscaper.Replace(l.Value))
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(formatValue(s.Value))
+		w.WriteByte('\n')
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// formatValue formats v as Prometheus expects, including +Inf, -Inf and NaN
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
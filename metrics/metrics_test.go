@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRegistryWrite tests the text exposition format, including label
+// escaping and families without samples
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	seen := r.Counter("sensorgo_advertisements_total", "Advertisements received")
+	seen.Inc()
+	seen.Inc()
+	r.Collect(func() []Family {
+		return []Family{
+			{Name: "sensorgo_temperature_celsius", Help: "Temperature\nin °C", Type: TypeGauge, Samples: []Sample{
+				{Labels: []Label{{"mac", "C4:7C:8D:6A:1B:2E"}, {"name", `Bandeja "3"`}}, Value: 21.5},
+				{Labels: []Label{{"mac", "AA:BB:CC:DD:EE:FF"}, {"name", `a\b`}}, Value: math.Inf(-1)},
+			}},
+			{Name: "sensorgo_empty", Type: TypeGauge},
+		}
+	})
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := `# HELP sensorgo_advertisements_total Advertisements received
+# TYPE sensorgo_advertisements_total counter
+sensorgo_advertisements_total 2
+# HELP sensorgo_temperature_celsius Temperature\nin °C
+# TYPE sensorgo_temperature_celsius gauge
+sensorgo_temperature_celsius{mac="C4:7C:8D:6A:1B:2E",name="Bandeja \"3\""} 21.5
+sensorgo_temperature_celsius{mac="AA:BB:CC:DD:EE:FF",name="a\\b"} -Inf
+`
+	if b.String() != want {
+		t.Errorf("Write =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ContentType || rec.Body.String() != want {
+		t.Errorf("GET /metrics = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sensorsgo/metrics"
+	"sensorsgo/sensor"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCollectSensorMetrics tests the labels and units of the sensor
+// metrics, and that disabled sensors are left out
+func TestCollectSensorMetrics(t *testing.T) {
+	currentSensors.Store(newSensorSet(&Config{Sensors: []AuthorizedSensor{
+		{MAC: "C4:7C:8D:6A:1B:2E", Name: "Bandeja 1"},
+		{MAC: "AA:BB:CC:DD:EE:FF", Name: "Bandeja 2", Disabled: true},
+		{MAC: "11:22:33:44:55:66", Name: "Bandeja 3"},
+	}}))
+	temperature, battery := 21.5, uint16(2977)
+	reading := &sensor.RuuviData{Temperature: &temperature, Battery: &battery, RSSI: -60}
+	latestReadings = map[string]*sensor.RuuviData{"C4:7C:8D:6A:1B:2E": reading, "AA:BB:CC:DD:EE:FF": reading}
+	now := time.Now()
+	lastSeenMap = map[string]time.Time{
+		"C4:7C:8D:6A:1B:2E": now.Add(-10 * time.Second),
+		"AA:BB:CC:DD:EE:FF": now,
+		"11:22:33:44:55:66": now.Add(-time.Minute),
+	}
+
+	samples := make(map[string][]metrics.Sample)
+	for _, family := range collectSensorMetrics() {
+		samples[family.Name] = family.Samples
+	}
+
+	labels := func(s metrics.Sample) string {
+		parts := make([]string, len(s.Labels))
+		for i, l := range s.Labels {
+			parts[i] = l.Name + "=" + l.Value
+		}
+		return strings.Join(parts, ",")
+	}
+	tests := []struct {
+		family string
+		want   []string // Labels and value of each sample
+	}{
+		{"sensorgo_temperature_celsius", []string{"mac=C4:7C:8D:6A:1B:2E,name=Bandeja 1 21.5"}},
+		{"sensorgo_battery_volts", []string{"mac=C4:7C:8D:6A:1B:2E,name=Bandeja 1 2.977"}},
+		{"sensorgo_rssi_dbm", []string{"mac=C4:7C:8D:6A:1B:2E,name=Bandeja 1 -60"}},
+		{"sensorgo_humidity_percent", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, s := range samples[tt.family] {
+			got = append(got, labels(s)+" "+strings.TrimRight(strings.TrimRight(strconv.FormatFloat(s.Value, 'f', 3, 64), "0"), "."))
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s = %q, want %q", tt.family, got, tt.want)
+		}
+	}
+
+	ages := samples["sensorgo_last_seen_age_seconds"]
+	if len(ages) != 2 || labels(ages[0]) != "mac=C4:7C:8D:6A:1B:2E,name=Bandeja 1" || labels(ages[1]) != "mac=11:22:33:44:55:66,name=Bandeja 3" {
+		t.Fatalf("sensorgo_last_seen_age_seconds = %+v", ages)
+	}
+	if ages[0].Value < 10 || ages[0].Value > 20 || ages[1].Value < 60 || ages[1].Value > 70 {
+		t.Errorf("ages = %v, %v", ages[0].Value, ages[1].Value)
+	}
+}
+
+// TestMetricsHandlerTwice tests that every family is written once, however
+// many handlers are created
+func TestMetricsHandlerTwice(t *testing.T) {
+	currentSensors.Store(newSensorSet(&Config{Sensors: []AuthorizedSensor{{MAC: "C4:7C:8D:6A:1B:2E", Name: "Bandeja 1"}}}))
+	temperature := 21.5
+	latestReadings = map[string]*sensor.RuuviData{"C4:7C:8D:6A:1B:2E": {Temperature: &temperature}}
+
+	metricsHandler()
+	rec := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, family := range []string{"sensorgo_advertisements_total", "sensorgo_temperature_celsius"} {
+		if n := strings.Count(rec.Body.String(), "# TYPE "+family+" "); n != 1 {
+			t.Errorf("%s written %d times", family, n)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+// Package mqtt is a minimal MQTT 3.1.1 client for publishing readings,
+// with QoS 1 delivery that survives reconnections
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults used when Options leaves a value unset
+const (
+	DefaultKeepAlive  = 60 * time.Second
+	DefaultBufferSize = 1000
+
+	connectTimeout = 10 * time.Second
+	maxInflight    = 32
+	minReconnect   = time.Second
+	maxReconnect   = time.Minute
+)
+
+// ErrClosed is returned by Publish after Close
+var ErrClosed = errors.New("mqtt: client closed")
+
+// Message is a message to publish
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte // 0 or 1
+	Retain  bool
+}
+
+// Options configures a Client
+type Options struct {
+	Broker    string // host:port, optionally prefixed with tcp://
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	Will      *Message // Published by the broker if the connection is lost
+	// BufferSize bounds the QoS 1 messages kept while the broker is
+	// unreachable; the oldest are dropped first
+	BufferSize int
+	// OnConnect is called after every successful (re)connection, before
+	// buffered messages are sent
+	OnConnect func(*Client)
+}
+
+// Client publishes messages to a broker, reconnecting in the background
+type Client struct {
+	opts Options
+
+	mu        sync.Mutex
+	queue     []*Message          // Waiting to be sent
+	inflight  map[uint16]*Message // QoS 1 sent, waiting for PUBACK
+	nextID    uint16
+	connected bool
+	lastErr   error
+	closed    bool
+
+	wake chan struct{}
+	done chan struct{}
+	exit chan struct{}
+}
+
+// NewClient creates a client; Start connects it
+func NewClient(opts Options) *Client {
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = DefaultKeepAlive
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	opts.Broker = strings.TrimPrefix(opts.Broker, "tcp://")
+
+	return &Client{
+		opts:     opts,
+		inflight: make(map[uint16]*Message),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		exit:     make(chan struct{}),
+	}
+}
+
+// Start connects to the broker in the background and keeps reconnecting
+// until Close
+func (c *Client) Start() {
+	go c.run()
+}
+
+// Publish queues a message. QoS 0 messages are dropped while disconnected;
+// QoS 1 messages are buffered and delivered at least once.
+func (c *Client) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	if qos > 1 {
+		return fmt.Errorf("mqtt: QoS %d not supported", qos)
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	if qos == 0 && !c.connected {
+		c.mu.Unlock()
+		return nil
+	}
+	c.queue = append(c.queue, &Message{Topic: topic, Payload: payload, QoS: qos, Retain: retain})
+	if excess := len(c.queue) + len(c.inflight) - c.opts.BufferSize; excess > 0 && excess <= len(c.queue) {
+		c.queue = c.queue[excess:]
+	}
+	c.mu.Unlock()
+
+	c.notify()
+	return nil
+}
+
+// Connected reports whether the client is connected to the broker
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// Err returns the last connection error, or nil while connected
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// Pending returns the number of messages not yet acknowledged by the broker
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue) + len(c.inflight)
+}
+
+// Close sends the queued messages and disconnects cleanly, so that the
+// broker does not publish the will
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.done)
+	<-c.exit
+	return nil
+}
+
+func (c *Client) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run connects and serves sessions until Close
+func (c *Client) run() {
+	defer close(c.exit)
+
+	backoff := minReconnect
+	for {
+		conn, err := c.connect()
+		if err == nil {
+			backoff = minReconnect
+			err = c.session(conn)
+		}
+
+		c.mu.Lock()
+		c.connected = false
+		c.lastErr = err
+		c.mu.Unlock()
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxReconnect {
+			backoff = maxReconnect
+		}
+	}
+}
+
+// connect dials the broker and completes the CONNECT/CONNACK handshake
+func (c *Client) connect() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", c.opts.Broker, connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(connectTimeout))
+	keepAlive := uint16(c.opts.KeepAlive / time.Second)
+	if _, err := conn.Write(encodeConnect(c.opts.ClientID, c.opts.Username, c.opts.Password, keepAlive, c.opts.Will)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	first, body, err := readPacket(bufio.NewReader(conn))
+	if err == nil {
+		err = checkConnack(first, body)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	c.connected = true
+	c.lastErr = nil
+	c.mu.Unlock()
+	return conn, nil
+}
+
+// session sends queued messages and keep-alives over conn until it fails
+// or the client is closed
+func (c *Client) session(conn net.Conn) error {
+	defer conn.Close()
+
+	if c.opts.OnConnect != nil {
+		c.opts.OnConnect(c)
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- c.readLoop(conn)
+	}()
+
+	// Messages that were in flight when the last connection dropped
+	c.mu.Lock()
+	resend := make([]uint16, 0, len(c.inflight))
+	for id := range c.inflight {
+		resend = append(resend, id)
+	}
+	c.mu.Unlock()
+	for _, id := range resend {
+		c.mu.Lock()
+		m, ok := c.inflight[id]
+		c.mu.Unlock()
+		if ok {
+			if err := c.write(conn, encodePublish(m, id, true)); err != nil {
+				return err
+			}
+		}
+	}
+
+	ping := time.NewTicker(c.opts.KeepAlive / 2)
+	defer ping.Stop()
+
+	for {
+		if err := c.flush(conn); err != nil {
+			return err
+		}
+
+		select {
+		case <-c.wake:
+		case <-ping.C:
+			if err := c.write(conn, []byte{packetPingreq << 4, 0}); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		case <-c.done:
+			// Messages published just before Close still go out
+			c.flush(conn)
+			c.write(conn, []byte{packetDisconnect << 4, 0})
+			return ErrClosed
+		}
+	}
+}
+
+// flush sends queued messages while the in-flight window allows
+func (c *Client) flush(conn net.Conn) error {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 || len(c.inflight) >= maxInflight {
+			c.mu.Unlock()
+			return nil
+		}
+		m := c.queue[0]
+		c.queue = c.queue[1:]
+		var id uint16
+		if m.QoS > 0 {
+			id = c.allocateID()
+			c.inflight[id] = m
+		}
+		c.mu.Unlock()
+
+		if err := c.write(conn, encodePublish(m, id, false)); err != nil {
+			return err
+		}
+	}
+}
+
+// allocateID returns an unused non-zero packet identifier; c.mu must be held
+func (c *Client) allocateID() uint16 {
+	for {
+		c.nextID++
+		if c.nextID == 0 {
+			continue
+		}
+		if _, used := c.inflight[c.nextID]; !used {
+			return c.nextID
+		}
+	}
+}
+
+func (c *Client) write(conn net.Conn, b []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(c.opts.KeepAlive))
+	_, err := conn.Write(b)
+	return err
+}
+
+// readLoop handles acknowledgements until the connection fails or the
+// broker stops answering keep-alives
+func (c *Client) readLoop(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	for {
+		// The broker answers PINGREQ, sent every KeepAlive/2
+		conn.SetReadDeadline(time.Now().Add(c.opts.KeepAlive * 3 / 2))
+		first, body, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+
+		switch first >> 4 {
+		case packetPuback:
+			if len(body) != 2 {
+				return errMalformedPacket
+			}
+			c.mu.Lock()
+			delete(c.inflight, binary.BigEndian.Uint16(body))
+			c.mu.Unlock()
+			c.notify()
+		case packetPingresp:
+		default:
+			// Nothing is subscribed, so no other packets are expected
+		}
+	}
+}
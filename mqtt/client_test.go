@@ -0,0 +1,144 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestEncodeConnect tests the CONNECT packet against a hand-assembled one
+func TestEncodeConnect(t *testing.T) {
+	will := &Message{Topic: "s/status", Payload: []byte("offline"), QoS: 1, Retain: true}
+	got := encodeConnect("id", "user", "pw", 60, will)
+
+	want := []byte{0x10, 43,
+		0, 4, 'M', 'Q', 'T', 'T', 4, 0xEE, 0, 60,
+		0, 2, 'i', 'd',
+		0, 8, 's', '/', 's', 't', 'a', 't', 'u', 's',
+		0, 7, 'o', 'f', 'f', 'l', 'i', 'n', 'e',
+		0, 4, 'u', 's', 'e', 'r',
+		0, 2, 'p', 'w'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encodeConnect = % x\nwant % x", got, want)
+	}
+}
+
+// TestRemainingLength tests the variable length encoding round trip
+func TestRemainingLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 2097152, maxRemainingLength} {
+		b := appendRemainingLength([]byte{packetPublish << 4}, n)
+		b = append(b, make([]byte, n)...)
+		_, body, err := readPacket(bufio.NewReader(bytes.NewReader(b)))
+		if err != nil || len(body) != n {
+			t.Errorf("length %d: got %d, %v", n, len(body), err)
+		}
+	}
+}
+
+// fakeBroker accepts one connection at a time and records publishes
+type fakeBroker struct {
+	t        *testing.T
+	listener net.Listener
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return &fakeBroker{t: t, listener: l}
+}
+
+// accept completes the handshake of the next connection
+func (b *fakeBroker) accept() (net.Conn, *bufio.Reader) {
+	b.t.Helper()
+	conn, err := b.listener.Accept()
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	if first, _, err := readPacket(r); err != nil || first>>4 != packetConnect {
+		b.t.Fatalf("expected CONNECT, got %#x, %v", first, err)
+	}
+	conn.Write([]byte{packetConnack << 4, 2, 0, 0})
+	return conn, r
+}
+
+// readPublish reads the next PUBLISH, skipping keep-alives
+func (b *fakeBroker) readPublish(r *bufio.Reader) (first byte, topic string, id uint16, payload []byte) {
+	b.t.Helper()
+	for {
+		first, body, err := readPacket(r)
+		if err != nil {
+			b.t.Fatalf("reading PUBLISH: %v", err)
+		}
+		if first>>4 != packetPublish {
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		topic = string(body[2 : 2+n])
+		body = body[2+n:]
+		if (first>>1)&0x03 > 0 {
+			id = binary.BigEndian.Uint16(body)
+			body = body[2:]
+		}
+		return first, topic, id, body
+	}
+}
+
+// TestClientRedelivery tests that a QoS 1 message published while offline
+// is sent on connection and resent with DUP after an unacknowledged drop
+func TestClientRedelivery(t *testing.T) {
+	broker := newFakeBroker(t)
+
+	c := NewClient(Options{Broker: "tcp://" + broker.listener.Addr().String(), ClientID: "test", KeepAlive: 2 * time.Second})
+	if err := c.Publish("sensorgo/a/state", []byte(`{"t":1}`), 1, true); err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer c.Close()
+
+	conn, r := broker.accept()
+	first, topic, _, payload := broker.readPublish(r)
+	if topic != "sensorgo/a/state" || string(payload) != `{"t":1}` || first&0x01 == 0 || first&0x08 != 0 {
+		t.Fatalf("first delivery = %#x %s %s", first, topic, payload)
+	}
+	conn.Close() // Dropped before PUBACK
+
+	conn, r = broker.accept()
+	defer conn.Close()
+	first, _, id, payload := broker.readPublish(r)
+	if string(payload) != `{"t":1}` || first&0x08 == 0 {
+		t.Fatalf("redelivery = %#x %s, want DUP", first, payload)
+	}
+	conn.Write([]byte{packetPuback << 4, 2, byte(id >> 8), byte(id)})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := c.Pending(); n != 0 {
+		t.Errorf("Pending = %d after PUBACK, want 0", n)
+	}
+	if !c.Connected() {
+		t.Error("expected client to be connected")
+	}
+}
+
+// TestClientBuffer tests that the oldest buffered messages are dropped
+func TestClientBuffer(t *testing.T) {
+	c := NewClient(Options{Broker: "127.0.0.1:1", BufferSize: 2})
+	for _, p := range []string{"1", "2", "3"} {
+		c.Publish("t", []byte(p), 1, false)
+	}
+	c.Publish("t", []byte("qos0"), 0, false)
+
+	if len(c.queue) != 2 || string(c.queue[0].Payload) != "2" || string(c.queue[1].Payload) != "3" {
+		t.Errorf("queue = %d messages", len(c.queue))
+	}
+}
@@ -0,0 +1,159 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPuback     = 4
+	packetPingreq    = 12
+	packetPingresp   = 13
+	packetDisconnect = 14
+)
+
+// maxRemainingLength is the largest length the 4-byte variable length encoding allows
+const maxRemainingLength = 268435455
+
+var errMalformedPacket = errors.New("malformed MQTT packet")
+
+// connackReturnCodes describes the CONNACK return codes
+var connackReturnCodes = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// appendString appends a length-prefixed UTF-8 string
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// appendRemainingLength appends the variable length encoding of n
+func appendRemainingLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+// packet assembles a control packet from its first byte and body
+func packet(first byte, body []byte) []byte {
+	b := appendRemainingLength([]byte{first}, len(body))
+	return append(b, body...)
+}
+
+// encodeConnect builds a CONNECT packet with a clean session
+func encodeConnect(clientID, username, password string, keepAlive uint16, will *Message) []byte {
+	flags := byte(0x02) // Clean session
+	if will != nil {
+		flags |= 0x04 | will.QoS<<3
+		if will.Retain {
+			flags |= 0x20
+		}
+	}
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags) // Protocol level 4 (3.1.1)
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+	body = appendString(body, clientID)
+	if will != nil {
+		body = appendString(body, will.Topic)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(will.Payload)))
+		body = append(body, will.Payload...)
+	}
+	if username != "" {
+		body = appendString(body, username)
+		if password != "" {
+			body = appendString(body, password)
+		}
+	}
+
+	return packet(packetConnect<<4, body)
+}
+
+// encodePublish builds a PUBLISH packet; id is only sent for QoS 1
+func encodePublish(m *Message, id uint16, dup bool) []byte {
+	first := byte(packetPublish<<4) | m.QoS<<1
+	if m.Retain {
+		first |= 0x01
+	}
+	if dup {
+		first |= 0x08
+	}
+
+	body := appendString(nil, m.Topic)
+	if m.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, m.Payload...)
+
+	return packet(first, body)
+}
+
+// readPacket reads one control packet and returns its first byte and body
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errMalformedPacket
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7F) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return first, body, nil
+}
+
+// checkConnack returns an error unless body is an accepting CONNACK
+func checkConnack(first byte, body []byte) error {
+	if first>>4 != packetConnack || len(body) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", first>>4)
+	}
+	if code := body[1]; code != 0 {
+		if reason, ok := connackReturnCodes[code]; ok {
+			return fmt.Errorf("connection refused: %s", reason)
+		}
+		return fmt.Errorf("connection refused: return code %d", code)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sensorsgo/sensor"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default topic prefixes
+const (
+	DefaultTopicPrefix     = "sensorgo"
+	DefaultDiscoveryPrefix = "homeassistant"
+)
+
+// Availability payloads
+const (
+	payloadOnline  = "online"
+	payloadOffline = "offline"
+)
+
+// haField is how a reading field is presented in Home Assistant
+type haField struct {
+	name        string
+	deviceClass string
+	unit        string // Overrides sensor.Field.Unit where Home Assistant differs
+	diagnostic  bool
+}
+
+// haFields maps sensor.Field keys to Home Assistant entities. Fields not
+// listed are published in the state but get no entity.
+var haFields = map[string]haField{
+	"temperature":          {name: "Temperature", deviceClass: "temperature"},
+	"humidity":             {name: "Humidity", deviceClass: "humidity"},
+	"pressure":             {name: "Pressure", deviceClass: "atmospheric_pressure"},
+	"battery":              {name: "Battery voltage", deviceClass: "voltage", diagnostic: true},
+	"battery_percent":      {name: "Battery", deviceClass: "battery"},
+	"tx_power":             {name: "TX power", deviceClass: "signal_strength", diagnostic: true},
+	"acceleration_x":       {name: "Acceleration X", diagnostic: true},
+	"acceleration_y":       {name: "Acceleration Y", diagnostic: true},
+	"acceleration_z":       {name: "Acceleration Z", diagnostic: true},
+	"movement_counter":     {name: "Movement counter"},
+	"measurement_sequence": {name: "Measurement sequence", diagnostic: true},
+	"co2":                  {name: "CO2", deviceClass: "carbon_dioxide"},
+	"pm1_0":                {name: "PM1", deviceClass: "pm1"},
+	"pm2_5":                {name: "PM2.5", deviceClass: "pm25"},
+	"pm4_0":                {name: "PM4"},
+	"pm10_0":               {name: "PM10", deviceClass: "pm10"},
+	"voc_index":            {name: "VOC index"},
+	"nox_index":            {name: "NOx index"},
+	"luminosity":           {name: "Illuminance", deviceClass: "illuminance"},
+	"sound_level":          {name: "Sound level", deviceClass: "sound_pressure", unit: "dBA"},
+	"rssi":                 {name: "Signal strength", deviceClass: "signal_strength", unit: "dBm", diagnostic: true},
+}
+
+// manufacturers maps reading models to device manufacturers
+var manufacturers = map[string]string{
+	"ruuvi":      "Ruuvi Innovations",
+	"bthome":     "BTHome",
+	"xiaomi-atc": "Xiaomi",
+	"govee":      "Govee",
+	"switchbot":  "SwitchBot",
+}
+
+// Publisher publishes readings and sensor availability, announcing each
+// sensor to Home Assistant through MQTT discovery
+type Publisher struct {
+	client          *Client
+	topicPrefix     string
+	discoveryPrefix string // Empty disables discovery
+	node            string // Identifies this gateway, e.g. the hostname
+
+	mu        sync.Mutex
+	announced map[string]map[string]bool // Sensor ID -> announced fields
+}
+
+// NewPublisher creates a publisher for the given gateway node
+func NewPublisher(topicPrefix, discoveryPrefix, node string) *Publisher {
+	return &Publisher{
+		topicPrefix:     topicPrefix,
+		discoveryPrefix: discoveryPrefix,
+		node:            node,
+		announced:       make(map[string]map[string]bool),
+	}
+}
+
+// Connect creates the client for opts, with the gateway status as its
+// retained last will, and starts it
+func (p *Publisher) Connect(opts Options) *Client {
+	opts.Will = &Message{Topic: p.StatusTopic(), Payload: []byte(payloadOffline), QoS: 1, Retain: true}
+	opts.OnConnect = func(c *Client) {
+		c.Publish(p.StatusTopic(), []byte(payloadOnline), 1, true)
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = p.topicPrefix + "-" + p.node
+	}
+
+	p.client = NewClient(opts)
+	p.client.Start()
+	return p.client
+}
+
+// Close publishes the gateway as offline and disconnects
+func (p *Publisher) Close() error {
+	p.client.Publish(p.StatusTopic(), []byte(payloadOffline), 1, true)
+	return p.client.Close()
+}
+
+// StatusTopic is the retained online/offline topic of the gateway
+func (p *Publisher) StatusTopic() string {
+	return fmt.Sprintf("%s/%s/status", p.topicPrefix, p.node)
+}
+
+// StateTopic is the retained topic holding the latest reading of a sensor
+func (p *Publisher) StateTopic(mac string) string {
+	return fmt.Sprintf("%s/%s/state", p.topicPrefix, sensorID(mac))
+}
+
+// AvailabilityTopic is the retained online/offline topic of a sensor
+func (p *Publisher) AvailabilityTopic(mac string) string {
+	return fmt.Sprintf("%s/%s/availability", p.topicPrefix, sensorID(mac))
+}
+
+// PublishReading publishes a reading as retained JSON state, announcing
+// any field not seen before for this sensor
+func (p *Publisher) PublishReading(mac, name string, data *sensor.RuuviData) error {
+	values := data.Values()
+	if data.RSSI != 0 {
+		values["rssi"] = float64(data.RSSI)
+	}
+
+	if p.discoveryPrefix != "" {
+		if err := p.announce(mac, name, data.Model, values); err != nil {
+			return err
+		}
+	}
+
+	state := make(map[string]interface{}, len(values)+3)
+	for key, value := range values {
+		state[key] = value
+	}
+	state["mac"] = mac
+	state["model"] = data.Model
+	measuredAt := data.Timestamp
+	if measuredAt.IsZero() {
+		measuredAt = time.Now()
+	}
+	state["measured_at"] = measuredAt.UTC().Format(time.RFC3339)
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return p.client.Publish(p.StateTopic(mac), payload, 1, true)
+}
+
+// PublishAvailability publishes whether a sensor is being received
+func (p *Publisher) PublishAvailability(mac string, online bool) error {
+	payload := payloadOffline
+	if online {
+		payload = payloadOnline
+	}
+	return p.client.Publish(p.AvailabilityTopic(mac), []byte(payload), 1, true)
+}
+
+// announce publishes the retained discovery config of new fields
+func (p *Publisher) announce(mac, name, model string, values map[string]float64) error {
+	id := sensorID(mac)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	announced := p.announced[id]
+	if announced == nil {
+		announced = make(map[string]bool)
+		p.announced[id] = announced
+	}
+
+	for key := range values {
+		if announced[key] {
+			continue
+		}
+		field, ok := haFields[key]
+		if !ok {
+			continue
+		}
+
+		payload, err := json.Marshal(p.discoveryConfig(mac, name, model, key, field))
+		if err != nil {
+			return err
+		}
+		topic := fmt.Sprintf("%s/sensor/%s_%s/%s/config", p.discoveryPrefix, p.topicPrefix, id, key)
+		if err := p.client.Publish(topic, payload, 1, true); err != nil {
+			return err
+		}
+		announced[key] = true
+	}
+	return nil
+}
+
+// discoveryConfig builds the Home Assistant MQTT discovery config of one field
+func (p *Publisher) discoveryConfig(mac, name, model, key string, field haField) map[string]interface{} {
+	id := sensorID(mac)
+	if name == "" {
+		name = mac
+	}
+
+	config := map[string]interface{}{
+		"name":           field.name,
+		"unique_id":      fmt.Sprintf("%s_%s_%s", p.topicPrefix, id, key),
+		"state_topic":    p.StateTopic(mac),
+		"value_template": fmt.Sprintf("{{ value_json.%s }}", key),
+		"state_class":    "measurement",
+		"availability": []map[string]string{
+			{"topic": p.StatusTopic()},
+			{"topic": p.AvailabilityTopic(mac)},
+		},
+		"availability_mode": "all",
+	}
+
+	device := map[string]interface{}{
+		"identifiers": []string{fmt.Sprintf("%s_%s", p.topicPrefix, id)},
+		"connections": [][]string{{"mac", strings.ToLower(mac)}},
+		"name":        name,
+		"model":       model,
+		"via_device":  fmt.Sprintf("%s_%s", p.topicPrefix, p.node),
+	}
+	if manufacturer, ok := manufacturers[model]; ok {
+		device["manufacturer"] = manufacturer
+	}
+	config["device"] = device
+
+	if field.deviceClass != "" {
+		config["device_class"] = field.deviceClass
+	}
+	unit := field.unit
+	if unit == "" {
+		for _, f := range sensor.Fields {
+			if f.Key == key {
+				unit = f.Unit
+			}
+		}
+	}
+	if unit != "" {
+		config["unit_of_measurement"] = unit
+	}
+	if field.diagnostic {
+		config["entity_category"] = "diagnostic"
+	}
+	return config
+}
+
+// sensorID turns a MAC into a topic and ID friendly string
+func sensorID(mac string) string {
+	return strings.ToLower(strings.ReplaceAll(mac, ":", ""))
+}
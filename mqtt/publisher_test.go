@@ -0,0 +1,61 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"sensorsgo/sensor"
+	"testing"
+	"time"
+)
+
+// TestPublishReading tests the state and Home Assistant discovery messages
+func TestPublishReading(t *testing.T) {
+	p := NewPublisher(DefaultTopicPrefix, DefaultDiscoveryPrefix, "pi")
+	p.client = NewClient(Options{})
+
+	temperature := 21.5
+	data := &sensor.RuuviData{
+		Model:       "ruuvi",
+		RSSI:        -70,
+		Temperature: &temperature,
+		Timestamp:   time.Date(2026, 2, 3, 15, 30, 45, 0, time.UTC),
+	}
+	if err := p.PublishReading("CB:B8:33:4C:88:4F", "Invernadero", data); err != nil {
+		t.Fatalf("PublishReading: %v", err)
+	}
+
+	messages := make(map[string]*Message)
+	for _, m := range p.client.queue {
+		messages[m.Topic] = m
+	}
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want temperature and rssi configs and the state", len(messages))
+	}
+
+	state := messages["sensorgo/cbb8334c884f/state"]
+	if state == nil || !state.Retain || state.QoS != 1 {
+		t.Fatalf("state = %+v", state)
+	}
+	var values map[string]interface{}
+	json.Unmarshal(state.Payload, &values)
+	if values["temperature"] != 21.5 || values["measured_at"] != "2026-02-03T15:30:45Z" {
+		t.Errorf("state payload = %s", state.Payload)
+	}
+
+	config := messages["homeassistant/sensor/sensorgo_cbb8334c884f/temperature/config"]
+	if config == nil || !config.Retain {
+		t.Fatalf("missing temperature discovery config")
+	}
+	var discovery map[string]interface{}
+	json.Unmarshal(config.Payload, &discovery)
+	if discovery["device_class"] != "temperature" || discovery["unit_of_measurement"] != "°C" ||
+		discovery["state_topic"] != "sensorgo/cbb8334c884f/state" {
+		t.Errorf("discovery config = %s", config.Payload)
+	}
+
+	// Fields are only announced once
+	p.client.queue = nil
+	p.PublishReading("CB:B8:33:4C:88:4F", "Invernadero", data)
+	if len(p.client.queue) != 1 {
+		t.Errorf("second reading published %d messages, want 1", len(p.client.queue))
+	}
+}
@@ -0,0 +1,415 @@
+// Package queue implements a durable FIFO queue stored as append-only
+// segment files on disk. Entries survive process restarts and are only
+// removed after they have been acknowledged.
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sensorsgo/internal/frame"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentExt = ".seg"
+	headFile   = "head"
+	// DefaultSegmentSize is the size after which a new segment is started
+	DefaultSegmentSize = 1 << 20
+)
+
+// Queue is a persistent append-only queue. It is safe for concurrent use.
+type Queue struct {
+	dir         string
+	segmentSize int64
+
+	mu       sync.Mutex
+	segments []uint64 // segment ids, oldest first
+	headSeg  uint64   // segment holding the oldest unacknowledged entry
+	headOff  int64    // offset of that entry inside headSeg
+	tail     *os.File // active segment, opened for appending
+	tailSize int64
+	pending  int
+}
+
+// Open opens (or creates) the queue stored in dir, recovering from any
+// partially written record left by a crash.
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating queue dir: %w", err)
+	}
+
+	q := &Queue{dir: dir, segmentSize: DefaultSegmentSize}
+
+	segments, err := q.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	q.segments = segments
+
+	if err := q.loadHead(); err != nil {
+		return nil, err
+	}
+
+	// Drop segments that were fully acknowledged before the last shutdown
+	for len(q.segments) > 0 && q.segments[0] < q.headSeg {
+		os.Remove(q.segmentPath(q.segments[0]))
+		q.segments = q.segments[1:]
+	}
+
+	if len(q.segments) == 0 {
+		q.segments = []uint64{q.headSeg}
+		q.headOff = 0
+	} else if q.segments[0] > q.headSeg {
+		q.headSeg = q.segments[0]
+		q.headOff = 0
+	}
+
+	if err := q.recover(); err != nil {
+		return nil, err
+	}
+
+	if err := q.openTail(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// SetSegmentSize changes the size threshold used to rotate segments
+func (q *Queue) SetSegmentSize(size int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.segmentSize = size
+}
+
+// Len returns the number of unacknowledged entries
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending
+}
+
+// Append durably adds an entry to the end of the queue
+func (q *Queue) Append(data []byte) error {
+	if len(data) > frame.MaxSize {
+		return fmt.Errorf("entry too large (%d bytes)", len(data))
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.tail == nil {
+		return errors.New("queue closed")
+	}
+
+	if q.tailSize > 0 && q.tailSize+int64(len(data)+frame.HeaderLen) > q.segmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	record := frame.Encode(data)
+
+	if _, err := q.tail.Write(record); err != nil {
+		return fmt.Errorf("writing entry: %w", err)
+	}
+	if err := q.tail.Sync(); err != nil {
+		return fmt.Errorf("syncing segment: %w", err)
+	}
+
+	q.tailSize += int64(len(record))
+	q.pending++
+	return nil
+}
+
+// Peek returns up to max entries from the head of the queue without
+// removing them
+func (q *Queue) Peek(max int) ([][]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var entries [][]byte
+	err := q.walk(max, func(data []byte) {
+		entries = append(entries, data)
+	})
+	return entries, err
+}
+
+// Ack removes n entries from the head of the queue. Segments that no
+// longer hold pending entries are deleted.
+func (q *Queue) Ack(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n > q.pending {
+		n = q.pending
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	seg, off := q.headSeg, q.headOff
+	acked := 0
+	for acked < n {
+		_, next, err := q.readAt(seg, off)
+		if err == io.EOF || err == frame.ErrCorrupt {
+			nextSeg, ok := q.segmentAfter(seg)
+			if !ok {
+				break
+			}
+			seg, off = nextSeg, 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		off = next
+		acked++
+	}
+
+	// Move past segments that have been fully consumed
+	for {
+		nextSeg, ok := q.segmentAfter(seg)
+		if !ok {
+			break
+		}
+		if _, _, err := q.readAt(seg, off); err != io.EOF && err != frame.ErrCorrupt {
+			break
+		}
+		seg, off = nextSeg, 0
+	}
+
+	if err := q.saveHead(seg, off); err != nil {
+		return err
+	}
+	q.headSeg, q.headOff = seg, off
+	q.pending -= acked
+
+	// Compact: remove every segment before the new head
+	for len(q.segments) > 1 && q.segments[0] < q.headSeg {
+		if err := os.Remove(q.segmentPath(q.segments[0])); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing segment: %w", err)
+		}
+		q.segments = q.segments[1:]
+	}
+
+	return nil
+}
+
+// Close closes the active segment
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.tail == nil {
+		return nil
+	}
+	err := q.tail.Close()
+	q.tail = nil
+	return err
+}
+
+// walk calls fn for up to max pending entries, starting at the head.
+// max <= 0 visits every entry.
+func (q *Queue) walk(max int, fn func([]byte)) error {
+	seg, off := q.headSeg, q.headOff
+	count := 0
+	for max <= 0 || count < max {
+		data, next, err := q.readAt(seg, off)
+		if err == io.EOF || err == frame.ErrCorrupt {
+			nextSeg, ok := q.segmentAfter(seg)
+			if !ok {
+				return nil
+			}
+			seg, off = nextSeg, 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fn(data)
+		off = next
+		count++
+	}
+	return nil
+}
+
+// readAt reads the record stored at off in segment seg
+func (q *Queue) readAt(seg uint64, off int64) ([]byte, int64, error) {
+	f, err := os.Open(q.segmentPath(seg))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	return frame.Read(bufio.NewReader(f), off)
+}
+
+// recover counts pending entries and truncates a torn record at the end
+// of the last segment
+func (q *Queue) recover() error {
+	q.pending = 0
+	last := q.segments[len(q.segments)-1]
+
+	for _, seg := range q.segments {
+		off := int64(0)
+		if seg == q.headSeg {
+			off = q.headOff
+		}
+
+		f, err := os.Open(q.segmentPath(seg))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("opening segment: %w", err)
+		}
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+
+		r := bufio.NewReader(f)
+		for {
+			_, next, err := frame.Read(r, off)
+			if err != nil {
+				break
+			}
+			off = next
+			q.pending++
+		}
+		f.Close()
+
+		if seg == last {
+			info, err := os.Stat(q.segmentPath(seg))
+			if err == nil && info.Size() > off {
+				if err := os.Truncate(q.segmentPath(seg), off); err != nil {
+					return fmt.Errorf("truncating segment: %w", err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (q *Queue) openTail() error {
+	last := q.segments[len(q.segments)-1]
+	f, err := os.OpenFile(q.segmentPath(last), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening segment: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	q.tail = f
+	q.tailSize = info.Size()
+	return nil
+}
+
+func (q *Queue) rotate() error {
+	if err := q.tail.Close(); err != nil {
+		return fmt.Errorf("closing segment: %w", err)
+	}
+	q.segments = append(q.segments, q.segments[len(q.segments)-1]+1)
+	return q.openTail()
+}
+
+func (q *Queue) segmentAfter(seg uint64) (uint64, bool) {
+	for _, s := range q.segments {
+		if s > seg {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func (q *Queue) segmentPath(seg uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016d%s", seg, segmentExt))
+}
+
+func (q *Queue) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading queue dir: %w", err)
+	}
+
+	var segments []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, id)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// loadHead reads the acknowledged position. A missing file means nothing
+// has been acknowledged yet.
+func (q *Queue) loadHead() error {
+	data, err := os.ReadFile(filepath.Join(q.dir, headFile))
+	if os.IsNotExist(err) {
+		q.headSeg, q.headOff = 1, 0
+		if len(q.segments) > 0 {
+			q.headSeg = q.segments[0]
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading queue head: %w", err)
+	}
+	if len(data) != 16 {
+		return fmt.Errorf("invalid queue head file (%d bytes)", len(data))
+	}
+	q.headSeg = binary.BigEndian.Uint64(data[0:8])
+	q.headOff = int64(binary.BigEndian.Uint64(data[8:16]))
+	return nil
+}
+
+// saveHead atomically persists the acknowledged position
+func (q *Queue) saveHead(seg uint64, off int64) error {
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[0:8], seg)
+	binary.BigEndian.PutUint64(data[8:16], uint64(off))
+
+	path := filepath.Join(q.dir, headFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("writing queue head: %w", err)
+	}
+	if _, err := f.Write(data[:]); err != nil {
+		f.Close()
+		return fmt.Errorf("writing queue head: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing queue head: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,142 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAppendPeekAck tests the basic FIFO behaviour
+func TestAppendPeekAck(t *testing.T) {
+	q, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer q.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := q.Append([]byte(fmt.Sprintf("entry-%d", i))); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	if q.Len() != 5 {
+		t.Fatalf("Len() = %d, want 5", q.Len())
+	}
+
+	entries, err := q.Peek(2)
+	if err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+	if len(entries) != 2 || string(entries[0]) != "entry-0" || string(entries[1]) != "entry-1" {
+		t.Fatalf("Peek(2) = %q", entries)
+	}
+
+	if err := q.Ack(2); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	entries, _ = q.Peek(1)
+	if len(entries) != 1 || string(entries[0]) != "entry-2" {
+		t.Fatalf("Peek after Ack = %q, want entry-2", entries)
+	}
+	if q.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", q.Len())
+	}
+}
+
+// TestReopen tests that pending entries and the acknowledged position
+// survive a restart
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	q.Append([]byte("a"))
+	q.Append([]byte("b"))
+	q.Append([]byte("c"))
+	q.Ack(1)
+	q.Close()
+
+	q, err = Open(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer q.Close()
+
+	if q.Len() != 2 {
+		t.Fatalf("Len() after reopen = %d, want 2", q.Len())
+	}
+	entries, _ := q.Peek(0)
+	if len(entries) != 2 || string(entries[0]) != "b" || string(entries[1]) != "c" {
+		t.Errorf("Peek after reopen = %q", entries)
+	}
+}
+
+// TestTornWrite tests recovery from a partially written record
+func TestTornWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	q, _ := Open(dir)
+	q.Append([]byte("complete"))
+	q.Close()
+
+	segment := filepath.Join(dir, fmt.Sprintf("%016d%s", 1, segmentExt))
+	f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("opening segment: %v", err)
+	}
+	f.Write([]byte{0, 0, 0, 10, 1, 2})
+	f.Close()
+
+	q, err = Open(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer q.Close()
+
+	if q.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", q.Len())
+	}
+
+	q.Append([]byte("after"))
+	entries, _ := q.Peek(0)
+	if len(entries) != 2 || string(entries[1]) != "after" {
+		t.Errorf("Peek after recovery = %q", entries)
+	}
+}
+
+// TestCompaction tests that acknowledged segments are removed
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	q, _ := Open(dir)
+	defer q.Close()
+	q.SetSegmentSize(32)
+
+	for i := 0; i < 10; i++ {
+		q.Append([]byte(fmt.Sprintf("reading-%02d", i)))
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(segments) < 2 {
+		t.Fatalf("expected several segments, got %d", len(segments))
+	}
+
+	if err := q.Ack(9); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	segments, _ = filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(segments) != 1 {
+		t.Errorf("segments after Ack = %d, want 1", len(segments))
+	}
+
+	entries, _ := q.Peek(0)
+	if len(entries) != 1 || string(entries[0]) != "reading-09" {
+		t.Errorf("Peek after compaction = %q", entries)
+	}
+}
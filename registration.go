@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sensorsgo/scanner"
+	"sensorsgo/sensor"
+	"sensorsgo/ui"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// candidate es un sensor oído durante el registro interactivo
+type candidate struct {
+	number   int // Posición en la lista, no cambia mientras dura el escaneo
+	mac      string
+	name     string
+	model    string
+	rssi     int16  // RSSI del último anuncio
+	movement *uint8 // Contador de movimiento de la primera lectura
+	tapped   string // Cómo se confirmó en modo tap ("movimiento", "cerca"), vacío si no
+}
+
+// update registra un nuevo anuncio del sensor. Cuenta como confirmado si
+// su contador de movimiento cambia (se ha movido o golpeado) o si se oye
+// con al menos tapRSSI dBm (está pegado al equipo).
+func (c *candidate) update(adv *sensor.Advertisement, data *sensor.RuuviData, tapRSSI int) {
+	c.rssi = adv.RSSI
+	if c.name == "" {
+		c.name = adv.LocalName
+	}
+	if data == nil {
+		return
+	}
+	c.model = data.Model
+
+	if data.MovementCounter != nil {
+		if c.movement == nil {
+			first := *data.MovementCounter
+			c.movement = &first
+		} else if *data.MovementCounter != *c.movement && c.tapped == "" {
+			c.tapped = "movimiento"
+		}
+	}
+	if adv.RSSI != 0 && int(adv.RSSI) >= tapRSSI && c.tapped == "" {
+		c.tapped = "cerca"
+	}
+}
+
+// registerSensors escanea y devuelve los sensores a autorizar según
+// registration_mode. Sin terminal no se puede preguntar, así que se
+// autorizan todos los sensores encontrados.
+func registerSensors(sources []scanner.Scanner) []AuthorizedSensor {
+	// Preparar el adaptador antes de escanear, para que su espera no se
+	// coma el tiempo de registration_scan
+	if !prepareSources(sources) {
+		return nil
+	}
+
+	mode := cfg.RegistrationMode
+	if mode != "all" && !isTerminal(os.Stdin) {
+		fmt.Fprintf(console, "⚠️  La entrada no es un terminal: se autorizarán todos los sensores encontrados (registration_mode = \"all\")\n")
+		mode = "all"
+	}
+	if mode == "all" {
+		return registerAll(sources)
+	}
+	return registerInteractive(sources, mode == "tap")
+}
+
+// prepareSources deja listas las fuentes que lo necesitan (p. ej. activa
+// el adaptador Bluetooth). Devuelve false si alguna falla.
+func prepareSources(sources []scanner.Scanner) bool {
+	for _, sc := range sources {
+		if err := scanner.Prepare(context.Background(), sc); err != nil {
+			fmt.Fprintf(console, "❌ Error preparando el escáner: %v\n", err)
+			return false
+		}
+	}
+	return true
+}
+
+// registerAll autoriza todos los sensores compatibles oídos durante
+// registration_scan
+func registerAll(sources []scanner.Scanner) []AuthorizedSensor {
+	fmt.Fprintln(console, "🔍 Escaneando sensores (RuuviTag, BTHome, Xiaomi ATC/PVVX, Govee, SwitchBot) para registrarlos...")
+	fmt.Fprintf(console, "⏱️  Escaneando durante %v...\n", cfg.RegistrationScan)
+
+	var found []AuthorizedSensor
+	seen := make(map[string]bool)
+
+	// Escanear durante el tiempo configurado
+	var foundMu sync.Mutex
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RegistrationScan)
+	defer cancel()
+	scanSources(ctx, sources, func(adv *sensor.Advertisement) {
+		if !isSupportedSensor(adv) {
+			return
+		}
+		foundMu.Lock()
+		defer foundMu.Unlock()
+		if !seen[adv.Address] {
+			seen[adv.Address] = true
+			sensor := AuthorizedSensor{
+				MAC:          adv.Address,
+				Name:         adv.LocalName,
+				RegisteredAt: time.Now(),
+			}
+			found = append(found, sensor)
+			fmt.Fprintf(console, "✅ Sensor registrado: %s (%s)\n", sensor.Name, sensor.MAC)
+		}
+	})
+	return found
+}
+
+// registerInteractive muestra en vivo los sensores oídos con su RSSI
+// hasta que el operador pulsa Enter, y le pregunta cuáles autorizar. En
+// modo tap solo se pueden elegir los sensores confirmados.
+func registerInteractive(sources []scanner.Scanner, tap bool) []AuthorizedSensor {
+	var mu sync.Mutex
+	var candidates []*candidate
+	byMAC := make(map[string]*candidate)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scanned := make(chan struct{})
+	go func() {
+		defer close(scanned)
+		scanSources(ctx, sources, func(adv *sensor.Advertisement) {
+			if !isSupportedSensor(adv) {
+				return
+			}
+			data, _ := decoders.Decode(adv)
+
+			mu.Lock()
+			defer mu.Unlock()
+			c := byMAC[adv.Address]
+			if c == nil {
+				c = &candidate{number: len(candidates) + 1, mac: adv.Address}
+				candidates = append(candidates, c)
+				byMAC[adv.Address] = c
+			}
+			c.update(adv, data, cfg.TapRSSI)
+		})
+	}()
+
+	// Lista en vivo hasta que se pulse Enter
+	lines := readLines(os.Stdin)
+	ticker := time.NewTicker(time.Second)
+	for waiting := true; waiting; {
+		mu.Lock()
+		fmt.Fprint(console, ui.Clear)
+		printCandidates(candidates, tap)
+		mu.Unlock()
+		if tap {
+			fmt.Fprintf(console, "\n👆 Mueve o golpea cada uno de tus sensores, o acércalo al equipo (%d dBm o más), hasta que aparezca como confirmado.\n", cfg.TapRSSI)
+		}
+		fmt.Fprintln(console, "⏎  Pulsa Enter cuando aparezcan todos tus sensores")
+
+		select {
+		case <-ticker.C:
+		case <-lines:
+			waiting = false
+		}
+	}
+	ticker.Stop()
+	cancel()
+	<-scanned
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(console)
+	printCandidates(candidates, tap)
+	for {
+		if tap {
+			fmt.Fprint(console, "\nNúmeros de los sensores a autorizar (Enter = todos los confirmados, q = cancelar): ")
+		} else {
+			fmt.Fprint(console, "\nNúmeros de los sensores a autorizar, p. ej. 1 3 4 (todos = todos, Enter = cancelar): ")
+		}
+		line, ok := <-lines
+		if !ok {
+			return nil
+		}
+
+		selected, err := parseSelection(line, candidates, tap)
+		if err != nil {
+			fmt.Fprintf(console, "❌ %v\n", err)
+			continue
+		}
+		if len(selected) == 0 {
+			fmt.Fprintln(console, "⚠️  No se ha elegido ningún sensor")
+			return nil
+		}
+
+		var sensors []AuthorizedSensor
+		for _, c := range selected {
+			sensors = append(sensors, AuthorizedSensor{MAC: c.mac, Name: c.name, RegisteredAt: time.Now()})
+			fmt.Fprintf(console, "✅ Sensor registrado: %s (%s)\n", c.name, c.mac)
+		}
+		return sensors
+	}
+}
+
+// parseSelection interpreta la respuesta del operador: números de la
+// lista separados por espacios o comas, "todos", o Enter (en modo tap,
+// todos los confirmados; si no, ninguno)
+func parseSelection(line string, candidates []*candidate, tap bool) ([]*candidate, error) {
+	line = strings.TrimSpace(strings.ToLower(line))
+
+	var selected []*candidate
+	switch line {
+	case "q":
+		return nil, nil
+	case "":
+		if !tap {
+			return nil, nil
+		}
+		fallthrough
+	case "todos":
+		for _, c := range candidates {
+			if !tap || c.tapped != "" {
+				selected = append(selected, c)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("ningún sensor está confirmado")
+		}
+		return selected, nil
+	}
+
+	chosen := make(map[int]bool)
+	for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == ',' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 || n > len(candidates) {
+			return nil, fmt.Errorf("%q no es un número de la lista (1-%d)", field, len(candidates))
+		}
+		c := candidates[n-1]
+		if tap && c.tapped == "" {
+			return nil, fmt.Errorf("el sensor %d (%s) no está confirmado", n, c.mac)
+		}
+		if !chosen[n] {
+			chosen[n] = true
+			selected = append(selected, c)
+		}
+	}
+	return selected, nil
+}
+
+// printCandidates muestra la tabla de sensores encontrados
+func printCandidates(candidates []*candidate, tap bool) {
+	fmt.Fprintf(console, "🔍 Sensores encontrados: %d\n\n", len(candidates))
+	if len(candidates) == 0 {
+		fmt.Fprintln(console, "   (ninguno todavía; asegúrate de que estén encendidos y cerca)")
+		return
+	}
+
+	w := tabwriter.NewWriter(console, 0, 0, 2, ' ', 0)
+	header := "#\tMAC\tNOMBRE\tMODELO\tRSSI"
+	if tap {
+		header += "\tCONFIRMADO"
+	}
+	fmt.Fprintln(w, header)
+	for _, c := range candidates {
+		row := fmt.Sprintf("%d\t%s\t%s\t%s\t%d dBm", c.number, c.mac, c.name, c.model, c.rssi)
+		if tap && c.tapped != "" {
+			row += "\t👆 " + c.tapped
+		}
+		fmt.Fprintln(w, row)
+	}
+	w.Flush()
+}
+
+// readLines devuelve las líneas leídas de f; el canal se cierra al
+// terminar la entrada
+func readLines(f *os.File) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+// isTerminal indica si f es un terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sensorsgo/scanner"
+	"sensorsgo/sensor"
+	"sensorsgo/settings"
+	"testing"
+	"time"
+)
+
+// slowScanner is a scanner whose backend takes delay to get ready, like
+// the tinygo one waiting for the adapter, and then hears one sensor
+type slowScanner struct {
+	delay    time.Duration
+	prepared bool
+}
+
+func (s *slowScanner) Prepare(ctx context.Context) error {
+	if s.prepared {
+		return nil
+	}
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.prepared = true
+	return nil
+}
+
+func (s *slowScanner) Start(ctx context.Context) (<-chan *sensor.Advertisement, error) {
+	ads := make(chan *sensor.Advertisement, 1)
+	go func() {
+		defer close(ads)
+		if s.Prepare(ctx) != nil {
+			return
+		}
+		ads <- &sensor.Advertisement{Address: "C4:7C:8D:6A:1B:2E", LocalName: "Ruuvi 1B2E", Timestamp: time.Now()}
+		<-ctx.Done()
+	}()
+	return ads, nil
+}
+
+func (s *slowScanner) Stop() error { return nil }
+func (s *slowScanner) Err() error  { return nil }
+
+// TestRegisterSlowScanner tests that the registration scan only starts
+// counting once the scanner is ready, also when it is recorded
+func TestRegisterSlowScanner(t *testing.T) {
+	cfg = settings.Default()
+	cfg.RegistrationMode = "all"
+	cfg.RegistrationScan = 50 * time.Millisecond
+
+	recorded, err := scanner.Record(&slowScanner{delay: 4 * cfg.RegistrationScan}, filepath.Join(t.TempDir(), "capture.jsonl"))
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	defer recorded.Close()
+
+	for _, sc := range []scanner.Scanner{&slowScanner{delay: 4 * cfg.RegistrationScan}, recorded} {
+		sensors := registerSensors([]scanner.Scanner{sc})
+		if len(sensors) != 1 || sensors[0].MAC != "C4:7C:8D:6A:1B:2E" {
+			t.Errorf("%T: registered %+v, want the sensor heard after the adapter got ready", sc, sensors)
+		}
+	}
+}
+
+// TestParseSelection tests the answers accepted when choosing sensors
+func TestParseSelection(t *testing.T) {
+	candidates := []*candidate{
+		{number: 1, mac: "A", tapped: "movimiento"},
+		{number: 2, mac: "B"},
+		{number: 3, mac: "C", tapped: "cerca"},
+	}
+	tests := []struct {
+		line string
+		tap  bool
+		want string // MACs selected, or "error"
+	}{
+		{"1 3", false, "AC"},
+		{"3,1, 3", false, "CA"}, // Duplicates are chosen once
+		{" TODOS ", false, "ABC"},
+		{"", false, ""}, // Enter cancels
+		{"q", false, ""},
+		{"4", false, "error"},
+		{"0", false, "error"},
+		{"1 dos", false, "error"},
+		{"", true, "AC"}, // Enter chooses the confirmed sensors
+		{"todos", true, "AC"},
+		{"3", true, "C"},
+		{"2", true, "error"}, // Not confirmed
+		{"q", true, ""},
+	}
+	for _, tt := range tests {
+		selected, err := parseSelection(tt.line, candidates, tt.tap)
+		got := ""
+		for _, c := range selected {
+			got += c.mac
+		}
+		if err != nil {
+			got = "error"
+		}
+		if got != tt.want {
+			t.Errorf("parseSelection(%q, tap %v) = %q (%v), want %q", tt.line, tt.tap, got, err, tt.want)
+		}
+	}
+
+	if _, err := parseSelection("", []*candidate{{number: 1, mac: "A"}}, true); err == nil {
+		t.Error("Enter in tap mode without confirmed sensors: expected error")
+	}
+}
+
+// TestCandidateUpdate tests how a sensor is confirmed in tap mode
+func TestCandidateUpdate(t *testing.T) {
+	reading := func(movement *uint8) *sensor.RuuviData {
+		return &sensor.RuuviData{Model: "ruuvi", MovementCounter: movement}
+	}
+	counter := func(n uint8) *uint8 { return &n }
+
+	tests := []struct {
+		name    string
+		updates []*sensor.RuuviData
+		rssi    []int16
+		want    string
+	}{
+		{"still and far", []*sensor.RuuviData{reading(counter(7)), reading(counter(7))}, []int16{-70, -72}, ""},
+		{"moved", []*sensor.RuuviData{reading(counter(7)), reading(counter(8))}, []int16{-70, -70}, "movimiento"},
+		{"counter wraps", []*sensor.RuuviData{reading(counter(255)), reading(counter(0))}, []int16{-70, -70}, "movimiento"},
+		{"close", []*sensor.RuuviData{reading(nil), reading(nil)}, []int16{-70, -35}, "cerca"},
+		{"threshold", []*sensor.RuuviData{reading(nil)}, []int16{-40}, "cerca"},
+		{"unknown RSSI", []*sensor.RuuviData{reading(nil)}, []int16{0}, ""},
+		{"undecoded", []*sensor.RuuviData{nil}, []int16{-30}, ""},
+		{"first reason kept", []*sensor.RuuviData{reading(counter(1)), reading(counter(2)), reading(counter(2))}, []int16{-70, -70, -30}, "movimiento"},
+	}
+	for _, tt := range tests {
+		c := &candidate{number: 1, mac: "A"}
+		for i, data := range tt.updates {
+			c.update(&sensor.Advertisement{Address: "A", LocalName: "Ruuvi 1B2E", RSSI: tt.rssi[i]}, data, -40)
+		}
+		if c.tapped != tt.want {
+			t.Errorf("%s: tapped = %q, want %q", tt.name, c.tapped, tt.want)
+		}
+		if c.name != "Ruuvi 1B2E" || c.rssi != tt.rssi[len(tt.rssi)-1] {
+			t.Errorf("%s: name %q, RSSI %d", tt.name, c.name, c.rssi)
+		}
+	}
+}
+
+// TestRegisterWithoutTerminal tests that every sensor is authorized when
+// the operator cannot be asked
+func TestRegisterWithoutTerminal(t *testing.T) {
+	cfg = settings.Default()
+	cfg.RegistrationMode = "tap"
+	cfg.RegistrationScan = 50 * time.Millisecond
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	sensors := registerSensors([]scanner.Scanner{&slowScanner{}})
+	if len(sensors) != 1 {
+		t.Errorf("registered %+v, want the sensor heard", sensors)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sensorsgo/settings"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// reloadPoll es cada cuánto se comprueba si los archivos de configuración
+// han cambiado
+const reloadPoll = 2 * time.Second
+
+// sensorSet es la lista de sensores autorizados en uso. Al recargar se
+// reemplaza entera, así el escaneo nunca ve una lista a medio cambiar.
+type sensorSet struct {
+	config     *Config
+	authorized map[string]bool
+	names      map[string]string
+}
+
+var (
+	currentSensors      atomic.Pointer[sensorSet]
+	onlineTimeout       atomic.Int64                  // time.Duration tras la que un sensor pasa a offline
+	sendIntervalChanges = make(chan time.Duration, 1) // Nuevo intervalo de envío tras una recarga
+)
+
+// newSensorSet crea los mapas de búsqueda rápida de config
+func newSensorSet(config *Config) *sensorSet {
+	set := &sensorSet{
+		config:     config,
+		authorized: make(map[string]bool),
+		names:      make(map[string]string),
+	}
+	for _, sensor := range config.Sensors {
+		set.authorized[sensor.MAC] = !sensor.Disabled
+		set.names[sensor.MAC] = sensor.Name
+	}
+	return set
+}
+
+// reloader vuelve a leer el archivo de configuración y la lista de
+// sensores con SIGHUP o cuando cambian, y aplica lo que se puede cambiar
+// sin reiniciar el escaneo
+type reloader struct {
+	path     string
+	required bool
+	cmdline  *settings.CommandLine
+
+	current *settings.Settings
+	stamps  map[string]fileStamp
+}
+
+// fileStamp identifica una versión de un archivo; es cero si no existe
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// run espera señales y cambios en los archivos hasta que ctx termina
+func (r *reloader) run(ctx context.Context) {
+	r.current = cfg
+	r.stamps = r.snapshot()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(reloadPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-ticker.C:
+			if r.changed() {
+				r.reload("archivo modificado")
+			}
+		}
+	}
+}
+
+// snapshot devuelve la versión actual de los archivos vigilados
+func (r *reloader) snapshot() map[string]fileStamp {
+	return map[string]fileStamp{
+		r.path:          statFile(r.path),
+		cfg.SensorsFile: statFile(cfg.SensorsFile),
+	}
+}
+
+// changed indica si algún archivo vigilado ha cambiado desde la última
+// comprobación
+func (r *reloader) changed() bool {
+	stamps := r.snapshot()
+	defer func() { r.stamps = stamps }()
+
+	for path, stamp := range stamps {
+		previous, ok := r.stamps[path]
+		if !ok || !previous.modTime.Equal(stamp.modTime) || previous.size != stamp.size {
+			return true
+		}
+	}
+	return false
+}
+
+// reload aplica la configuración y la lista de sensores actuales. Si la
+// configuración no es válida se mantiene la que está en uso. La lista se
+// sigue leyendo de cfg.SensorsFile, que es donde la editan el subcomando
+// sensors y la API local: un cambio de sensors_file requiere reiniciar.
+func (r *reloader) reload(reason string) {
+	addLog(fmt.Sprintf("🔄 Recargando configuración (%s)", reason))
+
+	next, err := settings.Load(r.path, r.required, os.Environ())
+	if err == nil {
+		err = r.cmdline.Apply(next)
+	}
+	if err == nil {
+		if next.Scanner.Replay != "" {
+			next.Scanner.Backend = "replay"
+		}
+		err = next.Validate()
+	}
+	if err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			addLog(fmt.Sprintf("❌ Configuración inválida: %s", line))
+		}
+		addLog("⚠️  Se mantiene la configuración actual")
+		r.stamps = r.snapshot()
+		return
+	}
+
+	changes := r.apply(next)
+	changes += reloadSensors(cfg.SensorsFile)
+	r.current = next
+	r.stamps = r.snapshot()
+
+	if changes == 0 {
+		addLog("✅ Configuración recargada sin cambios")
+	}
+}
+
+// apply aplica los ajustes que cambian de r.current a next y registra
+// cada cambio; los que solo se aplican al arrancar se avisan como tales.
+// Devuelve el número de cambios.
+func (r *reloader) apply(next *settings.Settings) int {
+	changes := settings.Diff(r.current, next)
+	retry := false
+
+	for _, change := range changes {
+		live := true
+		switch {
+		case change.Key == "send_interval":
+			// Descartar un cambio anterior aún sin aplicar
+			select {
+			case <-sendIntervalChanges:
+			default:
+			}
+			sendIntervalChanges <- next.SendInterval
+		case change.Key == "online_timeout":
+			onlineTimeout.Store(int64(next.OnlineTimeout))
+		case strings.HasPrefix(change.Key, "retry."):
+			retry = true
+		default:
+			live = false
+		}
+
+		message := fmt.Sprintf("🔧 %s: %s → %s", change.Key, displayValue(change.Old), displayValue(change.New))
+		if !live {
+			message = fmt.Sprintf("⚠️  %s: %s → %s (requiere reiniciar)", change.Key, displayValue(change.Old), displayValue(change.New))
+		}
+		addLog(message)
+	}
+
+	if retry {
+		for _, runner := range sinkRunners {
+			runner.SetPolicy(next.RetryPolicy())
+		}
+	}
+	return len(changes)
+}
+
+// reloadSensors vuelve a leer la lista de sensores autorizados y la
+// cambia por la actual si es distinta. Devuelve el número de cambios.
+func reloadSensors(path string) int {
+	config, err := readSensors(path)
+	if err != nil {
+		addLog(fmt.Sprintf("❌ Sensores: %v; se mantiene la lista actual", err))
+		return 0
+	}
+	if enabledSensors(config) == 0 {
+		addLog(fmt.Sprintf("⚠️  %s no tiene sensores activos; se mantiene la lista actual", path))
+		return 0
+	}
+
+	next := newSensorSet(config)
+	changes := diffSensors(currentSensors.Load(), next)
+	if len(changes) == 0 {
+		return 0
+	}
+
+	currentSensors.Store(next)
+	for _, change := range changes {
+		addLog(change)
+	}
+	addLog(fmt.Sprintf("🔒 %d sensores autorizados", enabledSensors(config)))
+	updateSensorStatus()
+	return len(changes)
+}
+
+// diffSensors describe los sensores añadidos, retirados, renombrados,
+// activados y desactivados de old a next
+func diffSensors(old, next *sensorSet) []string {
+	var changes []string
+	for _, sensor := range next.config.Sensors {
+		name, known := old.names[sensor.MAC]
+		if known && name != sensor.Name {
+			changes = append(changes, fmt.Sprintf("✏️  Sensor renombrado: %s → %s (%s)", name, sensor.Name, sensor.MAC))
+		}
+		switch {
+		case !known:
+			changes = append(changes, fmt.Sprintf("➕ Sensor autorizado: %s (%s)", sensor.Name, sensor.MAC))
+		case !old.authorized[sensor.MAC] && next.authorized[sensor.MAC]:
+			changes = append(changes, fmt.Sprintf("▶️  Sensor activado: %s (%s)", sensor.Name, sensor.MAC))
+		case old.authorized[sensor.MAC] && !next.authorized[sensor.MAC]:
+			changes = append(changes, fmt.Sprintf("⏸️  Sensor desactivado: %s (%s)", sensor.Name, sensor.MAC))
+		}
+	}
+	for _, sensor := range old.config.Sensors {
+		if _, kept := next.names[sensor.MAC]; !kept {
+			changes = append(changes, fmt.Sprintf("➖ Sensor retirado: %s (%s)", sensor.Name, sensor.MAC))
+		}
+	}
+	return changes
+}
+
+// displayValue muestra los valores vacíos de forma visible en el log
+func displayValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	return value
+}
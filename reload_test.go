@@ -0,0 +1,39 @@
+package main
+
+import (
+	"path/filepath"
+	"sensorsgo/settings"
+	"testing"
+)
+
+// TestReloadSensorsFile tests that a new sensors_file waits for a restart,
+// so that the monitor, the sensors subcommand and the API keep using the
+// same list
+func TestReloadSensorsFile(t *testing.T) {
+	server := useAPI(t)
+	path := cfg.SensorsFile
+
+	other := writeFile(t, "other_sensors.json", `{"authorized_sensors": [{"mac": "77:88:99:AA:BB:CC", "name": "Otra"}]}`)
+	configPath := writeFile(t, "sensorgo.toml", "sensors_file = "+`"`+filepath.ToSlash(other)+`"`+"\n")
+
+	r := &reloader{path: configPath, required: true, cmdline: &settings.CommandLine{}}
+	r.current = cfg
+	r.stamps = r.snapshot()
+	r.reload("test")
+	if current := currentSensors.Load().config.Sensors; len(current) != 3 {
+		t.Errorf("monitor sensors after reload = %+v, want the list in use", current)
+	}
+
+	if status, body := apiRequest(t, server, "POST", "/sensors", "secreto", `{"mac":"00:00:00:00:00:01","name":"Nueva"}`); status != 201 {
+		t.Fatalf("POST /sensors = %d %s", status, body)
+	}
+	if config, err := readSensors(path); err != nil || len(config.Sensors) != 4 {
+		t.Errorf("sensors file in use = %+v, %v; want the new sensor added", config, err)
+	}
+	if config, err := readSensors(other); err != nil || len(config.Sensors) != 1 {
+		t.Errorf("new sensors_file = %+v, %v; want it untouched", config, err)
+	}
+	if current := currentSensors.Load().config.Sensors; len(current) != 4 || current[3].Name != "Nueva" {
+		t.Errorf("monitor sensors = %+v", current)
+	}
+}
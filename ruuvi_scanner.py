@@ -47,9 +47,10 @@ def parse_ruuvi_rawv2(data):
         press_raw = struct.unpack('>H', data[5:7])[0]
         pressure = (press_raw + 50000) / 100.0
 
-        # Battery (bytes 11-12): first 11 bits in mV
-        batt_raw = struct.unpack('>H', data[11:13])[0]
-        battery = (batt_raw >> 5) + 1600
+        # Power info (bytes 13-14): first 11 bits are battery mV above 1600,
+        # all ones if not available
+        power_raw = struct.unpack('>H', data[13:15])[0]
+        battery = None if power_raw >> 5 == 0x7FF else (power_raw >> 5) + 1600
 
         # MAC address (bytes 18-23)
         mac = ':'.join(f'{b:02X}' for b in data[18:24])
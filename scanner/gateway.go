@@ -0,0 +1,158 @@
+package scanner
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sensorsgo/sensor"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gatewayMaxBody limits the size of a Ruuvi Gateway request
+const gatewayMaxBody = 1 << 20
+
+// gatewayPayload is the JSON the Ruuvi Gateway POSTs to a custom HTTP server
+type gatewayPayload struct {
+	Data struct {
+		GatewayMAC string                `json:"gw_mac"`
+		Timestamp  unixTime              `json:"timestamp"`
+		Tags       map[string]gatewayTag `json:"tags"`
+	} `json:"data"`
+}
+
+// gatewayTag is the last advertisement the Gateway received from one tag
+type gatewayTag struct {
+	RSSI      int16    `json:"rssi"`
+	Timestamp unixTime `json:"timestamp"`
+	Data      string   `json:"data"` // Raw advertising data, hex
+}
+
+// unixTime is a Unix timestamp in seconds, which the Gateway sends as a
+// string or, in newer firmware, as a number
+type unixTime int64
+
+func (t *unixTime) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*t = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s", b)
+	}
+	*t = unixTime(v)
+	return nil
+}
+
+// GatewayScanner is an HTTP server receiving the advertisements relayed
+// by Ruuvi Gateways configured to send to a custom HTTP server
+type GatewayScanner struct {
+	lifecycle
+
+	Addr  string // Listen address, e.g. ":8081"
+	Token string // If set, requests must carry "Authorization: Bearer <Token>"
+}
+
+// NewGatewayScanner creates a Ruuvi Gateway receiver listening on addr
+func NewGatewayScanner(addr, token string) *GatewayScanner {
+	return &GatewayScanner{Addr: addr, Token: token}
+}
+
+// Start starts the HTTP server
+func (s *GatewayScanner) Start(ctx context.Context) (<-chan *sensor.Advertisement, error) {
+	return s.start(ctx, s.run)
+}
+
+// Stop shuts the HTTP server down
+func (s *GatewayScanner) Stop() error {
+	return s.stop()
+}
+
+func (s *GatewayScanner) run(ctx context.Context, out chan<- *sensor.Advertisement) error {
+	server := &http.Server{
+		Addr:              s.Addr,
+		Handler:           s.handler(ctx, out),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Ruuvi Gateway listener: %w", err)
+	}
+	return nil
+}
+
+// handler accepts the Gateway's POST requests on any path
+func (s *GatewayScanner) handler(ctx context.Context, out chan<- *sensor.Advertisement) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.Token != "" {
+			expected := "Bearer " + s.Token
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		var payload gatewayPayload
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, gatewayMaxBody)).Decode(&payload); err != nil {
+			http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		for _, adv := range parseGatewayTags(payload, time.Now()) {
+			if !send(ctx, out, adv) {
+				http.Error(w, "shutting down", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// parseGatewayTags converts the tags of a Gateway payload into
+// advertisements. Tags whose data is not valid advertising data are skipped.
+func parseGatewayTags(payload gatewayPayload, received time.Time) []*sensor.Advertisement {
+	ads := make([]*sensor.Advertisement, 0, len(payload.Data.Tags))
+
+	for mac, tag := range payload.Data.Tags {
+		data, err := hex.DecodeString(tag.Data)
+		if err != nil {
+			continue
+		}
+
+		at := received
+		if tag.Timestamp > 0 {
+			at = time.Unix(int64(tag.Timestamp), 0)
+		}
+
+		adv := &sensor.Advertisement{
+			Address:   strings.ToUpper(mac),
+			RSSI:      tag.RSSI,
+			Timestamp: at,
+		}
+		if err := sensor.ParseAdvertisingData(data, adv); err != nil {
+			continue
+		}
+		ads = append(ads, adv)
+	}
+
+	return ads
+}
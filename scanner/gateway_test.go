@@ -0,0 +1,77 @@
+package scanner
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sensorsgo/sensor"
+	"strings"
+	"testing"
+	"time"
+)
+
+const gatewayRequest = `{
+  "data": {
+    "coordinates": "",
+    "timestamp": "1770132645",
+    "gw_mac": "C8:25:2D:8E:9C:2C",
+    "tags": {
+      "cb:b8:33:4c:88:4f": {
+        "rssi": -65,
+        "timestamp": 1770132640,
+        "data": "0201061BFF99040512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F"
+      },
+      "11:22:33:44:55:66": {
+        "rssi": -90,
+        "timestamp": "1770132641",
+        "data": "not hex"
+      }
+    }
+  }
+}`
+
+// TestGatewayHandler tests decoding of a Ruuvi Gateway request
+func TestGatewayHandler(t *testing.T) {
+	s := NewGatewayScanner(":0", "secret")
+	out := make(chan *sensor.Advertisement, 4)
+	handler := s.handler(context.Background(), out)
+
+	req := httptest.NewRequest(http.MethodPost, "/ruuvi", strings.NewReader(gatewayRequest))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want 401", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/ruuvi", strings.NewReader(gatewayRequest))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	if len(out) != 1 {
+		t.Fatalf("got %d advertisements, want 1", len(out))
+	}
+	adv := <-out
+	if adv.Address != "CB:B8:33:4C:88:4F" || adv.RSSI != -65 || !adv.Timestamp.Equal(time.Unix(1770132640, 0)) {
+		t.Errorf("advertisement = %s, %d dBm, %v", adv.Address, adv.RSSI, adv.Timestamp)
+	}
+
+	data, err := sensor.DefaultRegistry().Decode(adv)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if data.Temperature == nil || *data.Temperature != 24.3 {
+		t.Errorf("Temperature = %v, want 24.3", data.Temperature)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/ruuvi", strings.NewReader(`{"data":`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid JSON: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,422 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sensorsgo/sensor"
+	"time"
+)
+
+// HCI packet types, events and subevents used to receive advertisements
+const (
+	hciCommandPacket = 0x01
+	hciEventPacket   = 0x04
+
+	hciEventCommandComplete = 0x0E
+	hciEventCommandStatus   = 0x0F
+	hciEventLEMeta          = 0x3E
+
+	hciSubeventAdvertisingReport         = 0x02
+	hciSubeventExtendedAdvertisingReport = 0x0D
+)
+
+var (
+	errTruncatedEvent = errors.New("truncated HCI event")
+	// errReadTimeout is returned by hciConn.Read when no packet arrived in time
+	errReadTimeout = errors.New("HCI read timeout")
+)
+
+// HCI commands used to configure scanning
+const (
+	opReset                       = 0x0C03
+	opSetEventMask                = 0x0C01
+	opLESetEventMask              = 0x2001
+	opLESetScanParameters         = 0x200B
+	opLESetScanEnable             = 0x200C
+	opLESetExtendedScanParameters = 0x2041
+	opLESetExtendedScanEnable     = 0x2042
+)
+
+// HCI status codes the scanner reacts to
+const (
+	statusUnknownCommand     = 0x01
+	statusCommandDisallowed  = 0x0C
+	statusUnsupportedFeature = 0x11
+	statusInvalidParameters  = 0x12
+)
+
+// Scanning PHYs for the extended scan commands
+const (
+	phyLE1M    = 0x01
+	phyLECoded = 0x04
+)
+
+// commandTimeout bounds the wait for a Command Complete or Command Status event
+const commandTimeout = 2 * time.Second
+
+// hciConn is an open HCI socket. Read returns one packet (starting with its
+// packet type) per call, or errReadTimeout if none arrived in time.
+type hciConn interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	Close() error
+}
+
+// hciStatusError is a non-zero status returned by the controller for a command
+type hciStatusError struct {
+	opcode uint16
+	status uint8
+}
+
+func (e *hciStatusError) Error() string {
+	return fmt.Sprintf("HCI command %#04x failed with status %#02x", e.opcode, e.status)
+}
+
+// isStatus reports whether err is an hciStatusError with the given status
+func isStatus(err error, status uint8) bool {
+	var statusErr *hciStatusError
+	return errors.As(err, &statusErr) && statusErr.status == status
+}
+
+// HCIScanner scans through a raw AF_BLUETOOTH HCI socket, configuring a
+// passive LE scan itself and parsing the advertising report events. It
+// needs Linux and CAP_NET_RAW (CAP_NET_ADMIN as well for UserChannel).
+type HCIScanner struct {
+	lifecycle
+
+	Device      int  // Adapter index: 0 for hci0
+	UserChannel bool // Take exclusive control of the adapter instead of sharing it with bluetoothd
+
+	open func(device int, userChannel bool) (hciConn, error)
+}
+
+// NewHCIScanner creates a scanner for the HCI adapter with the given index
+func NewHCIScanner(device int) *HCIScanner {
+	return &HCIScanner{
+		Device: device,
+		open:   openHCISocket,
+	}
+}
+
+// Start opens the HCI socket and begins a passive LE scan
+func (s *HCIScanner) Start(ctx context.Context) (<-chan *sensor.Advertisement, error) {
+	return s.start(ctx, s.run)
+}
+
+// Stop disables scanning and closes the socket
+func (s *HCIScanner) Stop() error {
+	return s.stop()
+}
+
+func (s *HCIScanner) run(ctx context.Context, out chan<- *sensor.Advertisement) error {
+	conn, err := s.open(s.Device, s.UserChannel)
+	if err != nil {
+		return fmt.Errorf("opening hci%d: %w", s.Device, err)
+	}
+	defer conn.Close()
+
+	if s.UserChannel {
+		// The kernel does not initialise a controller handed to a user
+		// channel, so enable the LE Meta event and advertising reports
+		if err := initController(conn); err != nil {
+			return err
+		}
+	}
+
+	owned, extended, err := enableScan(conn)
+	if err != nil {
+		return err
+	}
+	if owned {
+		defer disableScan(conn, extended)
+	} else {
+		s.logf("hci%d is already scanning; sharing its advertising reports\n", s.Device)
+	}
+
+	buf := make([]byte, 1024)
+	for ctx.Err() == nil {
+		n, err := conn.Read(buf)
+		if errors.Is(err, errReadTimeout) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading hci%d: %w", s.Device, err)
+		}
+		if n < 1 || buf[0] != hciEventPacket {
+			continue
+		}
+
+		ads, _ := parseHCIEvent(buf[1:n], time.Now())
+		for _, adv := range ads {
+			if !send(ctx, out, adv) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// initController resets the controller and unmasks the events the scanner needs
+func initController(conn hciConn) error {
+	if err := command(conn, opReset, nil); err != nil {
+		return err
+	}
+
+	// Default event mask plus bit 61, LE Meta
+	eventMask := make([]byte, 8)
+	binary.LittleEndian.PutUint64(eventMask, 0x20001FFFFFFFFFFF)
+	if err := command(conn, opSetEventMask, eventMask); err != nil {
+		return err
+	}
+
+	// Default LE event mask plus bit 12, Extended Advertising Report
+	leEventMask := make([]byte, 8)
+	binary.LittleEndian.PutUint64(leEventMask, 0x101F)
+	return command(conn, opLESetEventMask, leEventMask)
+}
+
+// enableScan configures and starts a passive scan with duplicates reported,
+// preferring extended scanning (needed for the longer Ruuvi Air E1 frames)
+// and falling back to the legacy commands. owned is false when another host,
+// typically bluetoothd, is already scanning: its reports reach this socket
+// too and its scan must not be disabled on exit.
+func enableScan(conn hciConn) (owned, extended bool, err error) {
+	extended = true
+	err = command(conn, opLESetExtendedScanParameters, extendedScanParameters(phyLE1M|phyLECoded))
+	if isStatus(err, statusUnsupportedFeature) || isStatus(err, statusInvalidParameters) {
+		// No Coded PHY support
+		err = command(conn, opLESetExtendedScanParameters, extendedScanParameters(phyLE1M))
+	}
+	if isStatus(err, statusUnknownCommand) || isStatus(err, statusCommandDisallowed) {
+		// Bluetooth < 5.0, or the controller is already driven with the
+		// legacy commands
+		extended = false
+		err = command(conn, opLESetScanParameters, []byte{
+			0x00,       // Passive
+			0x10, 0x00, // Interval, 10 ms
+			0x10, 0x00, // Window, 10 ms
+			0x00, // Public own address
+			0x00, // Accept all advertisements
+		})
+	}
+	if isStatus(err, statusCommandDisallowed) {
+		return false, extended, nil
+	}
+	if err != nil {
+		return false, extended, err
+	}
+
+	if extended {
+		// Enable, no duplicate filtering, no duration, no period
+		err = command(conn, opLESetExtendedScanEnable, []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00})
+	} else {
+		// Enable, no duplicate filtering
+		err = command(conn, opLESetScanEnable, []byte{0x01, 0x00})
+	}
+	if isStatus(err, statusCommandDisallowed) {
+		return false, extended, nil
+	}
+	if err != nil {
+		return false, extended, err
+	}
+	return true, extended, nil
+}
+
+// extendedScanParameters builds the LE Set Extended Scan Parameters
+// parameters for a passive scan on the given PHYs
+func extendedScanParameters(phys byte) []byte {
+	params := []byte{0x00, 0x00, phys} // Public own address, accept all
+	for bit := byte(0x01); bit <= phyLECoded; bit <<= 1 {
+		if phys&bit != 0 {
+			// Passive, interval 30 ms, window 15 ms
+			params = append(params, 0x00, 0x30, 0x00, 0x18, 0x00)
+		}
+	}
+	return params
+}
+
+// disableScan stops the scan started by enableScan
+func disableScan(conn hciConn, extended bool) error {
+	if extended {
+		return command(conn, opLESetExtendedScanEnable, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	}
+	return command(conn, opLESetScanEnable, []byte{0x00, 0x00})
+}
+
+// command sends an HCI command and waits for its Command Complete or
+// Command Status event, returning an hciStatusError for a non-zero status
+func command(conn hciConn, opcode uint16, params []byte) error {
+	packet := make([]byte, 4+len(params))
+	packet[0] = hciCommandPacket
+	binary.LittleEndian.PutUint16(packet[1:3], opcode)
+	packet[3] = byte(len(params))
+	copy(packet[4:], params)
+
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("sending HCI command %#04x: %w", opcode, err)
+	}
+
+	deadline := time.Now().Add(commandTimeout)
+	buf := make([]byte, 1024)
+	for time.Now().Before(deadline) {
+		n, err := conn.Read(buf)
+		if errors.Is(err, errReadTimeout) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("waiting for HCI command %#04x: %w", opcode, err)
+		}
+
+		// Event packet: type, event code, length, parameters
+		if n < 3 || buf[0] != hciEventPacket {
+			continue
+		}
+		event, params := buf[1], buf[3:n]
+
+		var status uint8
+		switch {
+		case event == hciEventCommandComplete && len(params) >= 4 &&
+			binary.LittleEndian.Uint16(params[1:3]) == opcode:
+			// Number of packets, opcode, return parameters (status first)
+			status = params[3]
+		case event == hciEventCommandStatus && len(params) >= 4 &&
+			binary.LittleEndian.Uint16(params[2:4]) == opcode:
+			// Status, number of packets, opcode
+			status = params[0]
+		default:
+			// Advertising reports and other events received meanwhile
+			continue
+		}
+
+		if status != 0 {
+			return &hciStatusError{opcode: opcode, status: status}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("HCI command %#04x: no response after %v", opcode, commandTimeout)
+}
+
+// parseHCIEvent parses an HCI event packet (event code, length and
+// parameters, without the H4 packet type byte) and returns the
+// advertisements it reports. Events other than advertising reports yield
+// no advertisements.
+func parseHCIEvent(event []byte, received time.Time) ([]*sensor.Advertisement, error) {
+	if len(event) < 2 {
+		return nil, errTruncatedEvent
+	}
+
+	code, length := event[0], int(event[1])
+	params := event[2:]
+	if len(params) < length {
+		return nil, errTruncatedEvent
+	}
+	params = params[:length]
+
+	if code != hciEventLEMeta || len(params) < 1 {
+		return nil, nil
+	}
+
+	switch params[0] {
+	case hciSubeventAdvertisingReport:
+		return parseAdvertisingReports(params[1:], received)
+	case hciSubeventExtendedAdvertisingReport:
+		return parseExtendedAdvertisingReports(params[1:], received)
+	default:
+		return nil, nil
+	}
+}
+
+// parseAdvertisingReports parses the reports of an LE Advertising Report
+// event: event type, address type, address, data length, data and RSSI
+func parseAdvertisingReports(params []byte, received time.Time) ([]*sensor.Advertisement, error) {
+	if len(params) < 1 {
+		return nil, errTruncatedEvent
+	}
+
+	count := int(params[0])
+	params = params[1:]
+
+	ads := make([]*sensor.Advertisement, 0, count)
+	for i := 0; i < count; i++ {
+		if len(params) < 9 {
+			return ads, errTruncatedEvent
+		}
+
+		address := params[2:8]
+		dataLen := int(params[8])
+		if len(params) < 9+dataLen+1 {
+			return ads, errTruncatedEvent
+		}
+
+		adv := &sensor.Advertisement{
+			Address:   formatAddress(address),
+			RSSI:      int16(int8(params[9+dataLen])),
+			Timestamp: received,
+		}
+		if err := sensor.ParseAdvertisingData(params[9:9+dataLen], adv); err != nil {
+			return ads, fmt.Errorf("report %d: %w", i, err)
+		}
+		ads = append(ads, adv)
+
+		params = params[9+dataLen+1:]
+	}
+
+	return ads, nil
+}
+
+// parseExtendedAdvertisingReports parses the reports of an LE Extended
+// Advertising Report event. Reports whose data is incomplete or truncated
+// are skipped: a partial advertisement cannot be decoded.
+func parseExtendedAdvertisingReports(params []byte, received time.Time) ([]*sensor.Advertisement, error) {
+	if len(params) < 1 {
+		return nil, errTruncatedEvent
+	}
+
+	count := int(params[0])
+	params = params[1:]
+
+	ads := make([]*sensor.Advertisement, 0, count)
+	for i := 0; i < count; i++ {
+		// event type (2), address type (1), address (6), primary PHY (1),
+		// secondary PHY (1), SID (1), TX power (1), RSSI (1), periodic
+		// interval (2), direct address type (1), direct address (6), data
+		// length (1)
+		if len(params) < 24 {
+			return ads, errTruncatedEvent
+		}
+
+		eventType := binary.LittleEndian.Uint16(params[0:2])
+		address := params[3:9]
+		rssi := int8(params[13])
+		dataLen := int(params[23])
+		if len(params) < 24+dataLen {
+			return ads, errTruncatedEvent
+		}
+		data := params[24 : 24+dataLen]
+		params = params[24+dataLen:]
+
+		if dataStatus := (eventType >> 5) & 0x03; dataStatus != 0 {
+			continue
+		}
+
+		adv := &sensor.Advertisement{
+			Address:   formatAddress(address),
+			RSSI:      int16(rssi),
+			Timestamp: received,
+		}
+		if err := sensor.ParseAdvertisingData(data, adv); err != nil {
+			return ads, fmt.Errorf("report %d: %w", i, err)
+		}
+		ads = append(ads, adv)
+	}
+
+	return ads, nil
+}
+
+// formatAddress formats a little-endian device address as AA:BB:CC:DD:EE:FF
+func formatAddress(b []byte) string {
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", b[5], b[4], b[3], b[2], b[1], b[0])
+}
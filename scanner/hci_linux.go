@@ -0,0 +1,98 @@
+//go:build linux
+
+package scanner
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// Linux Bluetooth socket constants (linux/bluetooth/hci.h)
+const (
+	afBluetooth    = 31
+	btprotoHCI     = 1
+	solHCI         = 0
+	hciFilter      = 2
+	hciChannelRaw  = 0
+	hciChannelUser = 1
+	hciDevDown     = 0x400448CA // HCIDEVDOWN, _IOW('H', 202, int)
+)
+
+// sockaddrHCI is struct sockaddr_hci
+type sockaddrHCI struct {
+	family  uint16
+	dev     uint16
+	channel uint16
+}
+
+// hciSocket is an HCI socket bound to one adapter
+type hciSocket struct {
+	fd int
+}
+
+// openHCISocket opens and binds an HCI socket. The raw channel shares the
+// adapter with bluetoothd and only receives the events let through the
+// socket filter; the user channel takes the adapter over exclusively and
+// requires it to be down, so it is brought down first.
+func openHCISocket(device int, userChannel bool) (hciConn, error) {
+	fd, err := syscall.Socket(afBluetooth, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, btprotoHCI)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := uint16(hciChannelRaw)
+	if userChannel {
+		channel = hciChannelUser
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), hciDevDown, uintptr(device)); errno != 0 {
+			syscall.Close(fd)
+			return nil, errno
+		}
+	}
+
+	addr := sockaddrHCI{family: afBluetooth, dev: uint16(device), channel: channel}
+	if _, _, errno := syscall.Syscall(syscall.SYS_BIND, uintptr(fd), uintptr(unsafe.Pointer(&addr)), unsafe.Sizeof(addr)); errno != 0 {
+		syscall.Close(fd)
+		return nil, errno
+	}
+
+	if !userChannel {
+		// struct hci_filter: packet type mask, event mask, opcode. Let
+		// through event packets for Command Complete, Command Status and
+		// LE Meta.
+		filter := make([]byte, 14)
+		filter[0] = 1 << hciEventPacket
+		filter[4+hciEventCommandComplete/8] |= 1 << (hciEventCommandComplete % 8)
+		filter[4+hciEventCommandStatus/8] |= 1 << (hciEventCommandStatus % 8)
+		filter[4+hciEventLEMeta/8] |= 1 << (hciEventLEMeta % 8)
+		if err := syscall.SetsockoptString(fd, solHCI, hciFilter, string(filter)); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+
+	// Wake up Read periodically so that cancellation is noticed
+	timeout := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return &hciSocket{fd: fd}, nil
+}
+
+func (s *hciSocket) Read(p []byte) (int, error) {
+	n, err := syscall.Read(s.fd, p)
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+		return 0, errReadTimeout
+	}
+	return n, err
+}
+
+func (s *hciSocket) Write(p []byte) (int, error) {
+	return syscall.Write(s.fd, p)
+}
+
+func (s *hciSocket) Close() error {
+	return syscall.Close(s.fd)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package scanner
+
+import "errors"
+
+// openHCISocket is only available on Linux
+func openHCISocket(device int, userChannel bool) (hciConn, error) {
+	return nil, errors.New("HCI sockets are only supported on Linux")
+}
@@ -0,0 +1,150 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestParseHCIEvent tests an LE Advertising Report carrying RuuviTag RAWv2 data
+func TestParseHCIEvent(t *testing.T) {
+	packet, err := hex.DecodeString("043E2B02010001" + "4F884C33B8CB" + "1F" + "020106" +
+		"1BFF9904" + "0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F" + "C5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := time.Date(2026, 2, 3, 15, 30, 45, 0, time.UTC)
+	ads, err := parseHCIEvent(packet[1:], received)
+	if err != nil {
+		t.Fatalf("parseHCIEvent: %v", err)
+	}
+	if len(ads) != 1 {
+		t.Fatalf("got %d advertisements, want 1", len(ads))
+	}
+
+	adv := ads[0]
+	if adv.Address != "CB:B8:33:4C:88:4F" {
+		t.Errorf("Address = %q, want CB:B8:33:4C:88:4F", adv.Address)
+	}
+	if adv.RSSI != -59 {
+		t.Errorf("RSSI = %d, want -59", adv.RSSI)
+	}
+	if !adv.Timestamp.Equal(received) {
+		t.Errorf("Timestamp = %v, want %v", adv.Timestamp, received)
+	}
+	if got := hex.EncodeToString(adv.ManufacturerData[0x0499]); got != "0512fc5394c37c0004fffc040cac364200cdcbb8334c884f" {
+		t.Errorf("ManufacturerData = %s", got)
+	}
+
+	if _, err := parseHCIEvent(packet[1:10], received); err == nil {
+		t.Error("expected error for truncated event")
+	}
+}
+
+// TestParseExtendedAdvertisingReport tests an LE Extended Advertising Report
+// and that incomplete reports are skipped
+func TestParseExtendedAdvertisingReport(t *testing.T) {
+	report := "1300" + "00" + "4F884C33B8CB" + "01" + "00" + "FF" + "7F" + "C5" + "0000" + "00" + "000000000000"
+	data := "1BFF9904" + "0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F"
+	params := "0D" + "02" + report + "1C" + data +
+		"3300" + "00" + "010203040506" + "01" + "00" + "FF" + "7F" + "C5" + "0000" + "00" + "000000000000" + "00"
+
+	packet, err := hex.DecodeString("3E" + fmt.Sprintf("%02X", len(params)/2) + params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ads, err := parseHCIEvent(packet, time.Now())
+	if err != nil {
+		t.Fatalf("parseHCIEvent: %v", err)
+	}
+	if len(ads) != 1 {
+		t.Fatalf("got %d advertisements, want 1", len(ads))
+	}
+	if ads[0].Address != "CB:B8:33:4C:88:4F" || ads[0].RSSI != -59 {
+		t.Errorf("advertisement = %s, %d dBm", ads[0].Address, ads[0].RSSI)
+	}
+	if len(ads[0].ManufacturerData[0x0499]) != 24 {
+		t.Errorf("ManufacturerData = %x", ads[0].ManufacturerData[0x0499])
+	}
+}
+
+// fakeHCI answers every command with Command Complete and the configured
+// status, then delivers the queued packets
+type fakeHCI struct {
+	status  map[uint16]uint8
+	pending [][]byte
+	sent    []uint16
+}
+
+func (f *fakeHCI) Write(p []byte) (int, error) {
+	opcode := binary.LittleEndian.Uint16(p[1:3])
+	f.sent = append(f.sent, opcode)
+	complete := []byte{hciEventPacket, hciEventCommandComplete, 4, 1, 0, 0, f.status[opcode]}
+	binary.LittleEndian.PutUint16(complete[4:6], opcode)
+	f.pending = append(f.pending, complete)
+	return len(p), nil
+}
+
+func (f *fakeHCI) Read(p []byte) (int, error) {
+	if len(f.pending) == 0 {
+		return 0, errReadTimeout
+	}
+	n := copy(p, f.pending[0])
+	f.pending = f.pending[1:]
+	return n, nil
+}
+
+func (f *fakeHCI) Close() error { return nil }
+
+// TestEnableScan tests the fallbacks from extended to legacy scanning
+func TestEnableScan(t *testing.T) {
+	conn := &fakeHCI{}
+	owned, extended, err := enableScan(conn)
+	if err != nil || !owned || !extended {
+		t.Fatalf("enableScan = %v, %v, %v", owned, extended, err)
+	}
+	if want := []uint16{opLESetExtendedScanParameters, opLESetExtendedScanEnable}; !equalOpcodes(conn.sent, want) {
+		t.Errorf("sent %04x, want %04x", conn.sent, want)
+	}
+
+	// Bluetooth 4.x controller
+	conn = &fakeHCI{status: map[uint16]uint8{opLESetExtendedScanParameters: statusUnknownCommand}}
+	owned, extended, err = enableScan(conn)
+	if err != nil || !owned || extended {
+		t.Fatalf("legacy enableScan = %v, %v, %v", owned, extended, err)
+	}
+	if want := []uint16{opLESetExtendedScanParameters, opLESetScanParameters, opLESetScanEnable}; !equalOpcodes(conn.sent, want) {
+		t.Errorf("sent %04x, want %04x", conn.sent, want)
+	}
+
+	// bluetoothd is already scanning
+	conn = &fakeHCI{status: map[uint16]uint8{
+		opLESetExtendedScanParameters: statusCommandDisallowed,
+		opLESetScanParameters:         statusCommandDisallowed,
+	}}
+	owned, _, err = enableScan(conn)
+	if err != nil || owned {
+		t.Fatalf("shared enableScan = %v, %v", owned, err)
+	}
+
+	conn = &fakeHCI{status: map[uint16]uint8{opLESetExtendedScanEnable: 0x03}}
+	if _, _, err := enableScan(conn); !isStatus(err, 0x03) {
+		t.Errorf("expected status 0x03 error, got %v", err)
+	}
+}
+
+func equalOpcodes(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
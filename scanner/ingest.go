@@ -0,0 +1,257 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sensorsgo/sensor"
+	"strings"
+	"time"
+)
+
+// DefaultIngestFile is where `ruuvi_scanner.py --daemon` writes its output
+const DefaultIngestFile = "/tmp/ruuvi_data.json"
+
+// ingestPollInterval is how often the watched file's modification time is checked
+const ingestPollInterval = time.Second
+
+var macPattern = regexp.MustCompile(`^[0-9A-Fa-f]{2}(:[0-9A-Fa-f]{2}){5}$`)
+
+// pythonDevice is one tag as written by ruuvi_scanner.py
+type pythonDevice struct {
+	MAC         string   `json:"mac"`
+	Name        string   `json:"name"`
+	RSSI        int16    `json:"rssi"`
+	Timestamp   string   `json:"timestamp"`
+	Temperature *float64 `json:"temperature"`
+	Humidity    *float64 `json:"humidity"`
+	Pressure    *float64 `json:"pressure"`
+	Battery     *uint16  `json:"battery"`
+	MACFromData string   `json:"mac_from_data"`
+}
+
+// pythonOutput is the file written in daemon mode
+type pythonOutput struct {
+	Timestamp string         `json:"timestamp"`
+	Devices   []pythonDevice `json:"devices"`
+}
+
+// IngestScanner reads the tags decoded by ruuvi_scanner.py, either by
+// watching the JSON file it writes in daemon mode or from JSON lines on
+// stdin, and delivers them as advertisements carrying a Reading
+type IngestScanner struct {
+	lifecycle
+
+	// Path is the file to watch, or "-" for JSON lines on stdin
+	Path string
+
+	stdin io.Reader
+	seen  map[string]string // MAC -> timestamp of the last delivered reading
+}
+
+// NewIngestScanner creates a scanner reading ruuvi_scanner.py output from
+// path, or from stdin if path is "-"
+func NewIngestScanner(path string) *IngestScanner {
+	return &IngestScanner{Path: path, stdin: os.Stdin, seen: make(map[string]string)}
+}
+
+// Start begins reading. Reading stdin ends with ErrExhausted at end of input.
+func (s *IngestScanner) Start(ctx context.Context) (<-chan *sensor.Advertisement, error) {
+	return s.start(ctx, s.run)
+}
+
+// Stop stops reading
+func (s *IngestScanner) Stop() error {
+	return s.stop()
+}
+
+func (s *IngestScanner) run(ctx context.Context, out chan<- *sensor.Advertisement) error {
+	if s.Path == "-" {
+		return s.readLines(ctx, out)
+	}
+	return s.watchFile(ctx, out)
+}
+
+// watchFile re-reads the file whenever its modification time changes
+func (s *IngestScanner) watchFile(ctx context.Context, out chan<- *sensor.Advertisement) error {
+	var lastMod time.Time
+	ticker := time.NewTicker(ingestPollInterval)
+	defer ticker.Stop()
+
+	for {
+		info, err := os.Stat(s.Path)
+		if err == nil && !info.ModTime().Equal(lastMod) {
+			data, readErr := os.ReadFile(s.Path)
+			devices, parseErr := parseIngest(data)
+			// The script rewrites the file in place, so a read can catch
+			// it half written: retry on the next poll
+			if readErr == nil && parseErr == nil {
+				lastMod = info.ModTime()
+				if !s.deliver(ctx, out, devices) {
+					return nil
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// readLines reads one JSON document per line: a device, a list of devices
+// or the daemon's {"timestamp", "devices"} object
+func (s *IngestScanner) readLines(ctx context.Context, out chan<- *sensor.Advertisement) error {
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+
+	// Reading stdin cannot be interrupted, so it runs apart from ctx
+	go func() {
+		scanner := bufio.NewScanner(s.stdin)
+		for scanner.Scan() {
+			select {
+			case lines <- append([]byte(nil), scanner.Bytes()...):
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr <- scanner.Err()
+		close(lines)
+	}()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if err := <-readErr; err != nil {
+					return err
+				}
+				return ErrExhausted
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			devices, err := parseIngest(line)
+			if err != nil {
+				s.logf("Ignoring invalid JSON line: %v\n", err)
+				continue
+			}
+			if !s.deliver(ctx, out, devices) {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// deliver sends the devices not already delivered with the same timestamp
+func (s *IngestScanner) deliver(ctx context.Context, out chan<- *sensor.Advertisement, devices []pythonDevice) bool {
+	for _, d := range devices {
+		adv := d.advertisement()
+		if adv == nil {
+			continue
+		}
+		if d.Timestamp != "" && s.seen[adv.Address] == d.Timestamp {
+			continue
+		}
+		s.seen[adv.Address] = d.Timestamp
+
+		if !send(ctx, out, adv) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseIngest accepts the daemon's output object, a list of devices (the
+// script's one-shot output) or a single device
+func parseIngest(data []byte) ([]pythonDevice, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty document")
+	}
+
+	if data[0] == '[' {
+		var devices []pythonDevice
+		err := json.Unmarshal(data, &devices)
+		return devices, err
+	}
+
+	var output pythonOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, err
+	}
+	if output.Devices != nil {
+		return output.Devices, nil
+	}
+
+	var device pythonDevice
+	if err := json.Unmarshal(data, &device); err != nil {
+		return nil, err
+	}
+	if device.MAC == "" && device.MACFromData == "" {
+		return nil, errors.New("no devices in document")
+	}
+	return []pythonDevice{device}, nil
+}
+
+// advertisement maps the device to an advertisement carrying its reading,
+// or returns nil if it has no usable address
+func (d pythonDevice) advertisement() *sensor.Advertisement {
+	// bleak reports a UUID instead of the MAC on macOS
+	address := d.MAC
+	if !macPattern.MatchString(address) {
+		address = d.MACFromData
+	}
+	if !macPattern.MatchString(address) {
+		return nil
+	}
+	address = normalizeMAC(address)
+
+	received := parsePythonTime(d.Timestamp)
+	if received.IsZero() {
+		received = time.Now()
+	}
+
+	return &sensor.Advertisement{
+		Address:   address,
+		LocalName: d.Name,
+		RSSI:      d.RSSI,
+		Timestamp: received,
+		Reading: &sensor.RuuviData{
+			MAC:         normalizeMAC(d.MACFromData),
+			Model:       "ruuvi",
+			DataFormat:  sensor.FormatRAWv2,
+			RSSI:        d.RSSI,
+			Temperature: d.Temperature,
+			Humidity:    d.Humidity,
+			Pressure:    d.Pressure,
+			Battery:     d.Battery,
+			Timestamp:   received,
+		},
+	}
+}
+
+// parsePythonTime parses datetime.isoformat() output, which is local time
+// without a zone unless the datetime was zone-aware
+func parsePythonTime(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05.999999", s, time.Local); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+func normalizeMAC(mac string) string {
+	return strings.ToUpper(mac)
+}
@@ -0,0 +1,138 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sensorsgo/sensor"
+	"strings"
+	"testing"
+	"time"
+)
+
+// daemonOutput is a file as written by `ruuvi_scanner.py --daemon`
+const daemonOutput = `{
+  "timestamp": "2026-02-03T15:30:55.000001",
+  "devices": [
+    {
+      "mac": "cb:b8:33:4c:88:4f",
+      "name": "Ruuvi 884F",
+      "rssi": -67,
+      "timestamp": "2026-02-03T15:30:45.123456",
+      "temperature": 24.3,
+      "humidity": 53.49,
+      "pressure": 1000.44,
+      "battery": 2977,
+      "mac_from_data": "CB:B8:33:4C:88:4F"
+    },
+    {
+      "mac": "5A3C8E7B-0000-4000-8000-000000000000",
+      "name": "Ruuvi 1A2B",
+      "rssi": -80,
+      "timestamp": "2026-02-03T15:30:46.000000",
+      "temperature": 20.0,
+      "humidity": 40.0,
+      "pressure": 1010.0,
+      "battery": 3000,
+      "mac_from_data": "F1:E2:D3:C4:B5:1A"
+    }
+  ]
+}`
+
+// TestParseIngest tests the daemon output mapping
+func TestParseIngest(t *testing.T) {
+	devices, err := parseIngest([]byte(daemonOutput))
+	if err != nil {
+		t.Fatalf("parseIngest: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2", len(devices))
+	}
+
+	adv := devices[0].advertisement()
+	if adv.Address != "CB:B8:33:4C:88:4F" || adv.RSSI != -67 {
+		t.Errorf("advertisement = %s, %d dBm", adv.Address, adv.RSSI)
+	}
+	want := time.Date(2026, 2, 3, 15, 30, 45, 123456000, time.Local)
+	if !adv.Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v", adv.Timestamp, want)
+	}
+
+	data, err := sensor.DefaultRegistry().Decode(adv)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if data.Model != "ruuvi" || *data.Temperature != 24.3 || *data.Battery != 2977 {
+		t.Errorf("reading = %s, %v °C, %d mV", data.Model, *data.Temperature, *data.Battery)
+	}
+
+	// A macOS UUID falls back to the MAC decoded from the data
+	if adv := devices[1].advertisement(); adv.Address != "F1:E2:D3:C4:B5:1A" {
+		t.Errorf("Address = %q, want F1:E2:D3:C4:B5:1A", adv.Address)
+	}
+
+	if _, err := parseIngest([]byte(`{"timestamp": "2026-02-03T15:30`)); err == nil {
+		t.Error("expected error for a half written file")
+	}
+}
+
+// TestIngestFile tests that an unchanged reading is not delivered twice
+func TestIngestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ruuvi_data.json")
+	if err := os.WriteFile(path, []byte(daemonOutput), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := NewIngestScanner(path)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ads, err := sc.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		<-ads
+	}
+
+	// Rewritten with the same readings: nothing new
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case adv := <-ads:
+		t.Errorf("unexpected advertisement %+v", adv)
+	case <-time.After(2 * ingestPollInterval):
+	}
+	sc.Stop()
+}
+
+// TestIngestStdin tests JSON lines, one device per line
+func TestIngestStdin(t *testing.T) {
+	sc := NewIngestScanner("-")
+	sc.stdin = strings.NewReader(`{"mac": "CB:B8:33:4C:88:4F", "rssi": -70, "timestamp": "2026-02-03T15:30:45", "temperature": 21.5}
+not json
+
+{"mac": "CB:B8:33:4C:88:4F", "rssi": -71, "timestamp": "2026-02-03T15:30:50", "temperature": 21.6}
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ads, err := sc.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	var got []*sensor.Advertisement
+	for adv := range ads {
+		got = append(got, adv)
+	}
+	if len(got) != 2 || *got[1].Reading.Temperature != 21.6 {
+		t.Fatalf("got %d advertisements", len(got))
+	}
+	if !errors.Is(sc.Err(), ErrExhausted) {
+		t.Errorf("Err = %v, want ErrExhausted", sc.Err())
+	}
+}
@@ -0,0 +1,152 @@
+package scanner
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sensorsgo/sensor"
+	"strconv"
+	"time"
+)
+
+// recordedAdvertisement is one line of a JSONL recording. Data is kept
+// raw, keyed by hex company ID or service UUID, so that a replay goes
+// through the decoders again.
+type recordedAdvertisement struct {
+	Time             time.Time         `json:"time"`
+	Address          string            `json:"address"`
+	Name             string            `json:"name,omitempty"`
+	RSSI             int16             `json:"rssi"`
+	ManufacturerData map[string]string `json:"manufacturer_data,omitempty"`
+	ServiceData      map[string]string `json:"service_data,omitempty"`
+	Reading          *sensor.RuuviData `json:"reading,omitempty"` // Sources that deliver decoded data
+}
+
+func newRecordedAdvertisement(adv *sensor.Advertisement) recordedAdvertisement {
+	rec := recordedAdvertisement{
+		Time:    adv.Timestamp,
+		Address: adv.Address,
+		Name:    adv.LocalName,
+		RSSI:    adv.RSSI,
+		Reading: adv.Reading,
+	}
+	if len(adv.ManufacturerData) > 0 {
+		rec.ManufacturerData = make(map[string]string)
+		for id, data := range adv.ManufacturerData {
+			rec.ManufacturerData[fmt.Sprintf("%04x", id)] = hex.EncodeToString(data)
+		}
+	}
+	if len(adv.ServiceData) > 0 {
+		rec.ServiceData = make(map[string]string)
+		for uuid, data := range adv.ServiceData {
+			rec.ServiceData[fmt.Sprintf("%04x", uuid)] = hex.EncodeToString(data)
+		}
+	}
+	return rec
+}
+
+func (rec recordedAdvertisement) advertisement() (*sensor.Advertisement, error) {
+	adv := &sensor.Advertisement{
+		Address:          rec.Address,
+		LocalName:        rec.Name,
+		RSSI:             rec.RSSI,
+		ManufacturerData: make(map[uint16][]byte),
+		ServiceData:      make(map[uint16][]byte),
+		Timestamp:        rec.Time,
+		Reading:          rec.Reading,
+	}
+	if err := decodeHexMap(rec.ManufacturerData, adv.ManufacturerData); err != nil {
+		return nil, fmt.Errorf("manufacturer_data: %w", err)
+	}
+	if err := decodeHexMap(rec.ServiceData, adv.ServiceData); err != nil {
+		return nil, fmt.Errorf("service_data: %w", err)
+	}
+	return adv, nil
+}
+
+func decodeHexMap(in map[string]string, out map[uint16][]byte) error {
+	for key, value := range in {
+		id, err := strconv.ParseUint(key, 16, 16)
+		if err != nil {
+			return fmt.Errorf("invalid ID %q", key)
+		}
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("invalid data for %s: %w", key, err)
+		}
+		out[uint16(id)] = data
+	}
+	return nil
+}
+
+// Recorder wraps a Scanner and appends every advertisement it delivers to
+// a JSONL file that the replay backend can read back
+type Recorder struct {
+	lifecycle
+
+	inner Scanner
+	file  *os.File
+	enc   *json.Encoder
+}
+
+// Record wraps sc so that its advertisements are also appended to path
+func Record(sc Scanner, path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{inner: sc, file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Prepare gets the wrapped scanner ready, see Preparer
+func (r *Recorder) Prepare(ctx context.Context) error {
+	return Prepare(ctx, r.inner)
+}
+
+// setLog sends the messages of the recorder and of the wrapped scanner to w
+func (r *Recorder) setLog(w io.Writer) {
+	r.lifecycle.setLog(w)
+	SetLog(r.inner, w)
+}
+
+// Start starts the wrapped scanner and records what it delivers
+func (r *Recorder) Start(ctx context.Context) (<-chan *sensor.Advertisement, error) {
+	return r.start(ctx, r.run)
+}
+
+// Stop stops the wrapped scanner
+func (r *Recorder) Stop() error {
+	return r.stop()
+}
+
+// Close stops recording and closes the file
+func (r *Recorder) Close() error {
+	r.stop()
+	return r.file.Close()
+}
+
+func (r *Recorder) run(ctx context.Context, out chan<- *sensor.Advertisement) error {
+	in, err := r.inner.Start(ctx)
+	if err != nil {
+		return err
+	}
+
+	var writeErr error
+	for adv := range in {
+		if writeErr == nil {
+			writeErr = r.enc.Encode(newRecordedAdvertisement(adv))
+			if writeErr != nil {
+				r.logf("Error recording advertisements: %v\n", writeErr)
+			}
+		}
+		if !send(ctx, out, adv) {
+			r.inner.Stop()
+			return nil
+		}
+	}
+
+	return r.inner.Err()
+}
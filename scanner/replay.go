@@ -0,0 +1,308 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sensorsgo/sensor"
+	"sync/atomic"
+	"time"
+)
+
+// ErrExhausted is reported by Err, and returned by Start, once a finite
+// source such as a replayed capture has delivered everything it had
+var ErrExhausted = errors.New("scanner source exhausted")
+
+// btsnoop and pcap constants
+const (
+	btsnoopEpochDelta = 0x00DCDDB30F2F8000 // Microseconds from year 0 to 1970
+
+	btsnoopH1      = 1001 // Unencapsulated HCI
+	btsnoopH4      = 1002 // HCI UART, packet type prefix
+	btsnoopMonitor = 2001 // Linux monitor (btmon -w)
+
+	monitorEventPacket = 3 // Linux monitor opcode of HCI event packets
+
+	pcapMagic   = 0xA1B2C3D4
+	pcapMagicNs = 0xA1B23C4D
+
+	linktypeH4         = 187 // DLT_BLUETOOTH_HCI_H4
+	linktypeH4WithPhdr = 201 // DLT_BLUETOOTH_HCI_H4_WITH_PHDR
+	linktypeMonitor    = 254 // DLT_BLUETOOTH_LINUX_MONITOR
+)
+
+// ReplayScanner plays back a btsnoop capture (btmon -w), a pcap capture or
+// a JSONL recording made with Record. Advertisements are delivered with
+// the original spacing divided by Speed and are stamped with the time they
+// are delivered, as if they had just been received.
+type ReplayScanner struct {
+	lifecycle
+
+	Path  string
+	Speed float64 // 1 is real time, 10 ten times faster, 0 as fast as possible
+
+	exhausted atomic.Bool
+}
+
+// NewReplayScanner creates a scanner that replays the file at path
+func NewReplayScanner(path string, speed float64) *ReplayScanner {
+	return &ReplayScanner{Path: path, Speed: speed}
+}
+
+// Start begins the replay. Once the whole file has been replayed Start
+// returns ErrExhausted.
+func (s *ReplayScanner) Start(ctx context.Context) (<-chan *sensor.Advertisement, error) {
+	if s.exhausted.Load() {
+		return nil, ErrExhausted
+	}
+	return s.start(ctx, s.run)
+}
+
+// Stop stops the replay
+func (s *ReplayScanner) Stop() error {
+	return s.stop()
+}
+
+func (s *ReplayScanner) run(ctx context.Context, out chan<- *sensor.Advertisement) error {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	next, err := openReplay(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.Path, err)
+	}
+
+	var first time.Time
+	start := time.Now()
+	for {
+		at, ads, err := next()
+		if err == io.EOF {
+			s.exhausted.Store(true)
+			return ErrExhausted
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.Path, err)
+		}
+
+		if first.IsZero() {
+			first = at
+		}
+		if s.Speed > 0 {
+			due := start.Add(time.Duration(float64(at.Sub(first)) / s.Speed))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		now := time.Now()
+		for _, adv := range ads {
+			adv.Timestamp = now
+			if !send(ctx, out, adv) {
+				return nil
+			}
+		}
+	}
+}
+
+// replayFunc returns the next timestamped batch of advertisements, or io.EOF
+type replayFunc func() (time.Time, []*sensor.Advertisement, error)
+
+// openReplay detects the file format from its first bytes
+func openReplay(r *bufio.Reader) (replayFunc, error) {
+	magic, _ := r.Peek(8)
+
+	switch {
+	case bytes.Equal(magic, []byte("btsnoop\x00")):
+		return openBtsnoop(r)
+	case len(magic) >= 4 && isPcapMagic(magic[:4]):
+		return openPcap(r)
+	default:
+		return openJSONL(r), nil
+	}
+}
+
+// openBtsnoop reads a btsnoop file: a 16-byte header (magic, version,
+// datalink) followed by records with a 24-byte big-endian header
+func openBtsnoop(r io.Reader) (replayFunc, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	datalink := binary.BigEndian.Uint32(header[12:16])
+	switch datalink {
+	case btsnoopH1, btsnoopH4, btsnoopMonitor:
+	default:
+		return nil, fmt.Errorf("unsupported btsnoop datalink %d", datalink)
+	}
+
+	return func() (time.Time, []*sensor.Advertisement, error) {
+		for {
+			record := make([]byte, 24)
+			if _, err := io.ReadFull(r, record); err != nil {
+				return time.Time{}, nil, eofOrTruncated(err)
+			}
+			length := binary.BigEndian.Uint32(record[4:8])
+			flags := binary.BigEndian.Uint32(record[8:12])
+			micros := int64(binary.BigEndian.Uint64(record[16:24])) - btsnoopEpochDelta
+			at := time.UnixMicro(micros)
+
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return time.Time{}, nil, eofOrTruncated(err)
+			}
+
+			var event []byte
+			switch datalink {
+			case btsnoopH1:
+				// Flags: bit 0 received, bit 1 command/event
+				if flags&0x03 == 0x03 {
+					event = data
+				}
+			case btsnoopH4:
+				if len(data) > 0 && data[0] == hciEventPacket {
+					event = data[1:]
+				}
+			case btsnoopMonitor:
+				// Flags: adapter index << 16 | monitor opcode
+				if flags&0xFFFF == monitorEventPacket {
+					event = data
+				}
+			}
+			if event == nil {
+				continue
+			}
+
+			if ads, err := parseHCIEvent(event, at); err == nil && len(ads) > 0 {
+				return at, ads, nil
+			}
+		}
+	}, nil
+}
+
+func isPcapMagic(b []byte) bool {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if m := order.Uint32(b); m == pcapMagic || m == pcapMagicNs {
+			return true
+		}
+	}
+	return false
+}
+
+// openPcap reads a pcap file with one of the Bluetooth HCI link types
+func openPcap(r io.Reader) (replayFunc, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	magic := order.Uint32(header[0:4])
+	if magic != pcapMagic && magic != pcapMagicNs {
+		order = binary.BigEndian
+		magic = order.Uint32(header[0:4])
+	}
+	fraction := time.Microsecond
+	if magic == pcapMagicNs {
+		fraction = time.Nanosecond
+	}
+
+	linktype := order.Uint32(header[20:24])
+	switch linktype {
+	case linktypeH4, linktypeH4WithPhdr, linktypeMonitor:
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", linktype)
+	}
+
+	return func() (time.Time, []*sensor.Advertisement, error) {
+		for {
+			record := make([]byte, 16)
+			if _, err := io.ReadFull(r, record); err != nil {
+				return time.Time{}, nil, eofOrTruncated(err)
+			}
+			at := time.Unix(int64(order.Uint32(record[0:4])), int64(order.Uint32(record[4:8]))*int64(fraction))
+
+			data := make([]byte, order.Uint32(record[8:12]))
+			if _, err := io.ReadFull(r, data); err != nil {
+				return time.Time{}, nil, eofOrTruncated(err)
+			}
+
+			var event []byte
+			switch linktype {
+			case linktypeH4WithPhdr:
+				// 4-byte direction header before the H4 packet
+				if len(data) < 4 {
+					continue
+				}
+				data = data[4:]
+				fallthrough
+			case linktypeH4:
+				if len(data) > 0 && data[0] == hciEventPacket {
+					event = data[1:]
+				}
+			case linktypeMonitor:
+				// Big-endian adapter index and monitor opcode
+				if len(data) >= 4 && binary.BigEndian.Uint16(data[2:4]) == monitorEventPacket {
+					event = data[4:]
+				}
+			}
+			if event == nil {
+				continue
+			}
+
+			if ads, err := parseHCIEvent(event, at); err == nil && len(ads) > 0 {
+				return at, ads, nil
+			}
+		}
+	}, nil
+}
+
+// openJSONL reads a recording written by Record, one advertisement per line
+func openJSONL(r io.Reader) replayFunc {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+
+	return func() (time.Time, []*sensor.Advertisement, error) {
+		for scanner.Scan() {
+			line++
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+
+			var rec recordedAdvertisement
+			if err := json.Unmarshal(text, &rec); err != nil {
+				return time.Time{}, nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			adv, err := rec.advertisement()
+			if err != nil {
+				return time.Time{}, nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			return rec.Time, []*sensor.Advertisement{adv}, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return time.Time{}, nil, err
+		}
+		return time.Time{}, nil, io.EOF
+	}
+}
+
+// eofOrTruncated treats a capture cut in the middle of a record, as left
+// by an interrupted btmon, like a clean end of file
+func eofOrTruncated(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return io.EOF
+	}
+	return err
+}
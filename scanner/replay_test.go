@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sensorsgo/sensor"
+	"testing"
+	"time"
+)
+
+// ruuviReport is an LE Advertising Report event (without the H4 packet
+// type) carrying RuuviTag RAWv2 data from CB:B8:33:4C:88:4F
+const ruuviReport = "3E2B02010001" + "4F884C33B8CB" + "1F" + "020106" +
+	"1BFF9904" + "0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F" + "C5"
+
+// writeBtsnoop writes a btsnoop file with one record per event
+func writeBtsnoop(t *testing.T, path string, datalink uint32, flags uint32, prefix []byte, events ...[]byte) {
+	t.Helper()
+
+	header := append([]byte("btsnoop\x00"), make([]byte, 8)...)
+	binary.BigEndian.PutUint32(header[8:12], 1)
+	binary.BigEndian.PutUint32(header[12:16], datalink)
+
+	buf := header
+	at := time.Date(2026, 2, 3, 15, 30, 45, 0, time.UTC)
+	for i, event := range events {
+		data := append(append([]byte(nil), prefix...), event...)
+		record := make([]byte, 24)
+		binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+		binary.BigEndian.PutUint32(record[4:8], uint32(len(data)))
+		binary.BigEndian.PutUint32(record[8:12], flags)
+		micros := at.Add(time.Duration(i)*time.Second).UnixMicro() + btsnoopEpochDelta
+		binary.BigEndian.PutUint64(record[16:24], uint64(micros))
+		buf = append(append(buf, record...), data...)
+	}
+
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// collect runs sc to completion and returns what it delivered
+func collect(t *testing.T, sc Scanner) []*sensor.Advertisement {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ads, err := sc.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	var got []*sensor.Advertisement
+	for adv := range ads {
+		got = append(got, adv)
+	}
+	if !errors.Is(sc.Err(), ErrExhausted) {
+		t.Errorf("Err = %v, want ErrExhausted", sc.Err())
+	}
+	return got
+}
+
+// TestReplayBtsnoop tests H4 and btmon monitor btsnoop captures
+func TestReplayBtsnoop(t *testing.T) {
+	event, _ := hex.DecodeString(ruuviReport)
+	dir := t.TempDir()
+
+	h4 := filepath.Join(dir, "h4.btsnoop")
+	writeBtsnoop(t, h4, btsnoopH4, 1, []byte{hciEventPacket}, event, event)
+	monitor := filepath.Join(dir, "monitor.btsnoop")
+	// Flags: adapter index 0, monitor opcode 3 (event)
+	writeBtsnoop(t, monitor, btsnoopMonitor, monitorEventPacket, nil, event, event)
+
+	for _, path := range []string{h4, monitor} {
+		sc := NewReplayScanner(path, 0)
+		ads := collect(t, sc)
+		if len(ads) != 2 {
+			t.Fatalf("%s: got %d advertisements, want 2", filepath.Base(path), len(ads))
+		}
+		if ads[0].Address != "CB:B8:33:4C:88:4F" || len(ads[0].ManufacturerData[sensor.RuuviCompanyID]) != 24 {
+			t.Errorf("%s: advertisement = %+v", filepath.Base(path), ads[0])
+		}
+		if _, err := sc.Start(context.Background()); !errors.Is(err, ErrExhausted) {
+			t.Errorf("second Start: expected ErrExhausted, got %v", err)
+		}
+	}
+}
+
+// TestReplayPcap tests a little-endian pcap capture with the H4 link type
+func TestReplayPcap(t *testing.T) {
+	event, _ := hex.DecodeString(ruuviReport)
+	packet := append([]byte{hciEventPacket}, event...)
+
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagic)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], linktypeH4)
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:4], 1770132645)
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(packet)))
+
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	if err := os.WriteFile(path, append(append(header, record...), packet...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ads := collect(t, NewReplayScanner(path, 0))
+	if len(ads) != 1 || ads[0].RSSI != -59 {
+		t.Fatalf("got %+v", ads)
+	}
+}
+
+// TestRecordReplay tests that a recording replays the same advertisements
+func TestRecordReplay(t *testing.T) {
+	event, _ := hex.DecodeString(ruuviReport)
+	dir := t.TempDir()
+	capture := filepath.Join(dir, "capture.btsnoop")
+	writeBtsnoop(t, capture, btsnoopH4, 1, []byte{hciEventPacket}, event)
+
+	recording := filepath.Join(dir, "session.jsonl")
+	recorder, err := Record(NewReplayScanner(capture, 0), recording)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	recorded := collect(t, recorder)
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	replayed := collect(t, NewReplayScanner(recording, 0))
+	if len(recorded) != 1 || len(replayed) != 1 {
+		t.Fatalf("recorded %d, replayed %d advertisements", len(recorded), len(replayed))
+	}
+
+	want, got := recorded[0], replayed[0]
+	if got.Address != want.Address || got.RSSI != want.RSSI ||
+		hex.EncodeToString(got.ManufacturerData[sensor.RuuviCompanyID]) != hex.EncodeToString(want.ManufacturerData[sensor.RuuviCompanyID]) {
+		t.Errorf("replayed %+v, want %+v", got, want)
+	}
+}
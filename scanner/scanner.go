@@ -1,219 +1,203 @@
+// Package scanner provides the BLE scanning backends. Every backend
+// implements Scanner and streams the advertisements it receives.
 package scanner
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
-	"strings"
+	"io"
+	"os"
+	"sensorsgo/sensor"
 	"sync"
 	"time"
 )
 
-// RuuviData contains parsed sensor data
-type RuuviData struct {
-	MAC         string
-	Name        string
-	Temperature float64
-	Humidity    float64
-	Pressure    float64
-	Battery     uint16
+// advertisementBuffer is the capacity of the channel returned by Start
+const advertisementBuffer = 64
+
+// ErrRunning is returned by Start when the scanner is already scanning
+var ErrRunning = errors.New("scanner already running")
+
+// Scanner is a source of BLE advertisements
+type Scanner interface {
+	// Start begins scanning in the background. The returned channel is
+	// closed when scanning ends: ctx is cancelled, Stop is called or the
+	// backend fails.
+	Start(ctx context.Context) (<-chan *sensor.Advertisement, error)
+	// Stop ends scanning and waits for the backend to shut down
+	Stop() error
+	// Err returns the error that ended the last scan, or nil if it was
+	// stopped on request
+	Err() error
 }
 
-// Scanner handles BLE scanning using shell commands
-type Scanner struct {
-	running     bool
-	stopChan    chan struct{}
-	mu          sync.Mutex
-	lastData    map[string]*RuuviData
-	onData      func(*RuuviData)
+// Preparer is implemented by backends that must get the hardware ready
+// before they can scan, such as enabling the Bluetooth adapter. Start
+// prepares the backend itself; callers scanning for a fixed time call
+// Prepare first so that the setup does not eat into that time.
+type Preparer interface {
+	// Prepare gets the backend ready to scan. It does nothing once it
+	// has succeeded.
+	Prepare(ctx context.Context) error
 }
 
-// NewScanner creates a new shell-based BLE scanner
-func NewScanner() *Scanner {
-	return &Scanner{
-		stopChan: make(chan struct{}),
-		lastData: make(map[string]*RuuviData),
+// Prepare prepares sc if its backend needs it
+func Prepare(ctx context.Context, sc Scanner) error {
+	if p, ok := sc.(Preparer); ok {
+		return p.Prepare(ctx)
 	}
+	return nil
 }
 
-// SetCallback sets the callback function for when data is received
-func (s *Scanner) SetCallback(callback func(*RuuviData)) {
-	s.onData = callback
-}
+// Backends lists the backend names accepted by New
+var Backends = []string{"tinygo", "hci", "replay", "ruuvi-json"}
 
-// GetLastData returns the last reading for a sensor
-func (s *Scanner) GetLastData(mac string) *RuuviData {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.lastData[mac]
-}
+// Options holds the backend specific settings used by New
+type Options struct {
+	HCIDevice      int  // hci: adapter index, 0 for hci0
+	HCIUserChannel bool // hci: take exclusive control of the adapter
 
-// GetAllData returns all last readings
-func (s *Scanner) GetAllData() map[string]*RuuviData {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	ReplayFile  string  // replay: btsnoop, pcap or JSONL file
+	ReplaySpeed float64 // replay: speed-up factor, 0 for no delays
 
-	result := make(map[string]*RuuviData)
-	for k, v := range s.lastData {
-		result[k] = v
-	}
-	return result
-}
+	IngestFile string // ruuvi-json: ruuvi_scanner.py output file, "-" for stdin
 
-// Start begins scanning for RuuviTag devices
-func (s *Scanner) Start() error {
-	s.running = true
+	// tinygo: adapter retries, 0 for the scanner's defaults
+	EnableRetries int
+	ScanRetries   int
+	ReadyDelay    time.Duration
+}
 
-	// Use btmon to capture BLE advertisements
-	go s.runBtmon()
+// New creates the scanner for the named backend
+func New(backend string, opts Options) (Scanner, error) {
+	switch backend {
+	case "tinygo":
+		s := NewTinyGoScanner()
+		if opts.EnableRetries > 0 {
+			s.EnableRetries = opts.EnableRetries
+		}
+		if opts.ScanRetries > 0 {
+			s.ScanRetries = opts.ScanRetries
+		}
+		if opts.ReadyDelay > 0 {
+			s.ReadyDelay = opts.ReadyDelay
+		}
+		return s, nil
+	case "hci":
+		s := NewHCIScanner(opts.HCIDevice)
+		s.UserChannel = opts.HCIUserChannel
+		return s, nil
+	case "replay":
+		if opts.ReplayFile == "" {
+			return nil, errors.New("the replay backend needs a file to replay")
+		}
+		return NewReplayScanner(opts.ReplayFile, opts.ReplaySpeed), nil
+	case "ruuvi-json":
+		path := opts.IngestFile
+		if path == "" {
+			path = DefaultIngestFile
+		}
+		return NewIngestScanner(path), nil
+	default:
+		return nil, fmt.Errorf("unknown scanner backend %q (available: %v)", backend, Backends)
+	}
+}
 
-	return nil
+// SetLog sends the progress and error messages of sc to w instead of
+// os.Stdout
+func SetLog(sc Scanner, w io.Writer) {
+	if l, ok := sc.(interface{ setLog(io.Writer) }); ok {
+		l.setLog(w)
+	}
 }
 
-// Stop stops the scanner
-func (s *Scanner) Stop() {
-	s.running = false
-	close(s.stopChan)
+// lifecycle implements the Start/Stop/Err bookkeeping and the messages
+// shared by the backends
+type lifecycle struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+	log    io.Writer
 }
 
-// runBtmon runs btmon to capture BLE data
-func (s *Scanner) runBtmon() {
-	for s.running {
-		// Run hcitool lescan in background and capture with hcidump
-		s.scanWithHcidump()
+func (l *lifecycle) setLog(w io.Writer) {
+	l.log = w
+}
 
-		if s.running {
-			time.Sleep(2 * time.Second)
-		}
+// logf writes a progress or error message
+func (l *lifecycle) logf(format string, args ...interface{}) {
+	w := l.log
+	if w == nil {
+		w = os.Stdout
 	}
+	fmt.Fprintf(w, format, args...)
 }
 
-// scanWithHcidump uses hcitool and hcidump to capture BLE advertisements
-func (s *Scanner) scanWithHcidump() {
-	// Start lescan in background
-	lescan := exec.Command("hcitool", "lescan", "--duplicates", "--passive")
-	lescan.Start()
-
-	// Start hcidump to capture raw data
-	hcidump := exec.Command("hcidump", "--raw")
-	stdout, err := hcidump.StdoutPipe()
-	if err != nil {
-		fmt.Printf("Error creating hcidump pipe: %v\n", err)
-		lescan.Process.Kill()
-		return
-	}
+// start runs fn in the background until it returns or ctx is cancelled
+func (l *lifecycle) start(ctx context.Context, fn func(ctx context.Context, out chan<- *sensor.Advertisement) error) (<-chan *sensor.Advertisement, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	if err := hcidump.Start(); err != nil {
-		fmt.Printf("Error starting hcidump: %v\n", err)
-		lescan.Process.Kill()
-		return
+	if l.cancel != nil {
+		return nil, ErrRunning
 	}
 
-	// Read output
-	scanner := bufio.NewScanner(stdout)
-	var currentPacket bytes.Buffer
+	ctx, cancel := context.WithCancel(ctx)
+	out := make(chan *sensor.Advertisement, advertisementBuffer)
+	done := make(chan struct{})
+	l.cancel = cancel
+	l.done = done
+	l.err = nil
 
 	go func() {
-		for scanner.Scan() {
-			line := scanner.Text()
-
-			// hcidump output format: lines starting with > or < are packet starts
-			if strings.HasPrefix(line, ">") || strings.HasPrefix(line, "<") {
-				// Process previous packet
-				if currentPacket.Len() > 0 {
-					s.processPacket(currentPacket.String())
-				}
-				currentPacket.Reset()
-				currentPacket.WriteString(line)
-			} else {
-				currentPacket.WriteString(line)
-			}
+		err := fn(ctx, out)
+		if ctx.Err() != nil {
+			// Cancelled on request: not a failure
+			err = nil
 		}
-	}()
-
-	// Run for 30 seconds then restart
-	select {
-	case <-time.After(30 * time.Second):
-	case <-s.stopChan:
-	}
+		cancel()
 
-	lescan.Process.Kill()
-	hcidump.Process.Kill()
-}
-
-// processPacket processes a raw HCI packet looking for RuuviTag data
-func (s *Scanner) processPacket(packet string) {
-	// Remove whitespace and convert to hex bytes
-	packet = strings.ReplaceAll(packet, " ", "")
-	packet = strings.ReplaceAll(packet, "\n", "")
-	packet = strings.ReplaceAll(packet, ">", "")
-	packet = strings.ReplaceAll(packet, "<", "")
-
-	// Look for Ruuvi manufacturer ID (0x0499 = little endian 9904)
-	if !strings.Contains(strings.ToLower(packet), "9904") {
-		return
-	}
+		l.mu.Lock()
+		l.err = err
+		l.cancel = nil
+		l.mu.Unlock()
 
-	// Try to parse the packet
-	data, err := hex.DecodeString(packet)
-	if err != nil {
-		return
-	}
+		close(out)
+		close(done)
+	}()
 
-	// Find Ruuvi data (manufacturer ID 0x0499 followed by format 0x05)
-	for i := 0; i < len(data)-26; i++ {
-		if data[i] == 0x99 && data[i+1] == 0x04 && data[i+2] == 0x05 {
-			ruuvi := s.parseRuuviRAWv2(data[i+2:])
-			if ruuvi != nil {
-				s.mu.Lock()
-				s.lastData[ruuvi.MAC] = ruuvi
-				s.mu.Unlock()
-
-				if s.onData != nil {
-					s.onData(ruuvi)
-				}
-			}
-			return
-		}
-	}
+	return out, nil
 }
 
-// parseRuuviRAWv2 parses RAWv2 format data
-func (s *Scanner) parseRuuviRAWv2(data []byte) *RuuviData {
-	if len(data) < 24 {
-		return nil
-	}
+// stop cancels the running scan and waits for it to finish
+func (l *lifecycle) stop() error {
+	l.mu.Lock()
+	cancel, done := l.cancel, l.done
+	l.mu.Unlock()
 
-	// Format byte should be 0x05
-	if data[0] != 0x05 {
+	if cancel == nil {
 		return nil
 	}
+	cancel()
+	<-done
+	return nil
+}
 
-	ruuvi := &RuuviData{}
-
-	// Temperature (bytes 1-2): signed int16, scale 0.005
-	tempRaw := int16(uint16(data[1])<<8 | uint16(data[2]))
-	ruuvi.Temperature = float64(tempRaw) * 0.005
-
-	// Humidity (bytes 3-4): uint16, scale 0.0025
-	humRaw := uint16(data[3])<<8 | uint16(data[4])
-	ruuvi.Humidity = float64(humRaw) * 0.0025
-
-	// Pressure (bytes 5-6): uint16, offset 50000 Pa
-	pressRaw := uint16(data[5])<<8 | uint16(data[6])
-	ruuvi.Pressure = (float64(pressRaw) + 50000.0) / 100.0
-
-	// Battery (bytes 13-14): first 11 bits in mV
-	battRaw := uint16(data[11])<<8 | uint16(data[12])
-	ruuvi.Battery = (battRaw >> 5) + 1600
+// Err returns the error that ended the last scan
+func (l *lifecycle) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
 
-	// MAC address (bytes 18-23)
-	if len(data) >= 24 {
-		ruuvi.MAC = fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X",
-			data[18], data[19], data[20], data[21], data[22], data[23])
+// send delivers adv unless ctx is cancelled first
+func send(ctx context.Context, out chan<- *sensor.Advertisement, adv *sensor.Advertisement) bool {
+	select {
+	case out <- adv:
+		return true
+	case <-ctx.Done():
+		return false
 	}
-
-	return ruuvi
 }
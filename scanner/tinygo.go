@@ -0,0 +1,147 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"sensorsgo/sensor"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// TinyGoScanner scans through tinygo.org/x/bluetooth (BlueZ over D-Bus on Linux)
+type TinyGoScanner struct {
+	lifecycle
+
+	adapter  *bluetooth.Adapter
+	enableMu sync.Mutex
+	enabled  bool
+
+	EnableRetries int           // Attempts to enable the adapter
+	ScanRetries   int           // Attempts to start scanning before giving up
+	ReadyDelay    time.Duration // Wait after enabling the adapter before scanning
+}
+
+// NewTinyGoScanner creates a scanner using the default Bluetooth adapter
+func NewTinyGoScanner() *TinyGoScanner {
+	return &TinyGoScanner{
+		adapter:       bluetooth.DefaultAdapter,
+		EnableRetries: 10,
+		ScanRetries:   5,
+		ReadyDelay:    10 * time.Second,
+	}
+}
+
+// Start enables the adapter if needed and begins scanning
+func (s *TinyGoScanner) Start(ctx context.Context) (<-chan *sensor.Advertisement, error) {
+	return s.start(ctx, s.run)
+}
+
+// Stop stops scanning
+func (s *TinyGoScanner) Stop() error {
+	return s.stop()
+}
+
+// Prepare enables the adapter and waits ReadyDelay for it to settle, the
+// first time it is called
+func (s *TinyGoScanner) Prepare(ctx context.Context) error {
+	s.enableMu.Lock()
+	defer s.enableMu.Unlock()
+	if s.enabled {
+		return nil
+	}
+	if err := s.enable(ctx); err != nil {
+		return err
+	}
+	s.enabled = true
+	return nil
+}
+
+func (s *TinyGoScanner) run(ctx context.Context, out chan<- *sensor.Advertisement) error {
+	if err := s.Prepare(ctx); err != nil {
+		return err
+	}
+
+	// Adapter.Scan blocks until StopScan is called
+	go func() {
+		<-ctx.Done()
+		s.adapter.StopScan()
+	}()
+
+	var err error
+	for attempt := 0; attempt < s.ScanRetries; attempt++ {
+		s.logf("🔍 Scan attempt %d/%d\n", attempt+1, s.ScanRetries)
+
+		err = s.adapter.Scan(func(adapter *bluetooth.Adapter, device bluetooth.ScanResult) {
+			send(ctx, out, toAdvertisement(device))
+		})
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+
+		s.logf("❌ Scan error (attempt %d): %v\n", attempt+1, err)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	return fmt.Errorf("scanning failed after %d attempts: %w", s.ScanRetries, err)
+}
+
+// enable turns the adapter on, retrying while BlueZ is still starting,
+// then waits for it to settle
+func (s *TinyGoScanner) enable(ctx context.Context) error {
+	var err error
+	for i := 0; i < s.EnableRetries; i++ {
+		s.logf("   Enabling Bluetooth adapter, attempt %d/%d...\n", i+1, s.EnableRetries)
+		err = s.adapter.Enable()
+		if err == nil {
+			break
+		}
+		s.logf("   Error: %v\n", err)
+		if i < s.EnableRetries-1 {
+			select {
+			case <-time.After(3 * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("enabling Bluetooth after %d attempts: %w", s.EnableRetries, err)
+	}
+
+	s.logf("⏳ Waiting %v for Bluetooth to be ready...\n", s.ReadyDelay)
+	select {
+	case <-time.After(s.ReadyDelay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// toAdvertisement converts a tinygo scan result into a backend independent advertisement
+func toAdvertisement(device bluetooth.ScanResult) *sensor.Advertisement {
+	adv := &sensor.Advertisement{
+		Address:          device.Address.String(),
+		LocalName:        device.LocalName(),
+		RSSI:             device.RSSI,
+		ManufacturerData: make(map[uint16][]byte),
+		ServiceData:      make(map[uint16][]byte),
+		Timestamp:        time.Now(),
+	}
+
+	for _, mfg := range device.ManufacturerData() {
+		adv.ManufacturerData[mfg.CompanyID] = mfg.Data
+	}
+	for _, svc := range device.ServiceData() {
+		if svc.UUID.Is16Bit() {
+			adv.ServiceData[svc.UUID.Get16Bit()] = svc.Data
+		}
+	}
+
+	return adv
+}
@@ -0,0 +1,75 @@
+package sensor
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// AD structure types used when parsing raw advertising data
+const (
+	adShortName        = 0x08
+	adCompleteName     = 0x09
+	adServiceData16    = 0x16
+	adManufacturerData = 0xFF
+)
+
+// Advertisement holds the parts of a BLE advertisement needed to decode it
+type Advertisement struct {
+	Address          string            // Device address, AA:BB:CC:DD:EE:FF
+	LocalName        string            // Complete or shortened local name
+	RSSI             int16             // dBm
+	ManufacturerData map[uint16][]byte // Keyed by company ID, without the ID itself
+	ServiceData      map[uint16][]byte // Keyed by 16-bit service UUID
+	Timestamp        time.Time         // When the advertisement was received
+
+	// Reading is set by sources that deliver already decoded data, such as
+	// the output of ruuvi_scanner.py, instead of raw advertising data
+	Reading *RuuviData
+}
+
+// ParseAdvertisingData parses raw advertising data (a sequence of
+// length/type/value AD structures) into adv, merging with what it already
+// holds so that scan responses can be added to their advertisement
+func ParseAdvertisingData(data []byte, adv *Advertisement) error {
+	if adv.ManufacturerData == nil {
+		adv.ManufacturerData = make(map[uint16][]byte)
+	}
+	if adv.ServiceData == nil {
+		adv.ServiceData = make(map[uint16][]byte)
+	}
+
+	for len(data) > 0 {
+		length := int(data[0])
+		if length == 0 {
+			// Zero length marks the early end of the data (padding)
+			return nil
+		}
+		if len(data) < 1+length {
+			return errors.New("truncated AD structure")
+		}
+
+		adType := data[1]
+		value := data[2 : 1+length]
+		data = data[1+length:]
+
+		switch adType {
+		case adShortName:
+			if adv.LocalName == "" {
+				adv.LocalName = string(value)
+			}
+		case adCompleteName:
+			adv.LocalName = string(value)
+		case adManufacturerData:
+			if len(value) >= 2 {
+				adv.ManufacturerData[binary.LittleEndian.Uint16(value)] = append([]byte(nil), value[2:]...)
+			}
+		case adServiceData16:
+			if len(value) >= 2 {
+				adv.ServiceData[binary.LittleEndian.Uint16(value)] = append([]byte(nil), value[2:]...)
+			}
+		}
+	}
+
+	return nil
+}
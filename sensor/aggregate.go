@@ -0,0 +1,124 @@
+package sensor
+
+import (
+	"math"
+	"time"
+)
+
+// Stats summarizes the values of one field over an interval
+type Stats struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"` // Population standard deviation
+}
+
+// Aggregate accumulates the readings of one sensor over an interval. A
+// rebroadcast of the same measurement (same sequence number as the
+// previous reading) updates Last but is not counted again.
+type Aggregate struct {
+	Start time.Time  // Timestamp of the first reading
+	End   time.Time  // Timestamp of the last reading
+	Last  *RuuviData // Most recent reading
+
+	samples int
+	fields  map[string]*accumulator
+}
+
+// accumulator keeps running statistics with Welford's algorithm
+type accumulator struct {
+	count    int
+	min, max float64
+	mean, m2 float64
+}
+
+// NewAggregate creates an empty aggregate
+func NewAggregate() *Aggregate {
+	return &Aggregate{fields: make(map[string]*accumulator)}
+}
+
+// Add adds a reading to the interval
+func (a *Aggregate) Add(d *RuuviData) {
+	repeated := a.Last != nil && d.Sequence != nil && a.Last.Sequence != nil &&
+		*d.Sequence == *a.Last.Sequence && d.DataFormat == a.Last.DataFormat
+
+	if a.Last == nil {
+		a.Start = d.Timestamp
+	}
+	a.Last = d
+	a.End = d.Timestamp
+	if repeated {
+		return
+	}
+	a.samples++
+
+	for _, f := range Fields {
+		// The sequence number identifies measurements, it is not one
+		if f.Key == "measurement_sequence" {
+			continue
+		}
+		v, ok := f.Value(d)
+		if !ok || math.IsNaN(v) {
+			continue
+		}
+
+		acc := a.fields[f.Key]
+		if acc == nil {
+			acc = &accumulator{min: v, max: v}
+			a.fields[f.Key] = acc
+		}
+		acc.count++
+		acc.min = math.Min(acc.min, v)
+		acc.max = math.Max(acc.max, v)
+		delta := v - acc.mean
+		acc.mean += delta / float64(acc.count)
+		acc.m2 += delta * (v - acc.mean)
+	}
+}
+
+// Samples returns the number of distinct measurements added
+func (a *Aggregate) Samples() int {
+	return a.samples
+}
+
+// Stats returns the statistics of every field seen in the interval, keyed
+// by Field.Key
+func (a *Aggregate) Stats() map[string]Stats {
+	stats := make(map[string]Stats, len(a.fields))
+	for key, acc := range a.fields {
+		stats[key] = Stats{
+			Count:  acc.count,
+			Min:    acc.min,
+			Max:    acc.max,
+			Mean:   acc.mean,
+			StdDev: math.Sqrt(acc.m2 / float64(acc.count)),
+		}
+	}
+	return stats
+}
+
+// Merge combines the statistics of two sets of values of the same field,
+// as if they had been accumulated together
+func (s Stats) Merge(o Stats) Stats {
+	switch {
+	case o.Count == 0:
+		return s
+	case s.Count == 0:
+		return o
+	}
+
+	n := float64(s.Count + o.Count)
+	delta := o.Mean - s.Mean
+	mean := s.Mean + delta*float64(o.Count)/n
+	m2 := s.StdDev*s.StdDev*float64(s.Count) + o.StdDev*o.StdDev*float64(o.Count) +
+		delta*delta*float64(s.Count)*float64(o.Count)/n
+
+	return Stats{
+		Count:  s.Count + o.Count,
+		Min:    math.Min(s.Min, o.Min),
+		Max:    math.Max(s.Max, o.Max),
+		Mean:   mean,
+		StdDev: math.Sqrt(m2 / n),
+	}
+}
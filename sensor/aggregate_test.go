@@ -0,0 +1,70 @@
+package sensor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestAggregate tests the interval statistics and that rebroadcasts of a
+// measurement are counted once
+func TestAggregate(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	agg := NewAggregate()
+
+	for i, temperature := range []float64{20, 22, 22, 26} {
+		sequence := uint32(i)
+		if i == 2 {
+			sequence = 1 // Rebroadcast of the second measurement
+		}
+		agg.Add(&RuuviData{
+			DataFormat:  FormatRAWv2,
+			Temperature: float64Ptr(temperature),
+			Sequence:    &sequence,
+			Timestamp:   start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	if agg.Samples() != 3 {
+		t.Errorf("Samples() = %d, want 3", agg.Samples())
+	}
+	if !agg.Start.Equal(start) || !agg.End.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("interval = %v - %v", agg.Start, agg.End)
+	}
+	assertFloat(t, "Last.Temperature", agg.Last.Temperature, 26)
+
+	stats := agg.Stats()
+	temperature := stats["temperature"]
+	if temperature.Count != 3 || temperature.Min != 20 || temperature.Max != 26 {
+		t.Errorf("temperature = %+v", temperature)
+	}
+	if math.Abs(temperature.Mean-68.0/3) > 1e-9 {
+		t.Errorf("Mean = %v, want %v", temperature.Mean, 68.0/3)
+	}
+	// Population variance of 20, 22, 26: 56/9
+	if math.Abs(temperature.StdDev-math.Sqrt(56.0/9)) > 1e-9 {
+		t.Errorf("StdDev = %v, want %v", temperature.StdDev, math.Sqrt(56.0/9))
+	}
+	if _, ok := stats["measurement_sequence"]; ok {
+		t.Error("measurement_sequence should not be aggregated")
+	}
+}
+
+// TestStatsMerge tests that merged statistics equal those of all the
+// values accumulated together
+func TestStatsMerge(t *testing.T) {
+	stats := func(values ...float64) Stats {
+		agg := NewAggregate()
+		for _, v := range values {
+			agg.Add(&RuuviData{Temperature: float64Ptr(v)})
+		}
+		return agg.Stats()["temperature"]
+	}
+
+	got := stats(20, 22).Merge(stats(26)).Merge(Stats{})
+	want := stats(20, 22, 26)
+	if got.Count != want.Count || got.Min != want.Min || got.Max != want.Max ||
+		math.Abs(got.Mean-want.Mean) > 1e-9 || math.Abs(got.StdDev-want.StdDev) > 1e-9 {
+		t.Errorf("Merge = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+package sensor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// BTHomeServiceUUID is the 16-bit service UUID of BTHome advertisements
+const BTHomeServiceUUID = 0xFCD2
+
+// bthomeObjectSizes gives the data length of the fixed size BTHome v2
+// objects, so that unused ones can be skipped
+var bthomeObjectSizes = map[byte]int{
+	0x00: 1, 0x01: 1, 0x02: 2, 0x03: 2, 0x04: 3, 0x05: 3, 0x06: 2, 0x07: 2,
+	0x08: 2, 0x09: 1, 0x0A: 3, 0x0B: 3, 0x0C: 2, 0x0D: 2, 0x0E: 2, 0x0F: 1,
+	0x10: 1, 0x11: 1, 0x12: 2, 0x13: 2, 0x14: 2, 0x2E: 1, 0x2F: 1, 0x3A: 1,
+	0x3C: 2, 0x3D: 2, 0x3E: 4, 0x3F: 2, 0x40: 2, 0x41: 2, 0x42: 3, 0x43: 2,
+	0x44: 2, 0x45: 2, 0x46: 1, 0x47: 2, 0x48: 2, 0x49: 2, 0x4A: 2, 0x4B: 3,
+	0x4C: 4, 0x4D: 4, 0x4E: 4, 0x4F: 4, 0x50: 4, 0x51: 2, 0x52: 2, 0x55: 4,
+	0x56: 2, 0x57: 1, 0x58: 1, 0x59: 1, 0x5A: 2, 0x5B: 4, 0x5C: 4, 0x5D: 2,
+	0x5E: 2, 0x5F: 2, 0x60: 1, 0xF0: 2, 0xF1: 4, 0xF2: 3,
+}
+
+func init() {
+	// Binary sensors (battery low, door, motion, ...) are one byte each
+	for id := byte(0x15); id <= 0x2D; id++ {
+		bthomeObjectSizes[id] = 1
+	}
+}
+
+// bthomeDecoder decodes unencrypted BTHome v2 service data
+type bthomeDecoder struct{}
+
+func (bthomeDecoder) Name() string { return "bthome" }
+
+func (bthomeDecoder) Decode(payload []byte, adv *Advertisement) (*RuuviData, error) {
+	return DecodeBTHome(payload)
+}
+
+// DecodeBTHome decodes BTHome v2 service data: a device information byte
+// followed by (object ID, value) pairs in little-endian
+func DecodeBTHome(payload []byte) (*RuuviData, error) {
+	if len(payload) < 1 {
+		return nil, ErrShortPayload
+	}
+
+	info := payload[0]
+	if info&0x01 != 0 {
+		return nil, errors.New("BTHome: encrypted advertisements are not supported")
+	}
+	if version := info >> 5; version != 2 {
+		return nil, fmt.Errorf("BTHome: %w (version %d)", ErrUnsupportedFormat, version)
+	}
+
+	result := &RuuviData{}
+	objects := payload[1:]
+	decoded := 0
+
+	for len(objects) > 0 {
+		id := objects[0]
+		objects = objects[1:]
+
+		size, err := bthomeValueSize(id, objects)
+		if err != nil {
+			// Without the size the remaining objects cannot be located
+			if decoded == 0 {
+				return nil, err
+			}
+			break
+		}
+		if len(objects) < size {
+			return nil, fmt.Errorf("BTHome: %w (object %#02x)", ErrShortPayload, id)
+		}
+
+		value := objects[:size]
+		objects = objects[size:]
+		decoded++
+
+		switch id {
+		case 0x00: // Packet ID
+			result.Sequence = uint32Ptr(uint32(value[0]))
+		case 0x01: // Battery, %
+			result.BatteryPercent = uint8Ptr(value[0])
+		case 0x02: // Temperature, 0.01 °C
+			result.Temperature = float64Ptr(float64(int16(binary.LittleEndian.Uint16(value))) * 0.01)
+		case 0x03: // Humidity, 0.01 %
+			result.Humidity = float64Ptr(float64(binary.LittleEndian.Uint16(value)) * 0.01)
+		case 0x04: // Pressure, 0.01 hPa
+			result.Pressure = float64Ptr(float64(uint24LE(value)) * 0.01)
+		case 0x05: // Illuminance, 0.01 lux
+			result.Luminosity = float64Ptr(float64(uint24LE(value)) * 0.01)
+		case 0x0C: // Voltage, mV
+			battery := binary.LittleEndian.Uint16(value)
+			result.Battery = &battery
+		case 0x0D: // PM2.5, µg/m³
+			result.PM2_5 = float64Ptr(float64(binary.LittleEndian.Uint16(value)))
+		case 0x0E: // PM10, µg/m³
+			result.PM10_0 = float64Ptr(float64(binary.LittleEndian.Uint16(value)))
+		case 0x12: // CO2, ppm
+			co2 := binary.LittleEndian.Uint16(value)
+			result.CO2 = &co2
+		case 0x2E: // Humidity, 1 %
+			result.Humidity = float64Ptr(float64(value[0]))
+		case 0x45: // Temperature, 0.1 °C
+			result.Temperature = float64Ptr(float64(int16(binary.LittleEndian.Uint16(value))) * 0.1)
+		case 0x57: // Temperature, 1 °C
+			result.Temperature = float64Ptr(float64(int8(value[0])))
+		case 0x58: // Temperature, 0.35 °C
+			result.Temperature = float64Ptr(float64(int8(value[0])) * 0.35)
+		}
+	}
+
+	return result, nil
+}
+
+// bthomeValueSize returns the data length of object id, reading the
+// length prefix of variable sized objects from data
+func bthomeValueSize(id byte, data []byte) (int, error) {
+	// Text and raw objects: length byte followed by the data
+	if id == 0x53 || id == 0x54 {
+		if len(data) < 1 {
+			return 0, ErrShortPayload
+		}
+		return 1 + int(data[0]), nil
+	}
+
+	if size, ok := bthomeObjectSizes[id]; ok {
+		return size, nil
+	}
+	return 0, fmt.Errorf("BTHome: unknown object ID %#02x", id)
+}
+
+func uint24LE(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
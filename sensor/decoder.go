@@ -0,0 +1,127 @@
+package sensor
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoDecoder is returned when no registered decoder understands an advertisement
+var ErrNoDecoder = errors.New("no decoder for advertisement")
+
+// Decoder turns the manufacturer or service data of an advertisement into a reading
+type Decoder interface {
+	// Name identifies the decoder and is recorded as the reading's model
+	Name() string
+	// Decode decodes payload, the data registered under the decoder's
+	// company ID or service UUID. adv gives access to the rest of the
+	// advertisement.
+	Decode(payload []byte, adv *Advertisement) (*RuuviData, error)
+}
+
+// Registry dispatches advertisements to decoders by company ID and service UUID
+type Registry struct {
+	manufacturer map[uint16][]Decoder
+	service      map[uint16][]Decoder
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		manufacturer: make(map[uint16][]Decoder),
+		service:      make(map[uint16][]Decoder),
+	}
+}
+
+// DefaultRegistry creates a registry with every built-in decoder
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.RegisterManufacturer(RuuviCompanyID, ruuviDecoder{})
+	r.RegisterService(EddystoneServiceUUID, eddystoneDecoder{})
+	r.RegisterService(BTHomeServiceUUID, bthomeDecoder{})
+	r.RegisterService(ATCServiceUUID, atcDecoder{})
+	r.RegisterManufacturer(GoveeCompanyID, goveeDecoder{})
+	r.RegisterService(SwitchBotServiceUUID, switchBotDecoder{})
+	r.RegisterService(SwitchBotLegacyServiceUUID, switchBotDecoder{})
+	return r
+}
+
+// RegisterManufacturer adds a decoder for manufacturer data with the given company ID
+func (r *Registry) RegisterManufacturer(companyID uint16, d Decoder) {
+	r.manufacturer[companyID] = append(r.manufacturer[companyID], d)
+}
+
+// RegisterService adds a decoder for service data with the given 16-bit UUID
+func (r *Registry) RegisterService(uuid uint16, d Decoder) {
+	r.service[uuid] = append(r.service[uuid], d)
+}
+
+// Decode returns the reading of the first decoder that accepts the
+// advertisement. Manufacturer data is tried before service data; an
+// advertisement that already carries a reading is returned as is.
+func (r *Registry) Decode(adv *Advertisement) (*RuuviData, error) {
+	if adv.Reading != nil {
+		data := *adv.Reading
+		if data.RSSI == 0 {
+			data.RSSI = adv.RSSI
+		}
+		if data.Timestamp.IsZero() {
+			data.Timestamp = adv.Timestamp
+		}
+		return &data, nil
+	}
+
+	err := ErrNoDecoder
+
+	for companyID, payload := range adv.ManufacturerData {
+		for _, d := range r.manufacturer[companyID] {
+			data, decodeErr := d.Decode(payload, adv)
+			if decodeErr != nil {
+				err = decodeErr
+				continue
+			}
+			return r.complete(data, d, adv), nil
+		}
+	}
+
+	for uuid, payload := range adv.ServiceData {
+		for _, d := range r.service[uuid] {
+			data, decodeErr := d.Decode(payload, adv)
+			if decodeErr != nil {
+				err = decodeErr
+				continue
+			}
+			return r.complete(data, d, adv), nil
+		}
+	}
+
+	return nil, err
+}
+
+// complete fills the fields every reading shares
+func (r *Registry) complete(data *RuuviData, d Decoder, adv *Advertisement) *RuuviData {
+	data.Model = d.Name()
+	data.RSSI = adv.RSSI
+	data.Timestamp = adv.Timestamp
+	if data.Timestamp.IsZero() {
+		data.Timestamp = time.Now()
+	}
+	return data
+}
+
+// ruuviDecoder decodes RuuviTag manufacturer data (formats 3, 5, 6 and E1)
+type ruuviDecoder struct{}
+
+func (ruuviDecoder) Name() string { return "ruuvi" }
+
+func (ruuviDecoder) Decode(payload []byte, adv *Advertisement) (*RuuviData, error) {
+	return DecodeManufacturerData(payload)
+}
+
+// eddystoneDecoder decodes the legacy Ruuvi URL formats 2 and 4
+type eddystoneDecoder struct{}
+
+func (eddystoneDecoder) Name() string { return "ruuvi" }
+
+func (eddystoneDecoder) Decode(payload []byte, adv *Advertisement) (*RuuviData, error) {
+	return DecodeEddystone(payload)
+}
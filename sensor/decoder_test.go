@@ -0,0 +1,135 @@
+package sensor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRegistryDecode tests dispatch by company ID and service UUID
+func TestRegistryDecode(t *testing.T) {
+	registry := DefaultRegistry()
+	received := time.Date(2026, 2, 3, 15, 30, 45, 0, time.UTC)
+
+	adv := &Advertisement{
+		Address:          "CB:B8:33:4C:88:4F",
+		RSSI:             -67,
+		ManufacturerData: map[uint16][]byte{RuuviCompanyID: mustHex(t, "0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F")},
+		Timestamp:        received,
+	}
+
+	data, err := registry.Decode(adv)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if data.Model != "ruuvi" || data.RSSI != -67 || !data.Timestamp.Equal(received) {
+		t.Errorf("Decode = model %q, RSSI %d, timestamp %v", data.Model, data.RSSI, data.Timestamp)
+	}
+	assertFloat(t, "Temperature", data.Temperature, 24.3)
+
+	adv = &Advertisement{
+		ServiceData: map[uint16][]byte{BTHomeServiceUUID: mustHex(t, "4000A4016402CA0903BF13")},
+	}
+	data, err = registry.Decode(adv)
+	if err != nil {
+		t.Fatalf("Decode(BTHome): %v", err)
+	}
+	if data.Model != "bthome" {
+		t.Errorf("Model = %q, want bthome", data.Model)
+	}
+
+	adv = &Advertisement{ManufacturerData: map[uint16][]byte{0x004C: {0x02, 0x15}}}
+	if _, err := registry.Decode(adv); !errors.Is(err, ErrNoDecoder) {
+		t.Errorf("unknown advertisement: expected ErrNoDecoder, got %v", err)
+	}
+}
+
+// TestDecodeBTHome tests a BTHome v2 advertisement with packet ID,
+// battery, temperature and humidity objects
+func TestDecodeBTHome(t *testing.T) {
+	data, err := DecodeBTHome(mustHex(t, "4000A4016402CA0903BF13"))
+	if err != nil {
+		t.Fatalf("DecodeBTHome: %v", err)
+	}
+
+	assertFloat(t, "Temperature", data.Temperature, 25.06)
+	assertFloat(t, "Humidity", data.Humidity, 50.55)
+	if data.BatteryPercent == nil || *data.BatteryPercent != 100 {
+		t.Errorf("BatteryPercent = %v, want 100", data.BatteryPercent)
+	}
+	if data.Sequence == nil || *data.Sequence != 0xA4 {
+		t.Errorf("Sequence = %v, want %d", data.Sequence, 0xA4)
+	}
+
+	if _, err := DecodeBTHome(mustHex(t, "4100A4")); err == nil {
+		t.Error("expected error for encrypted advertisement")
+	}
+	if _, err := DecodeBTHome(mustHex(t, "2000A4")); err == nil {
+		t.Error("expected error for BTHome v1")
+	}
+}
+
+// TestDecodeATC tests the ATC1441 and PVVX firmware formats
+func TestDecodeATC(t *testing.T) {
+	data, err := DecodeATC(mustHex(t, "A4C13801020300E62A5D0B9E1C"))
+	if err != nil {
+		t.Fatalf("DecodeATC(ATC1441): %v", err)
+	}
+	assertFloat(t, "Temperature", data.Temperature, 23.0)
+	assertFloat(t, "Humidity", data.Humidity, 42)
+	if *data.Battery != 2974 || *data.BatteryPercent != 93 || data.MAC != "A4:C1:38:01:02:03" {
+		t.Errorf("ATC1441 = %d mV, %d %%, MAC %s", *data.Battery, *data.BatteryPercent, data.MAC)
+	}
+
+	data, err = DecodeATC(mustHex(t, "03020138C1A4FC089411B80B5A0500"))
+	if err != nil {
+		t.Fatalf("DecodeATC(PVVX): %v", err)
+	}
+	assertFloat(t, "Temperature", data.Temperature, 23.0)
+	assertFloat(t, "Humidity", data.Humidity, 45.0)
+	if *data.Battery != 3000 || *data.BatteryPercent != 90 || data.MAC != "A4:C1:38:01:02:03" {
+		t.Errorf("PVVX = %d mV, %d %%, MAC %s", *data.Battery, *data.BatteryPercent, data.MAC)
+	}
+
+	if _, err := DecodeATC(mustHex(t, "0102030405")); err == nil {
+		t.Error("expected error for unknown length")
+	}
+}
+
+// TestDecodeGovee tests positive and negative Govee temperatures
+func TestDecodeGovee(t *testing.T) {
+	data, err := DecodeGovee(mustHex(t, "00031C4B5E00"))
+	if err != nil {
+		t.Fatalf("DecodeGovee: %v", err)
+	}
+	assertFloat(t, "Temperature", data.Temperature, 20.3)
+	assertFloat(t, "Humidity", data.Humidity, 85.1)
+	if *data.BatteryPercent != 94 {
+		t.Errorf("BatteryPercent = %d, want 94", *data.BatteryPercent)
+	}
+
+	// 0x800000 | 52455 -> -5.2 °C, 45.5 %
+	data, err = DecodeGovee(mustHex(t, "0080CCE75000"))
+	if err != nil {
+		t.Fatalf("DecodeGovee(negative): %v", err)
+	}
+	assertFloat(t, "Temperature", data.Temperature, -5.2)
+	assertFloat(t, "Humidity", data.Humidity, 45.5)
+}
+
+// TestDecodeSwitchBot tests a SwitchBot Meter advertisement
+func TestDecodeSwitchBot(t *testing.T) {
+	data, err := DecodeSwitchBot(mustHex(t, "5400E405963A"))
+	if err != nil {
+		t.Fatalf("DecodeSwitchBot: %v", err)
+	}
+	assertFloat(t, "Temperature", data.Temperature, 22.5)
+	assertFloat(t, "Humidity", data.Humidity, 58)
+	if *data.BatteryPercent != 100 {
+		t.Errorf("BatteryPercent = %d, want 100", *data.BatteryPercent)
+	}
+
+	if _, err := DecodeSwitchBot(mustHex(t, "4800E405963A")); err == nil {
+		t.Error("expected error for a SwitchBot Bot (not a meter)")
+	}
+}
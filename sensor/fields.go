@@ -0,0 +1,83 @@
+package sensor
+
+// Field describes one numeric measurement of a reading, named as in the
+// JSON payload sent to the API
+type Field struct {
+	Key  string // JSON name, e.g. "temperature"
+	Unit string // Unit of the value, empty if dimensionless
+	// Value returns the field's value, or false if the reading lacks it
+	Value func(*RuuviData) (float64, bool)
+}
+
+// Fields lists every numeric measurement a reading can carry
+var Fields = []Field{
+	{"temperature", "°C", floatField(func(d *RuuviData) *float64 { return d.Temperature })},
+	{"humidity", "%", floatField(func(d *RuuviData) *float64 { return d.Humidity })},
+	{"pressure", "hPa", floatField(func(d *RuuviData) *float64 { return d.Pressure })},
+	{"battery", "mV", uint16Field(func(d *RuuviData) *uint16 { return d.Battery })},
+	{"battery_percent", "%", func(d *RuuviData) (float64, bool) {
+		if d.BatteryPercent == nil {
+			return 0, false
+		}
+		return float64(*d.BatteryPercent), true
+	}},
+	{"tx_power", "dBm", func(d *RuuviData) (float64, bool) {
+		if d.TxPower == nil {
+			return 0, false
+		}
+		return float64(*d.TxPower), true
+	}},
+	{"acceleration_x", "g", floatField(func(d *RuuviData) *float64 { return d.AccelerationX })},
+	{"acceleration_y", "g", floatField(func(d *RuuviData) *float64 { return d.AccelerationY })},
+	{"acceleration_z", "g", floatField(func(d *RuuviData) *float64 { return d.AccelerationZ })},
+	{"movement_counter", "", func(d *RuuviData) (float64, bool) {
+		if d.MovementCounter == nil {
+			return 0, false
+		}
+		return float64(*d.MovementCounter), true
+	}},
+	{"measurement_sequence", "", func(d *RuuviData) (float64, bool) {
+		if d.Sequence == nil {
+			return 0, false
+		}
+		return float64(*d.Sequence), true
+	}},
+	{"co2", "ppm", uint16Field(func(d *RuuviData) *uint16 { return d.CO2 })},
+	{"pm1_0", "µg/m³", floatField(func(d *RuuviData) *float64 { return d.PM1_0 })},
+	{"pm2_5", "µg/m³", floatField(func(d *RuuviData) *float64 { return d.PM2_5 })},
+	{"pm4_0", "µg/m³", floatField(func(d *RuuviData) *float64 { return d.PM4_0 })},
+	{"pm10_0", "µg/m³", floatField(func(d *RuuviData) *float64 { return d.PM10_0 })},
+	{"voc_index", "", uint16Field(func(d *RuuviData) *uint16 { return d.VOCIndex })},
+	{"nox_index", "", uint16Field(func(d *RuuviData) *uint16 { return d.NOxIndex })},
+	{"luminosity", "lx", floatField(func(d *RuuviData) *float64 { return d.Luminosity })},
+	{"sound_level", "dBA", floatField(func(d *RuuviData) *float64 { return d.SoundLevel })},
+}
+
+// Values returns the fields present in d, keyed by Field.Key
+func (d *RuuviData) Values() map[string]float64 {
+	values := make(map[string]float64)
+	for _, f := range Fields {
+		if v, ok := f.Value(d); ok {
+			values[f.Key] = v
+		}
+	}
+	return values
+}
+
+func floatField(get func(*RuuviData) *float64) func(*RuuviData) (float64, bool) {
+	return func(d *RuuviData) (float64, bool) {
+		if p := get(d); p != nil {
+			return *p, true
+		}
+		return 0, false
+	}
+}
+
+func uint16Field(get func(*RuuviData) *uint16) func(*RuuviData) (float64, bool) {
+	return func(d *RuuviData) (float64, bool) {
+		if p := get(d); p != nil {
+			return float64(*p), true
+		}
+		return 0, false
+	}
+}
@@ -0,0 +1,319 @@
+// Package sensor decodes the data broadcast by BLE environmental sensors
+package sensor
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RuuviCompanyID is the Bluetooth SIG company identifier of Ruuvi Innovations Ltd
+const RuuviCompanyID = 0x0499
+
+// EddystoneServiceUUID is the 16-bit service UUID of Eddystone frames,
+// used by the legacy Ruuvi URL formats
+const EddystoneServiceUUID = 0xFEAA
+
+// Ruuvi data format identifiers
+const (
+	FormatURL   = 0x02 // Eddystone-URL, deprecated
+	FormatRAWv1 = 0x03
+	FormatURLv4 = 0x04 // Eddystone-URL with tag identifier, deprecated
+	FormatRAWv2 = 0x05
+	FormatAir   = 0x06 // Ruuvi Air, BLE4 compatible
+	FormatAirE1 = 0xE1 // Ruuvi Air, extended advertising
+)
+
+// ruuviURLPrefix precedes the base64 encoded data in formats 2 and 4
+const ruuviURLPrefix = "ruu.vi/#"
+
+var (
+	// ErrUnsupportedFormat is returned when the payload uses an unknown data format
+	ErrUnsupportedFormat = errors.New("unsupported data format")
+	// ErrShortPayload is returned when the payload is too short for its format
+	ErrShortPayload = errors.New("payload too short")
+)
+
+// RuuviData contains a decoded sensor reading. Optional values are nil
+// when the sensor does not measure them or reports them as not available.
+// Despite the name it is shared by every supported sensor family.
+type RuuviData struct {
+	MAC             string   // MAC broadcast in the payload, if any
+	Model           string   // Name of the decoder that produced the reading
+	DataFormat      uint8    // Ruuvi data format the reading was decoded from
+	RSSI            int16    // dBm, as received by the scanner
+	Temperature     *float64 // °C
+	Humidity        *float64 // %RH
+	Pressure        *float64 // hPa
+	AccelerationX   *float64 // g
+	AccelerationY   *float64 // g
+	AccelerationZ   *float64 // g
+	Battery         *uint16  // mV
+	BatteryPercent  *uint8   // %, reported by non-Ruuvi sensors
+	TxPower         *int8    // dBm
+	MovementCounter *uint8
+	Sequence        *uint32 // Measurement sequence number, width depends on the format
+
+	// Air quality values, only broadcast by Ruuvi Air
+	CO2        *uint16  // ppm
+	PM1_0      *float64 // µg/m³
+	PM2_5      *float64 // µg/m³
+	PM4_0      *float64 // µg/m³
+	PM10_0     *float64 // µg/m³
+	VOCIndex   *uint16
+	NOxIndex   *uint16
+	Luminosity *float64 // lux
+	SoundLevel *float64 // dBA, average
+
+	Timestamp time.Time // When the reading was received
+}
+
+// MissedSince returns how many measurements were lost between previous and
+// d, based on the measurement sequence number. It returns 0 when the
+// sequence is unknown or the readings come from different formats.
+func (d *RuuviData) MissedSince(previous *RuuviData) int {
+	if previous == nil || d.Sequence == nil || previous.Sequence == nil || d.DataFormat != previous.DataFormat {
+		return 0
+	}
+
+	var modulus uint32
+	switch d.DataFormat {
+	case FormatRAWv2:
+		modulus = 1 << 16
+	case FormatAir:
+		modulus = 1 << 8
+	case FormatAirE1:
+		modulus = 1 << 24
+	default:
+		return 0
+	}
+
+	gap := (*d.Sequence + modulus - *previous.Sequence) % modulus
+	// A repeated or older sequence is not a loss (duplicates, reordering)
+	if gap == 0 || gap >= modulus/2 {
+		return 0
+	}
+	return int(gap - 1)
+}
+
+// DecodeManufacturerData decodes the manufacturer specific data of a Ruuvi
+// advertisement (without the company ID), dispatching on the format byte
+func DecodeManufacturerData(data []byte) (*RuuviData, error) {
+	if len(data) < 1 {
+		return nil, ErrShortPayload
+	}
+
+	switch data[0] {
+	case FormatRAWv1:
+		return DecodeRAWv1(data)
+	case FormatRAWv2:
+		return DecodeRAWv2(data)
+	case FormatAir:
+		return DecodeAir(data)
+	case FormatAirE1:
+		return DecodeAirE1(data)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// DecodeRAWv1 decodes a data format 3 (RAWv1) payload, starting at the
+// format byte. RAWv1 has no "not available" values and carries no MAC.
+func DecodeRAWv1(data []byte) (*RuuviData, error) {
+	if len(data) < 1 || data[0] != FormatRAWv1 {
+		return nil, ErrUnsupportedFormat
+	}
+	if len(data) < 14 {
+		return nil, fmt.Errorf("RAWv1: %w (%d bytes)", ErrShortPayload, len(data))
+	}
+
+	result := &RuuviData{DataFormat: FormatRAWv1}
+
+	// Humidity (byte 1): 0.5 % steps
+	result.Humidity = float64Ptr(float64(data[1]) * 0.5)
+
+	// Temperature (bytes 2-3): sign bit and integer part, then hundredths
+	result.Temperature = float64Ptr(decodeSignedTemperature(data[2], data[3]))
+
+	// Pressure (bytes 4-5): uint16 Pa with a -50000 Pa offset
+	result.Pressure = float64Ptr((float64(binary.BigEndian.Uint16(data[4:6])) + 50000.0) / 100.0)
+
+	// Acceleration X/Y/Z (bytes 6-11): signed int16 in mG
+	result.AccelerationX = float64Ptr(float64(int16(binary.BigEndian.Uint16(data[6:8]))) / 1000.0)
+	result.AccelerationY = float64Ptr(float64(int16(binary.BigEndian.Uint16(data[8:10]))) / 1000.0)
+	result.AccelerationZ = float64Ptr(float64(int16(binary.BigEndian.Uint16(data[10:12]))) / 1000.0)
+
+	// Battery voltage (bytes 12-13): mV
+	battery := binary.BigEndian.Uint16(data[12:14])
+	result.Battery = &battery
+
+	return result, nil
+}
+
+// DecodeEddystone decodes an Eddystone-URL service data frame broadcast by
+// a RuuviTag in data format 2 or 4
+func DecodeEddystone(frame []byte) (*RuuviData, error) {
+	// Frame type 0x10 (URL), TX power, URL scheme, encoded URL
+	if len(frame) < 4 || frame[0] != 0x10 {
+		return nil, ErrUnsupportedFormat
+	}
+
+	url := expandEddystoneURL(frame[3:])
+	idx := strings.Index(url, ruuviURLPrefix)
+	if idx < 0 {
+		return nil, ErrUnsupportedFormat
+	}
+
+	return DecodeURL(url[idx+len(ruuviURLPrefix):])
+}
+
+// DecodeURL decodes the base64 data following "ruu.vi/#" in data formats
+// 2 and 4. Format 4 appends a one character tag identifier.
+func DecodeURL(encoded string) (*RuuviData, error) {
+	if len(encoded) < 8 {
+		return nil, fmt.Errorf("URL: %w (%d characters)", ErrShortPayload, len(encoded))
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded[:8])
+	if err != nil {
+		return nil, fmt.Errorf("URL: invalid encoding: %w", err)
+	}
+
+	if data[0] != FormatURL && data[0] != FormatURLv4 {
+		return nil, ErrUnsupportedFormat
+	}
+
+	result := &RuuviData{DataFormat: data[0]}
+	result.Humidity = float64Ptr(float64(data[1]) * 0.5)
+	result.Temperature = float64Ptr(decodeSignedTemperature(data[2], data[3]))
+	result.Pressure = float64Ptr((float64(binary.BigEndian.Uint16(data[4:6])) + 50000.0) / 100.0)
+
+	return result, nil
+}
+
+// decodeSignedTemperature decodes the sign-magnitude temperature used by
+// formats 2, 3 and 4
+func decodeSignedTemperature(integer, fraction byte) float64 {
+	temperature := float64(integer&0x7F) + float64(fraction)/100.0
+	if integer&0x80 != 0 {
+		return -temperature
+	}
+	return temperature
+}
+
+// eddystoneExpansions are the URL expansion codes of the Eddystone-URL spec
+var eddystoneExpansions = []string{
+	".com/", ".org/", ".edu/", ".net/", ".info/", ".biz/", ".gov/",
+	".com", ".org", ".edu", ".net", ".info", ".biz", ".gov",
+}
+
+func expandEddystoneURL(encoded []byte) string {
+	var url strings.Builder
+	for _, b := range encoded {
+		if int(b) < len(eddystoneExpansions) {
+			url.WriteString(eddystoneExpansions[b])
+		} else {
+			url.WriteByte(b)
+		}
+	}
+	return url.String()
+}
+
+// DecodeRAWv2 decodes a data format 5 (RAWv2) payload, starting at the
+// format byte. Fields set to their "not available" value are left nil.
+func DecodeRAWv2(data []byte) (*RuuviData, error) {
+	if len(data) < 1 || data[0] != FormatRAWv2 {
+		return nil, ErrUnsupportedFormat
+	}
+	if len(data) < 24 {
+		return nil, fmt.Errorf("RAWv2: %w (%d bytes)", ErrShortPayload, len(data))
+	}
+
+	result := &RuuviData{DataFormat: FormatRAWv2}
+
+	// Temperature (bytes 1-2): signed int16, 0.005 °C steps
+	if raw := int16(binary.BigEndian.Uint16(data[1:3])); raw != -0x8000 {
+		result.Temperature = float64Ptr(float64(raw) * 0.005)
+	}
+
+	// Humidity (bytes 3-4): uint16, 0.0025 % steps
+	if raw := binary.BigEndian.Uint16(data[3:5]); raw != 0xFFFF {
+		result.Humidity = float64Ptr(float64(raw) * 0.0025)
+	}
+
+	// Pressure (bytes 5-6): uint16 Pa with a -50000 Pa offset
+	if raw := binary.BigEndian.Uint16(data[5:7]); raw != 0xFFFF {
+		result.Pressure = float64Ptr((float64(raw) + 50000.0) / 100.0)
+	}
+
+	// Acceleration X/Y/Z (bytes 7-12): signed int16 in mG
+	result.AccelerationX = decodeAcceleration(data[7:9])
+	result.AccelerationY = decodeAcceleration(data[9:11])
+	result.AccelerationZ = decodeAcceleration(data[11:13])
+
+	// Power info (bytes 13-14): 11 bits battery voltage above 1.6 V in mV,
+	// 5 bits TX power above -40 dBm in 2 dBm steps
+	power := binary.BigEndian.Uint16(data[13:15])
+	if raw := power >> 5; raw != 0x7FF {
+		battery := raw + 1600
+		result.Battery = &battery
+	}
+	if raw := power & 0x1F; raw != 0x1F {
+		txPower := int8(-40 + 2*int(raw))
+		result.TxPower = &txPower
+	}
+
+	// Movement counter (byte 15)
+	if raw := data[15]; raw != 0xFF {
+		result.MovementCounter = &raw
+	}
+
+	// Measurement sequence number (bytes 16-17)
+	if raw := binary.BigEndian.Uint16(data[16:18]); raw != 0xFFFF {
+		result.Sequence = uint32Ptr(uint32(raw))
+	}
+
+	// MAC address (bytes 18-23)
+	result.MAC = formatMAC(data[18:24])
+
+	return result, nil
+}
+
+func decodeAcceleration(b []byte) *float64 {
+	raw := int16(binary.BigEndian.Uint16(b))
+	if raw == -0x8000 {
+		return nil
+	}
+	return float64Ptr(float64(raw) / 1000.0)
+}
+
+// formatMAC formats a big-endian MAC address, returning "" for the
+// all-ones "not available" value
+func formatMAC(b []byte) string {
+	invalid := true
+	for _, v := range b {
+		if v != 0xFF {
+			invalid = false
+			break
+		}
+	}
+	if invalid {
+		return ""
+	}
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", b[0], b[1], b[2], b[3], b[4], b[5])
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func uint32Ptr(v uint32) *uint32 {
+	return &v
+}
+
+func uint8Ptr(v uint8) *uint8 {
+	return &v
+}
@@ -0,0 +1,150 @@
+package sensor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Flag bits shared by the Ruuvi Air formats
+const (
+	airFlagSoundAverageLSB = 1 << 4
+	airFlagNOxLSB          = 1 << 6
+	airFlagVOCLSB          = 1 << 7
+)
+
+// luminosityStep is the exponent step of the logarithmic luminosity
+// encoding of format 6 (0..254 maps to 0..65535 lux)
+var luminosityStep = math.Log(65536) / 254
+
+// DecodeAir decodes a data format 6 payload (Ruuvi Air, BLE4 compatible),
+// starting at the format byte.
+//
+// Layout: temperature (1-2), humidity (3-4), pressure (5-6), PM2.5 (7-8),
+// CO2 (9-10), VOC and NOx index upper bits (11, 12), logarithmic
+// luminosity (13), reserved (14), sequence (15), flags (16) and the three
+// lowest MAC bytes (17-19).
+func DecodeAir(data []byte) (*RuuviData, error) {
+	if len(data) < 1 || data[0] != FormatAir {
+		return nil, ErrUnsupportedFormat
+	}
+	if len(data) < 20 {
+		return nil, fmt.Errorf("format 6: %w (%d bytes)", ErrShortPayload, len(data))
+	}
+
+	result := &RuuviData{DataFormat: FormatAir}
+	flags := data[16]
+
+	decodeEnvironment(result, data[1:7])
+	result.PM2_5 = decodePM(data[7:9])
+	result.CO2 = decodeCO2(data[9:11])
+	result.VOCIndex = decodeIndex(data[11], flags&airFlagVOCLSB != 0)
+	result.NOxIndex = decodeIndex(data[12], flags&airFlagNOxLSB != 0)
+
+	if raw := data[13]; raw != 0xFF {
+		result.Luminosity = float64Ptr(math.Exp(float64(raw)*luminosityStep) - 1)
+	}
+
+	result.Sequence = uint32Ptr(uint32(data[15]))
+
+	return result, nil
+}
+
+// DecodeAirE1 decodes an extended data format E1 payload (Ruuvi Air over
+// BLE5 extended advertising), starting at the format byte.
+//
+// Layout: temperature (1-2), humidity (3-4), pressure (5-6), PM1.0, PM2.5,
+// PM4.0 and PM10 (7-14), CO2 (15-16), VOC and NOx index upper bits (17,
+// 18), luminosity (19-21), sound instant/average/peak upper bits (22-24),
+// sequence (25-27), flags (28), reserved (29-33) and MAC (34-39).
+func DecodeAirE1(data []byte) (*RuuviData, error) {
+	if len(data) < 1 || data[0] != FormatAirE1 {
+		return nil, ErrUnsupportedFormat
+	}
+	if len(data) < 40 {
+		return nil, fmt.Errorf("format E1: %w (%d bytes)", ErrShortPayload, len(data))
+	}
+
+	result := &RuuviData{DataFormat: FormatAirE1}
+	flags := data[28]
+
+	decodeEnvironment(result, data[1:7])
+	result.PM1_0 = decodePM(data[7:9])
+	result.PM2_5 = decodePM(data[9:11])
+	result.PM4_0 = decodePM(data[11:13])
+	result.PM10_0 = decodePM(data[13:15])
+	result.CO2 = decodeCO2(data[15:17])
+	result.VOCIndex = decodeIndex(data[17], flags&airFlagVOCLSB != 0)
+	result.NOxIndex = decodeIndex(data[18], flags&airFlagNOxLSB != 0)
+
+	// Luminosity (bytes 19-21): uint24, 0.01 lux steps
+	if raw := uint24(data[19:22]); raw != 0xFFFFFF {
+		result.Luminosity = float64Ptr(float64(raw) / 100.0)
+	}
+
+	// Sound average (byte 23): 9 bit value, 0.2 dBA steps above 18 dBA
+	if raw := nineBit(data[23], flags&airFlagSoundAverageLSB != 0); raw != 0x1FF {
+		result.SoundLevel = float64Ptr(float64(raw)*0.2 + 18.0)
+	}
+
+	if raw := uint24(data[25:28]); raw != 0xFFFFFF {
+		result.Sequence = uint32Ptr(raw)
+	}
+
+	result.MAC = formatMAC(data[34:40])
+
+	return result, nil
+}
+
+// decodeEnvironment decodes temperature, humidity and pressure, encoded
+// as in RAWv2
+func decodeEnvironment(result *RuuviData, data []byte) {
+	if raw := int16(binary.BigEndian.Uint16(data[0:2])); raw != -0x8000 {
+		result.Temperature = float64Ptr(float64(raw) * 0.005)
+	}
+	if raw := binary.BigEndian.Uint16(data[2:4]); raw != 0xFFFF {
+		result.Humidity = float64Ptr(float64(raw) * 0.0025)
+	}
+	if raw := binary.BigEndian.Uint16(data[4:6]); raw != 0xFFFF {
+		result.Pressure = float64Ptr((float64(raw) + 50000.0) / 100.0)
+	}
+}
+
+// decodePM decodes a particulate matter concentration in 0.1 µg/m³ steps
+func decodePM(b []byte) *float64 {
+	raw := binary.BigEndian.Uint16(b)
+	if raw == 0xFFFF {
+		return nil
+	}
+	return float64Ptr(float64(raw) / 10.0)
+}
+
+func decodeCO2(b []byte) *uint16 {
+	raw := binary.BigEndian.Uint16(b)
+	if raw == 0xFFFF {
+		return nil
+	}
+	return &raw
+}
+
+// decodeIndex decodes a 9 bit VOC/NOx index whose lowest bit is carried
+// in the flags byte
+func decodeIndex(high byte, lsb bool) *uint16 {
+	raw := nineBit(high, lsb)
+	if raw == 0x1FF {
+		return nil
+	}
+	return &raw
+}
+
+func nineBit(high byte, lsb bool) uint16 {
+	value := uint16(high) << 1
+	if lsb {
+		value |= 1
+	}
+	return value
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
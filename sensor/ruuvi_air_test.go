@@ -0,0 +1,107 @@
+package sensor
+
+import "testing"
+
+// TestDecodeAir tests data format 6
+func TestDecodeAir(t *testing.T) {
+	data, err := DecodeManufacturerData(mustHex(t, "06170C5668C79E007000C90A02FFCD2A804A4E4F"))
+	if err != nil {
+		t.Fatalf("DecodeManufacturerData: %v", err)
+	}
+
+	if data.DataFormat != FormatAir {
+		t.Errorf("DataFormat = %#x, want %#x", data.DataFormat, FormatAir)
+	}
+	assertFloat(t, "Temperature", data.Temperature, 29.5)
+	assertFloat(t, "Humidity", data.Humidity, 55.3)
+	assertFloat(t, "Pressure", data.Pressure, 1011.02)
+	assertFloat(t, "PM2_5", data.PM2_5, 11.2)
+	if data.CO2 == nil || *data.CO2 != 201 {
+		t.Errorf("CO2 = %v, want 201", data.CO2)
+	}
+	// VOC carries its LSB in flag bit 7
+	if data.VOCIndex == nil || *data.VOCIndex != 21 {
+		t.Errorf("VOCIndex = %v, want 21", data.VOCIndex)
+	}
+	if data.NOxIndex == nil || *data.NOxIndex != 4 {
+		t.Errorf("NOxIndex = %v, want 4", data.NOxIndex)
+	}
+	if data.Luminosity != nil {
+		t.Errorf("Luminosity = %v, want nil", *data.Luminosity)
+	}
+	if data.Sequence == nil || *data.Sequence != 0x2A {
+		t.Errorf("Sequence = %v, want 42", data.Sequence)
+	}
+}
+
+// TestDecodeAirE1 tests extended data format E1
+func TestDecodeAirE1(t *testing.T) {
+	payload := "E1" + "170C" + "5668" + "C79E" + // temperature, humidity, pressure
+		"000A" + "0070" + "00FA" + "FFFF" + // PM1.0, PM2.5, PM4.0, PM10 (n/a)
+		"01C9" + // CO2
+		"32" + "FF" + // VOC, NOx (n/a with LSB set)
+		"0186A0" + // luminosity 1000.00 lux
+		"00" + "64" + "00" + // sound instant, average, peak
+		"00ABCD" + // sequence
+		"D0" + // flags: VOC, NOx and sound average LSBs
+		"0000000000" +
+		"CBB8334C884F"
+
+	data, err := DecodeManufacturerData(mustHex(t, payload))
+	if err != nil {
+		t.Fatalf("DecodeManufacturerData: %v", err)
+	}
+
+	assertFloat(t, "Temperature", data.Temperature, 29.5)
+	assertFloat(t, "PM1_0", data.PM1_0, 1.0)
+	assertFloat(t, "PM2_5", data.PM2_5, 11.2)
+	assertFloat(t, "PM4_0", data.PM4_0, 25.0)
+	if data.PM10_0 != nil {
+		t.Errorf("PM10_0 = %v, want nil", *data.PM10_0)
+	}
+	if data.CO2 == nil || *data.CO2 != 457 {
+		t.Errorf("CO2 = %v, want 457", data.CO2)
+	}
+	if data.VOCIndex == nil || *data.VOCIndex != 101 {
+		t.Errorf("VOCIndex = %v, want 101", data.VOCIndex)
+	}
+	if data.NOxIndex != nil {
+		t.Errorf("NOxIndex = %v, want nil", *data.NOxIndex)
+	}
+	assertFloat(t, "Luminosity", data.Luminosity, 1000)
+	// 0x64 << 1 | 1 = 201 -> 201 * 0.2 + 18
+	assertFloat(t, "SoundLevel", data.SoundLevel, 58.2)
+	if data.Sequence == nil || *data.Sequence != 0xABCD {
+		t.Errorf("Sequence = %v, want %d", data.Sequence, 0xABCD)
+	}
+	if data.MAC != "CB:B8:33:4C:88:4F" {
+		t.Errorf("MAC = %q", data.MAC)
+	}
+}
+
+// TestMissedSince tests packet loss detection across sequence wrap-around
+func TestMissedSince(t *testing.T) {
+	reading := func(format uint8, seq uint32) *RuuviData {
+		return &RuuviData{DataFormat: format, Sequence: &seq}
+	}
+
+	tests := []struct {
+		previous, current *RuuviData
+		expected          int
+	}{
+		{reading(FormatRAWv2, 10), reading(FormatRAWv2, 11), 0},
+		{reading(FormatRAWv2, 10), reading(FormatRAWv2, 14), 3},
+		{reading(FormatRAWv2, 65535), reading(FormatRAWv2, 1), 1},
+		{reading(FormatAir, 255), reading(FormatAir, 0), 0},
+		{reading(FormatRAWv2, 10), reading(FormatRAWv2, 10), 0},
+		{reading(FormatRAWv2, 10), reading(FormatRAWv2, 9), 0},
+		{reading(FormatRAWv2, 10), reading(FormatAir, 14), 0},
+		{nil, reading(FormatRAWv2, 14), 0},
+	}
+
+	for i, tt := range tests {
+		if result := tt.current.MissedSince(tt.previous); result != tt.expected {
+			t.Errorf("case %d: MissedSince = %d, want %d", i, result, tt.expected)
+		}
+	}
+}
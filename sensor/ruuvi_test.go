@@ -0,0 +1,182 @@
+package sensor
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+func assertFloat(t *testing.T, name string, got *float64, want float64) {
+	t.Helper()
+	if got == nil {
+		t.Errorf("%s = nil, want %v", name, want)
+		return
+	}
+	if math.Abs(*got-want) > 1e-6 {
+		t.Errorf("%s = %v, want %v", name, *got, want)
+	}
+}
+
+// TestDecodeRAWv2 uses the test vectors from the Ruuvi data format 5 specification
+func TestDecodeRAWv2(t *testing.T) {
+	data, err := DecodeRAWv2(mustHex(t, "0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F"))
+	if err != nil {
+		t.Fatalf("DecodeRAWv2: %v", err)
+	}
+
+	assertFloat(t, "Temperature", data.Temperature, 24.3)
+	assertFloat(t, "Humidity", data.Humidity, 53.49)
+	assertFloat(t, "Pressure", data.Pressure, 1000.44)
+	assertFloat(t, "AccelerationX", data.AccelerationX, 0.004)
+	assertFloat(t, "AccelerationY", data.AccelerationY, -0.004)
+	assertFloat(t, "AccelerationZ", data.AccelerationZ, 1.036)
+
+	if data.Battery == nil || *data.Battery != 2977 {
+		t.Errorf("Battery = %v, want 2977", data.Battery)
+	}
+	if data.TxPower == nil || *data.TxPower != 4 {
+		t.Errorf("TxPower = %v, want 4", data.TxPower)
+	}
+	if data.MovementCounter == nil || *data.MovementCounter != 66 {
+		t.Errorf("MovementCounter = %v, want 66", data.MovementCounter)
+	}
+	if data.Sequence == nil || *data.Sequence != 205 {
+		t.Errorf("Sequence = %v, want 205", data.Sequence)
+	}
+	if data.MAC != "CB:B8:33:4C:88:4F" {
+		t.Errorf("MAC = %q, want CB:B8:33:4C:88:4F", data.MAC)
+	}
+}
+
+// TestDecodeRAWv2Limits tests the maximum and minimum values of the specification
+func TestDecodeRAWv2Limits(t *testing.T) {
+	max, err := DecodeRAWv2(mustHex(t, "057FFFFFFEFFFE7FFF7FFF7FFFFFDEFEFFFECBB8334C884F"))
+	if err != nil {
+		t.Fatalf("DecodeRAWv2(max): %v", err)
+	}
+	assertFloat(t, "Temperature", max.Temperature, 163.835)
+	assertFloat(t, "Humidity", max.Humidity, 163.835)
+	assertFloat(t, "Pressure", max.Pressure, 1155.34)
+	assertFloat(t, "AccelerationX", max.AccelerationX, 32.767)
+	if *max.Battery != 3646 || *max.TxPower != 20 || *max.MovementCounter != 254 || *max.Sequence != 65534 {
+		t.Errorf("max power/counters = %d mV, %d dBm, %d, %d",
+			*max.Battery, *max.TxPower, *max.MovementCounter, *max.Sequence)
+	}
+
+	min, err := DecodeRAWv2(mustHex(t, "058001000000008001800180010000000000CBB8334C884F"))
+	if err != nil {
+		t.Fatalf("DecodeRAWv2(min): %v", err)
+	}
+	assertFloat(t, "Temperature", min.Temperature, -163.835)
+	assertFloat(t, "Humidity", min.Humidity, 0)
+	assertFloat(t, "Pressure", min.Pressure, 500)
+	assertFloat(t, "AccelerationZ", min.AccelerationZ, -32.767)
+	if *min.Battery != 1600 || *min.TxPower != -40 || *min.MovementCounter != 0 || *min.Sequence != 0 {
+		t.Errorf("min power/counters = %d mV, %d dBm, %d, %d",
+			*min.Battery, *min.TxPower, *min.MovementCounter, *min.Sequence)
+	}
+}
+
+// TestDecodeRAWv2Invalid tests that "not available" values are left nil
+func TestDecodeRAWv2Invalid(t *testing.T) {
+	data, err := DecodeRAWv2(mustHex(t, "058000FFFFFFFF800080008000FFFFFFFFFFFFFFFFFFFFFF"))
+	if err != nil {
+		t.Fatalf("DecodeRAWv2: %v", err)
+	}
+
+	if data.Temperature != nil || data.Humidity != nil || data.Pressure != nil {
+		t.Errorf("environment values should be nil: %+v", data)
+	}
+	if data.AccelerationX != nil || data.AccelerationY != nil || data.AccelerationZ != nil {
+		t.Errorf("acceleration should be nil: %+v", data)
+	}
+	if data.Battery != nil || data.TxPower != nil || data.MovementCounter != nil || data.Sequence != nil {
+		t.Errorf("power and counters should be nil: %+v", data)
+	}
+	if data.MAC != "" {
+		t.Errorf("MAC = %q, want empty", data.MAC)
+	}
+}
+
+// TestDecodeRAWv2Errors tests rejection of malformed payloads
+func TestDecodeRAWv2Errors(t *testing.T) {
+	if _, err := DecodeRAWv2(mustHex(t, "0512FC5394")); err == nil {
+		t.Error("expected error for short payload")
+	}
+	if _, err := DecodeRAWv2(mustHex(t, "0312FC5394C37C0004FFFC040CAC364200CDCBB8334C884F")); err != ErrUnsupportedFormat {
+		t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+// TestDecodeRAWv1 uses the test vector from the Ruuvi data format 3 specification
+func TestDecodeRAWv1(t *testing.T) {
+	data, err := DecodeManufacturerData(mustHex(t, "03291A1ECE1EFC18F94202CA0B53"))
+	if err != nil {
+		t.Fatalf("DecodeManufacturerData: %v", err)
+	}
+
+	if data.DataFormat != FormatRAWv1 {
+		t.Errorf("DataFormat = %d, want %d", data.DataFormat, FormatRAWv1)
+	}
+	assertFloat(t, "Temperature", data.Temperature, 26.3)
+	assertFloat(t, "Humidity", data.Humidity, 20.5)
+	assertFloat(t, "Pressure", data.Pressure, 1027.66)
+	assertFloat(t, "AccelerationX", data.AccelerationX, -1.0)
+	assertFloat(t, "AccelerationY", data.AccelerationY, -1.726)
+	assertFloat(t, "AccelerationZ", data.AccelerationZ, 0.714)
+	if data.Battery == nil || *data.Battery != 2899 {
+		t.Errorf("Battery = %v, want 2899", data.Battery)
+	}
+
+	negative, err := DecodeRAWv1(mustHex(t, "03299A1ECE1EFC18F94202CA0B53"))
+	if err != nil {
+		t.Fatalf("DecodeRAWv1: %v", err)
+	}
+	assertFloat(t, "negative Temperature", negative.Temperature, -26.3)
+}
+
+// TestDecodeURL tests the Eddystone-URL formats 2 and 4
+func TestDecodeURL(t *testing.T) {
+	data, err := DecodeURL("AjwYAMFc")
+	if err != nil {
+		t.Fatalf("DecodeURL: %v", err)
+	}
+	if data.DataFormat != FormatURL {
+		t.Errorf("DataFormat = %d, want %d", data.DataFormat, FormatURL)
+	}
+	assertFloat(t, "Temperature", data.Temperature, 24)
+	assertFloat(t, "Humidity", data.Humidity, 30)
+	assertFloat(t, "Pressure", data.Pressure, 995)
+
+	data, err = DecodeURL("BDwYAMFcX")
+	if err != nil {
+		t.Fatalf("DecodeURL(format 4): %v", err)
+	}
+	if data.DataFormat != FormatURLv4 {
+		t.Errorf("DataFormat = %d, want %d", data.DataFormat, FormatURLv4)
+	}
+}
+
+// TestDecodeEddystone tests extraction of the Ruuvi URL from an Eddystone frame
+func TestDecodeEddystone(t *testing.T) {
+	frame := append([]byte{0x10, 0xF9, 0x03}, []byte("ruu.vi/#AjwYAMFc")...)
+	data, err := DecodeEddystone(frame)
+	if err != nil {
+		t.Fatalf("DecodeEddystone: %v", err)
+	}
+	assertFloat(t, "Temperature", data.Temperature, 24)
+
+	other := append([]byte{0x10, 0xF9, 0x03, 'g', 'o', 'o', 'g', 'l', 'e'}, 0x07)
+	if _, err := DecodeEddystone(other); err != ErrUnsupportedFormat {
+		t.Errorf("non-Ruuvi URL: expected ErrUnsupportedFormat, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package sensor
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Identifiers used by the supported third-party thermometers
+const (
+	// ATCServiceUUID is the Environmental Sensing service UUID used by the
+	// ATC1441 and PVVX custom firmwares for Xiaomi LYWSD03MMC
+	ATCServiceUUID = 0x181A
+	// GoveeCompanyID is the company ID used by Govee H5075/H5072 thermometers
+	GoveeCompanyID = 0xEC88
+	// SwitchBotServiceUUID is the service UUID of SwitchBot advertisements
+	SwitchBotServiceUUID = 0xFD3D
+	// SwitchBotLegacyServiceUUID is used by older SwitchBot firmware
+	SwitchBotLegacyServiceUUID = 0x0D00
+)
+
+// atcDecoder decodes the ATC1441 and PVVX custom firmware formats
+type atcDecoder struct{}
+
+func (atcDecoder) Name() string { return "xiaomi-atc" }
+
+func (atcDecoder) Decode(payload []byte, adv *Advertisement) (*RuuviData, error) {
+	return DecodeATC(payload)
+}
+
+// DecodeATC decodes the service data of a Xiaomi thermometer running the
+// ATC1441 (13 bytes, big-endian) or PVVX (15 bytes, little-endian) firmware
+func DecodeATC(payload []byte) (*RuuviData, error) {
+	result := &RuuviData{}
+
+	switch len(payload) {
+	case 13:
+		// MAC (0-5), temperature 0.1 °C (6-7), humidity % (8), battery %
+		// (9), battery mV (10-11), frame counter (12)
+		result.MAC = formatMAC(payload[0:6])
+		result.Temperature = float64Ptr(float64(int16(binary.BigEndian.Uint16(payload[6:8]))) * 0.1)
+		result.Humidity = float64Ptr(float64(payload[8]))
+		result.BatteryPercent = uint8Ptr(payload[9])
+		battery := binary.BigEndian.Uint16(payload[10:12])
+		result.Battery = &battery
+		result.Sequence = uint32Ptr(uint32(payload[12]))
+	case 15:
+		// MAC reversed (0-5), temperature 0.01 °C (6-7), humidity 0.01 %
+		// (8-9), battery mV (10-11), battery % (12), counter (13), flags (14)
+		mac := make([]byte, 6)
+		for i := range mac {
+			mac[i] = payload[5-i]
+		}
+		result.MAC = formatMAC(mac)
+		result.Temperature = float64Ptr(float64(int16(binary.LittleEndian.Uint16(payload[6:8]))) * 0.01)
+		result.Humidity = float64Ptr(float64(binary.LittleEndian.Uint16(payload[8:10])) * 0.01)
+		battery := binary.LittleEndian.Uint16(payload[10:12])
+		result.Battery = &battery
+		result.BatteryPercent = uint8Ptr(payload[12])
+		result.Sequence = uint32Ptr(uint32(payload[13]))
+	default:
+		// Other lengths are the encrypted variants
+		return nil, fmt.Errorf("ATC: %w (%d bytes)", ErrUnsupportedFormat, len(payload))
+	}
+
+	return result, nil
+}
+
+// goveeDecoder decodes Govee H5075/H5072 manufacturer data
+type goveeDecoder struct{}
+
+func (goveeDecoder) Name() string { return "govee" }
+
+func (goveeDecoder) Decode(payload []byte, adv *Advertisement) (*RuuviData, error) {
+	return DecodeGovee(payload)
+}
+
+// DecodeGovee decodes the manufacturer data of a Govee H5075/H5072. Bytes
+// 1-3 pack temperature and humidity as temp*10000 + hum*10, with bit 23
+// marking a negative temperature; byte 4 is the battery percentage.
+func DecodeGovee(payload []byte) (*RuuviData, error) {
+	if len(payload) < 5 {
+		return nil, fmt.Errorf("Govee: %w (%d bytes)", ErrShortPayload, len(payload))
+	}
+
+	packed := uint32(payload[1])<<16 | uint32(payload[2])<<8 | uint32(payload[3])
+	negative := packed&0x800000 != 0
+	packed &^= 0x800000
+
+	temperature := float64(packed/1000) / 10.0
+	if negative {
+		temperature = -temperature
+	}
+
+	result := &RuuviData{}
+	result.Temperature = float64Ptr(temperature)
+	result.Humidity = float64Ptr(float64(packed%1000) / 10.0)
+	result.BatteryPercent = uint8Ptr(payload[4])
+
+	return result, nil
+}
+
+// switchBotDecoder decodes SwitchBot Meter service data
+type switchBotDecoder struct{}
+
+func (switchBotDecoder) Name() string { return "switchbot" }
+
+func (switchBotDecoder) Decode(payload []byte, adv *Advertisement) (*RuuviData, error) {
+	return DecodeSwitchBot(payload)
+}
+
+// DecodeSwitchBot decodes the service data of a SwitchBot Meter, Meter
+// Plus or Outdoor Meter
+func DecodeSwitchBot(payload []byte) (*RuuviData, error) {
+	if len(payload) < 6 {
+		return nil, fmt.Errorf("SwitchBot: %w (%d bytes)", ErrShortPayload, len(payload))
+	}
+
+	// Device type: 'T' Meter, 'i' Meter Plus, 'w' Outdoor Meter
+	switch payload[0] & 0x7F {
+	case 'T', 'i', 'w':
+	default:
+		return nil, fmt.Errorf("SwitchBot: %w (device type %#02x)", ErrUnsupportedFormat, payload[0]&0x7F)
+	}
+
+	// Temperature: tenths in byte 3, integer part in byte 4 whose top bit
+	// is set for positive values
+	temperature := float64(payload[4]&0x7F) + float64(payload[3]&0x0F)/10.0
+	if payload[4]&0x80 == 0 {
+		temperature = -temperature
+	}
+
+	battery := payload[2] & 0x7F
+
+	result := &RuuviData{}
+	result.Temperature = float64Ptr(temperature)
+	result.Humidity = float64Ptr(float64(payload[5] & 0x7F))
+	result.BatteryPercent = &battery
+
+	return result, nil
+}
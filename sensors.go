@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// lastSeenFile es el archivo de estado, dentro de data_dir, donde el
+// monitor guarda cuándo vio cada sensor por última vez
+const lastSeenFile = "last_seen.json"
+
+// lastSeenFresh es la antigüedad máxima del archivo de estado para
+// considerar que el monitor está en marcha (se escribe cada 30 segundos)
+const lastSeenFresh = 90 * time.Second
+
+// lastSeenState es el contenido del archivo de estado
+type lastSeenState struct {
+	UpdatedAt time.Time            `json:"updated_at"`
+	Sensors   map[string]time.Time `json:"sensors"`
+}
+
+const sensorsUsage = `Uso: insectius-monitor [flags] sensors <comando>
+
+Comandos:
+  list                 Mostrar los sensores autorizados
+  add <mac> [nombre]   Autorizar un sensor
+  remove <mac>         Retirar un sensor
+  rename <mac> <nombre>
+                       Cambiar el nombre de un sensor
+  disable <mac>        Dejar de leer un sensor sin retirarlo
+  enable <mac>         Volver a leer un sensor desactivado`
+
+// runSensorsCommand ejecuta el subcomando sensors y devuelve el código de
+// salida
+func runSensorsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(sensorsUsage)
+		return 2
+	}
+
+	var err error
+	switch command, args := args[0], args[1:]; {
+	case command == "list" && len(args) == 0:
+		err = listSensors()
+	case command == "add" && (len(args) == 1 || len(args) == 2):
+		name := ""
+		if len(args) == 2 {
+			name = args[1]
+		}
+		err = editSensors(args[0], addSensor(name))
+	case command == "remove" && len(args) == 1:
+		err = editSensors(args[0], removeSensor)
+	case command == "rename" && len(args) >= 2:
+		name := strings.Join(args[1:], " ")
+		err = editSensors(args[0], func(config *Config, mac string, i int) (string, error) {
+			if i < 0 {
+				return "", fmt.Errorf("el sensor %s %w", mac, errSensorMissing)
+			}
+			old := config.Sensors[i].Name
+			config.Sensors[i].Name = name
+			return fmt.Sprintf("✏️  Sensor renombrado: %s → %s (%s)", old, name, mac), nil
+		})
+	case (command == "disable" || command == "enable") && len(args) == 1:
+		disable := command == "disable"
+		err = editSensors(args[0], func(config *Config, mac string, i int) (string, error) {
+			if i < 0 {
+				return "", fmt.Errorf("el sensor %s %w", mac, errSensorMissing)
+			}
+			config.Sensors[i].Disabled = disable
+			if disable {
+				return fmt.Sprintf("⏸️  Sensor desactivado: %s (%s)", config.Sensors[i].Name, mac), nil
+			}
+			return fmt.Sprintf("▶️  Sensor activado: %s (%s)", config.Sensors[i].Name, mac), nil
+		})
+	default:
+		fmt.Println(sensorsUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Errores de las ediciones de la lista de sensores, para distinguirlos
+// en la API local
+var (
+	errSensorExists  = errors.New("ya está autorizado")
+	errSensorMissing = errors.New("no está en la lista")
+	// Sin sensores activos el monitor se quedaría sin nada que leer y, al
+	// reiniciarse, volvería a registrar sensores
+	errLastSensor = errors.New("es el último sensor activo; añade o activa otro antes")
+)
+
+// sensorEdit cambia la lista de sensores. Recibe la posición del sensor
+// en la lista (-1 si no está) y devuelve el mensaje a mostrar.
+type sensorEdit func(config *Config, mac string, i int) (string, error)
+
+// addSensor autoriza un sensor nuevo con el nombre dado
+func addSensor(name string) sensorEdit {
+	return func(config *Config, mac string, i int) (string, error) {
+		if i >= 0 {
+			return "", fmt.Errorf("el sensor %s %w", mac, errSensorExists)
+		}
+		config.Sensors = append(config.Sensors, AuthorizedSensor{MAC: mac, Name: name, RegisteredAt: time.Now()})
+		return fmt.Sprintf("➕ Sensor autorizado: %s (%s)", name, mac), nil
+	}
+}
+
+// removeSensor retira un sensor de la lista
+func removeSensor(config *Config, mac string, i int) (string, error) {
+	if i < 0 {
+		return "", fmt.Errorf("el sensor %s %w", mac, errSensorMissing)
+	}
+	removed := config.Sensors[i]
+	config.Sensors = append(config.Sensors[:i], config.Sensors[i+1:]...)
+	return fmt.Sprintf("➖ Sensor retirado: %s (%s)", removed.Name, mac), nil
+}
+
+// updateSensors valida mac, aplica edit a la lista de sensores y la
+// guarda con el archivo <sensors_file>.lock bloqueado. Devuelve el mensaje
+// de edit y la lista guardada. No guarda una edición que deje la lista sin
+// sensores activos.
+func updateSensors(address string, edit sensorEdit) (string, *Config, error) {
+	mac, err := parseMAC(address)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// El subcomando sensors y la API local del monitor pueden editar la
+	// lista a la vez
+	unlock, err := lockFile(cfg.SensorsFile + ".lock")
+	if err != nil {
+		return "", nil, fmt.Errorf("error bloqueando la lista de sensores: %w", err)
+	}
+	defer unlock()
+
+	config, err := readSensors(cfg.SensorsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		config, err = &Config{Sensors: []AuthorizedSensor{}}, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	index := -1
+	for i, sensor := range config.Sensors {
+		if sensor.MAC == mac {
+			index = i
+			break
+		}
+	}
+
+	enabled := enabledSensors(config)
+	message, err := edit(config, mac, index)
+	if err != nil {
+		return "", nil, err
+	}
+	if enabled > 0 && enabledSensors(config) == 0 {
+		return "", nil, fmt.Errorf("el sensor %s %w", mac, errLastSensor)
+	}
+	if err := saveConfig(config); err != nil {
+		return "", nil, err
+	}
+	return message, config, nil
+}
+
+// editSensors aplica edit a la lista de sensores y muestra el resultado
+func editSensors(address string, edit sensorEdit) error {
+	message, config, err := updateSensors(address, edit)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(message)
+	if enabledSensors(config) == 0 {
+		fmt.Println("⚠️  No queda ningún sensor activo; el monitor mantendrá su lista actual hasta que se añada alguno")
+	} else if running, _ := readLastSeen(); running {
+		fmt.Println("🔄 El monitor en marcha aplicará el cambio en unos segundos")
+	}
+	return nil
+}
+
+// listSensors muestra los sensores autorizados y cuándo se vieron por
+// última vez
+func listSensors() error {
+	config, err := readSensors(cfg.SensorsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("No hay sensores registrados (%s no existe)\n", cfg.SensorsFile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	running, seen := readLastSeen()
+	if running {
+		fmt.Println("🟢 Monitor en marcha")
+	} else {
+		fmt.Println("⚪ Monitor detenido")
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MAC\tNOMBRE\tESTADO\tREGISTRADO\tVISTO")
+	for _, sensor := range config.Sensors {
+		state := "activo"
+		if sensor.Disabled {
+			state = "desactivado"
+		}
+		lastSeen := "nunca"
+		if t, ok := seen[sensor.MAC]; ok {
+			lastSeen = "hace " + formatAge(now.Sub(t))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", sensor.MAC, sensor.Name, state, sensor.RegisteredAt.Local().Format("2006-01-02"), lastSeen)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d sensores (%d activos) en %s\n", len(config.Sensors), enabledSensors(config), cfg.SensorsFile)
+	return nil
+}
+
+// enabledSensors cuenta los sensores no desactivados
+func enabledSensors(config *Config) int {
+	n := 0
+	for _, sensor := range config.Sensors {
+		if !sensor.Disabled {
+			n++
+		}
+	}
+	return n
+}
+
+// parseMAC valida una dirección MAC (AA:BB:CC:DD:EE:FF, también con
+// guiones o en minúsculas) y la devuelve en el formato de los escáneres
+func parseMAC(address string) (string, error) {
+	hw, err := net.ParseMAC(address)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("MAC inválida %q (formato AA:BB:CC:DD:EE:FF)", address)
+	}
+	return strings.ToUpper(hw.String()), nil
+}
+
+// formatAge redondea una antigüedad para mostrarla
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return d.Round(time.Second).String()
+	case d < time.Hour:
+		return d.Round(time.Minute).String()
+	default:
+		return d.Round(time.Hour).String()
+	}
+}
+
+// readLastSeen lee el archivo de estado del monitor. running indica si
+// el monitor lo ha escrito hace poco.
+func readLastSeen() (running bool, seen map[string]time.Time) {
+	data, err := os.ReadFile(filepath.Join(cfg.DataDir, lastSeenFile))
+	if err != nil {
+		return false, nil
+	}
+	var state lastSeenState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, nil
+	}
+	return time.Since(state.UpdatedAt) < lastSeenFresh, state.Sensors
+}
+
+// saveLastSeen guarda cuándo se vio cada sensor por última vez para
+// `sensors list`
+func saveLastSeen() {
+	lastSeenMutex.Lock()
+	state := lastSeenState{UpdatedAt: time.Now(), Sensors: make(map[string]time.Time, len(lastSeenMap))}
+	for mac, t := range lastSeenMap {
+		state.Sensors[mac] = t
+	}
+	lastSeenMutex.Unlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(cfg.DataDir, lastSeenFile), data, 0644); err != nil {
+		fmt.Printf("⚠️  Error guardando %s: %v\n", lastSeenFile, err)
+	}
+}
+
+// writeFileAtomic escribe data en path a través de un archivo temporal,
+// de modo que un corte nunca deja el archivo a medio escribir
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sensorsgo/settings"
+	"sync"
+	"testing"
+)
+
+// useSensorsFile points cfg to an empty sensors file in a temporary
+// directory
+func useSensorsFile(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	cfg = settings.Default()
+	cfg.SensorsFile = filepath.Join(dir, "authorized_sensors.json")
+	cfg.DataDir = dir
+	return cfg.SensorsFile
+}
+
+// TestParseMAC tests the accepted MAC formats
+func TestParseMAC(t *testing.T) {
+	for _, address := range []string{"C4:7C:8D:6A:1B:2E", "c4:7c:8d:6a:1b:2e", "C4-7C-8D-6A-1B-2E"} {
+		if mac, err := parseMAC(address); err != nil || mac != "C4:7C:8D:6A:1B:2E" {
+			t.Errorf("parseMAC(%q) = %q, %v", address, mac, err)
+		}
+	}
+	for _, address := range []string{"", "C4:7C:8D:6A:1B", "C4:7C:8D:6A:1B:2E:00:11", "sensor"} {
+		if _, err := parseMAC(address); err == nil {
+			t.Errorf("parseMAC(%q): expected error", address)
+		}
+	}
+}
+
+// TestUpdateSensors tests the sensors subcommands on the sensors file
+func TestUpdateSensors(t *testing.T) {
+	path := useSensorsFile(t)
+
+	if _, _, err := updateSensors("c4-7c-8d-6a-1b-2e", addSensor("Bandeja 1")); err != nil {
+		t.Fatalf("add to a missing file: %v", err)
+	}
+	if _, _, err := updateSensors("AA:BB:CC:DD:EE:FF", addSensor("Bandeja 2")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, _, err := updateSensors("C4:7C:8D:6A:1B:2E", addSensor("Otra")); !errors.Is(err, errSensorExists) {
+		t.Errorf("add twice = %v, want errSensorExists", err)
+	}
+	if _, _, err := updateSensors("11:22:33:44:55:66", removeSensor); !errors.Is(err, errSensorMissing) {
+		t.Errorf("remove unknown = %v, want errSensorMissing", err)
+	}
+	if _, _, err := updateSensors("bandeja", removeSensor); err == nil {
+		t.Error("invalid MAC: expected error")
+	}
+	if code := runSensorsCommand([]string{"rename", "aa:bb:cc:dd:ee:ff", "Bandeja", "2B"}); code != 0 {
+		t.Errorf("rename: exit code %d", code)
+	}
+	if code := runSensorsCommand([]string{"disable", "AA:BB:CC:DD:EE:FF"}); code != 0 {
+		t.Errorf("disable: exit code %d", code)
+	}
+	if code := runSensorsCommand([]string{"enable", "11:22:33:44:55:66"}); code != 1 {
+		t.Errorf("enable unknown: exit code %d, want 1", code)
+	}
+
+	config, err := readSensors(path)
+	if err != nil {
+		t.Fatalf("readSensors: %v", err)
+	}
+	got := fmt.Sprintf("%s %s %s %v", config.Sensors[0].MAC, config.Sensors[1].MAC, config.Sensors[1].Name, config.Sensors[1].Disabled)
+	if len(config.Sensors) != 2 || got != "C4:7C:8D:6A:1B:2E AA:BB:CC:DD:EE:FF Bandeja 2B true" {
+		t.Errorf("sensors = %+v", config.Sensors)
+	}
+
+	// Every save keeps the previous list in a backup
+	before, _ := os.ReadFile(path)
+	if _, _, err := updateSensors("AA:BB:CC:DD:EE:FF", removeSensor); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if backup, err := os.ReadFile(path + ".bak"); err != nil || string(backup) != string(before) {
+		t.Errorf("backup = %s, %v; want the list before removing", backup, err)
+	}
+	if config, _ := readSensors(path); len(config.Sensors) != 1 {
+		t.Errorf("sensors after remove = %+v", config.Sensors)
+	}
+
+	// The last enabled sensor can be neither removed nor disabled
+	before, _ = os.ReadFile(path)
+	if _, _, err := updateSensors("C4:7C:8D:6A:1B:2E", removeSensor); !errors.Is(err, errLastSensor) {
+		t.Errorf("remove the last sensor = %v, want errLastSensor", err)
+	}
+	if code := runSensorsCommand([]string{"disable", "C4:7C:8D:6A:1B:2E"}); code != 1 {
+		t.Errorf("disable the last sensor: exit code %d, want 1", code)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Errorf("sensors file changed to %s", after)
+	}
+}
+
+// TestUpdateSensorsConcurrent tests that edits made at the same time, like
+// a sensors subcommand and an API request, all end up in the file
+func TestUpdateSensorsConcurrent(t *testing.T) {
+	path := useSensorsFile(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mac := fmt.Sprintf("AA:BB:CC:DD:EE:%02X", i)
+			if _, _, err := updateSensors(mac, addSensor(mac)); err != nil {
+				t.Errorf("add %s: %v", mac, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	config, err := readSensors(path)
+	if err != nil {
+		t.Fatalf("readSensors: %v", err)
+	}
+	if len(config.Sensors) != 20 {
+		t.Errorf("%d sensors saved, want 20", len(config.Sensors))
+	}
+}
+
+// TestWriteFileAtomic tests that the file is replaced with the given mode
+// and no temporary file is left behind
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(path, []byte(content), 0600); err != nil {
+			t.Fatalf("writeFileAtomic: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "second" {
+		t.Errorf("content = %q, %v", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, %v", info.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files in the directory, want 1", len(entries))
+	}
+}
+
+// TestDiffSensors tests the changes logged when the list is reloaded
+func TestDiffSensors(t *testing.T) {
+	old := newSensorSet(&Config{Sensors: []AuthorizedSensor{
+		{MAC: "A", Name: "Bandeja 1"},
+		{MAC: "B", Name: "Bandeja 2"},
+		{MAC: "C", Name: "Bandeja 3", Disabled: true},
+		{MAC: "D", Name: "Bandeja 4"},
+	}})
+	next := newSensorSet(&Config{Sensors: []AuthorizedSensor{
+		{MAC: "A", Name: "Bandeja 1"},
+		{MAC: "B", Name: "Bandeja 2B", Disabled: true},
+		{MAC: "C", Name: "Bandeja 3"},
+		{MAC: "E", Name: "Bandeja 5"},
+	}})
+
+	want := []string{
+		"✏️  Sensor renombrado: Bandeja 2 → Bandeja 2B (B)",
+		"⏸️  Sensor desactivado: Bandeja 2B (B)",
+		"▶️  Sensor activado: Bandeja 3 (C)",
+		"➕ Sensor autorizado: Bandeja 5 (E)",
+		"➖ Sensor retirado: Bandeja 4 (D)",
+	}
+	if got := diffSensors(old, next); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("diffSensors =\n%q\nwant\n%q", got, want)
+	}
+	if got := diffSensors(next, next); len(got) != 0 {
+		t.Errorf("diffSensors of the same list = %q", got)
+	}
+}
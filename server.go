@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// serveHTTP sirve las métricas, la API local y los eventos en vivo en
+// addr hasta que ctx termina. Si no puede escuchar lo avisa y el monitor
+// sigue sin servidor.
+func serveHTTP(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	handleAPI(mux)
+	mux.Handle("/stream", eventHub.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(console, "❌ Servidor HTTP local: %v\n", err)
+		addLog(fmt.Sprintf("❌ Servidor HTTP local: %v", err))
+	}
+}
+
+// handleAPI añade a mux las rutas de la API local
+func handleAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/sensors", handleSensors)
+	mux.HandleFunc("/sensors/", handleSensor)
+	mux.HandleFunc("/status", handleStatus)
+}
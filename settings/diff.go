@@ -0,0 +1,88 @@
+package settings
+
+import (
+	"sensorsgo/sink"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change is a setting whose value differs between two Settings
+type Change struct {
+	Key string
+	Old string
+	New string
+}
+
+// Diff lists the settings that differ from old to new, in the order of
+// the configuration file. Secrets are masked; changed sinks are reported
+// under the key "sink" in the format of the -sink flag.
+func Diff(old, new *Settings) []Change {
+	var changes []Change
+	for _, st := range settings {
+		before, after := st.value(old).String(), st.value(new).String()
+		if before == after {
+			continue
+		}
+		if st.secret {
+			before, after = maskSecret(before), maskSecret(after)
+		}
+		changes = append(changes, Change{Key: st.key, Old: before, New: after})
+	}
+
+	before, after := formatSinks(old.Sinks), formatSinks(new.Sinks)
+	if before != after {
+		changes = append(changes, Change{Key: "sink", Old: before, New: after})
+	}
+	return changes
+}
+
+// RetryPolicy returns the retry policy of the sink runners
+func (s *Settings) RetryPolicy() sink.Policy {
+	return sink.Policy{
+		MinBackoff:       s.Retry.MinBackoff,
+		MaxBackoff:       s.Retry.MaxBackoff,
+		BreakerThreshold: s.Retry.BreakerThreshold,
+		BreakerCooldown:  s.Retry.BreakerCooldown,
+	}
+}
+
+func maskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return masked
+}
+
+// formatSinks formats sinks as -sink specs separated by "; "
+func formatSinks(sinks []sink.Config) string {
+	specs := make([]string, len(sinks))
+	for i, cfg := range sinks {
+		parts := []string{cfg.Type}
+		if cfg.Name != "" && cfg.Name != cfg.Type {
+			parts = append(parts, "name="+cfg.Name)
+		}
+		if cfg.Batch > 0 {
+			parts = append(parts, "batch="+strconv.Itoa(cfg.Batch))
+		}
+		keys := make([]string, 0, len(cfg.Options))
+		for key := range cfg.Options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := cfg.Options[key]
+			if secretOption(key) {
+				value = masked
+			}
+			parts = append(parts, key+"="+value)
+		}
+		specs[i] = strings.Join(parts, ",")
+	}
+	return strings.Join(specs, "; ")
+}
+
+// secretOption reports whether a sink option holds a credential
+func secretOption(key string) bool {
+	return key == "key" || key == "token" || key == "password"
+}
@@ -0,0 +1,90 @@
+package settings
+
+import (
+	"flag"
+	"fmt"
+	"sensorsgo/sink"
+	"strings"
+)
+
+// CommandLine collects the settings given as flags, to be applied over
+// the file and environment once those are loaded
+type CommandLine struct {
+	set   []flagSetting
+	sinks []sink.Config
+}
+
+type flagSetting struct {
+	setting setting
+	value   string
+}
+
+// commandLineValue records a flag for CommandLine.Apply
+type commandLineValue struct {
+	c       *CommandLine
+	setting setting
+	isBool  bool
+	def     string
+}
+
+func (v *commandLineValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.def
+}
+
+func (v *commandLineValue) Set(s string) error {
+	// Check the value now so that flag reports errors with the usage
+	if err := v.setting.value(Default()).Set(s); err != nil {
+		return err
+	}
+	v.c.set = append(v.c.set, flagSetting{setting: v.setting, value: s})
+	return nil
+}
+
+func (v *commandLineValue) IsBoolFlag() bool { return v.isBool }
+
+// Bind registers on fs a flag for every setting that has one, plus the
+// repeatable -sink flag, showing the built-in defaults
+func Bind(fs *flag.FlagSet) *CommandLine {
+	c := &CommandLine{}
+	defaults := Default()
+
+	for _, st := range settings {
+		if st.flag == "" {
+			continue
+		}
+		v := st.value(defaults)
+		_, isBool := v.(*boolValue)
+		def := v.String()
+		if def == "0" || def == "false" {
+			def = "" // flag does not print zero defaults
+		}
+		fs.Var(&commandLineValue{c: c, setting: st, isBool: isBool, def: def}, st.flag, st.usage)
+	}
+
+	fs.Func("sink", fmt.Sprintf("Destino de las lecturas como tipo[,clave=valor...] (%s); repetible, por defecto api", strings.Join(sink.Types, ", ")), func(spec string) error {
+		cfg, err := sink.ParseSpec(spec)
+		if err != nil {
+			return err
+		}
+		c.sinks = append(c.sinks, cfg)
+		return nil
+	})
+	return c
+}
+
+// Apply overrides s with the flags given on the command line. Any -sink
+// replaces the configured sinks.
+func (c *CommandLine) Apply(s *Settings) error {
+	for _, f := range c.set {
+		if err := f.setting.value(s).Set(f.value); err != nil {
+			return fmt.Errorf("-%s: %w", f.setting.flag, err)
+		}
+	}
+	if len(c.sinks) > 0 {
+		s.Sinks = append([]sink.Config(nil), c.sinks...)
+	}
+	return nil
+}
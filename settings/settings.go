@@ -0,0 +1,440 @@
+// Package settings holds the tunables of a deployment. They start from
+// built-in defaults and are overridden, in order, by a TOML configuration
+// file, SENSORGO_* environment variables and command-line flags.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sensorsgo/mqtt"
+	"sensorsgo/scanner"
+	"sensorsgo/sink"
+	"strings"
+	"time"
+)
+
+// DefaultFile is the configuration file read when none is given
+const DefaultFile = "sensorgo.toml"
+
+// DefaultAPIURL is the production Larvai API
+const DefaultAPIURL = "https://go.larvai.com/api/v1/sensors"
+
+// EnvPrefix is the prefix of the environment variables that override
+// settings: mqtt.broker is SENSORGO_MQTT_BROKER
+const EnvPrefix = "SENSORGO_"
+
+// EnvConfig names the configuration file when -config is not given
+const EnvConfig = EnvPrefix + "CONFIG"
+
+// RegistrationModes are the ways of choosing sensors when registering:
+// every sensor heard, a selection from the list, or a selection of the
+// sensors confirmed by moving them or holding them next to the scanner
+var RegistrationModes = []string{"all", "select", "tap"}
+
+// Settings is the configuration of a deployment
+type Settings struct {
+	APIURL           string        // Larvai API endpoint
+	APIKey           string        // Overrides the key in APIKeyFile
+	APIKeyFile       string        // File holding the API key, ~ is the home directory
+	SensorsFile      string        // Authorized sensors
+	DataDir          string        // Persistent data (sink queues)
+	SendInterval     time.Duration // Aggregation and upload interval
+	OnlineTimeout    time.Duration // A sensor not seen for this long is offline
+	RegistrationScan time.Duration // Scan length when registering sensors
+	RegistrationMode string        // How sensors are chosen when registering, one of RegistrationModes
+	TapRSSI          int           // dBm from which a sensor counts as tapped in tap mode
+	FirstSyncDelay   time.Duration // Wait before the first upload after startup
+
+	Bluetooth struct {
+		EnableRetries int           // Attempts to enable the adapter (tinygo backend)
+		ScanRetries   int           // Attempts to start scanning (tinygo backend)
+		ReadyDelay    time.Duration // Wait after enabling the adapter
+	}
+
+	Scanner struct {
+		Backend        string
+		HCIDevice      int
+		HCIUserChannel bool
+		Replay         string
+		ReplaySpeed    float64
+		Ingest         string
+		Record         string
+	}
+
+	Gateway struct {
+		Listen string
+		Token  string
+	}
+
+	MQTT struct {
+		Broker          string
+		User            string
+		Password        string
+		TopicPrefix     string
+		DiscoveryPrefix string
+	}
+
+	Retry struct {
+		MinBackoff       time.Duration
+		MaxBackoff       time.Duration
+		BreakerThreshold int
+		BreakerCooldown  time.Duration
+	}
+
+	History struct {
+		Enabled         bool
+		RawRetention    time.Duration // 0 keeps the readings forever
+		MinuteRetention time.Duration
+		HourRetention   time.Duration
+	}
+
+	HTTP struct {
+		Listen string // Local HTTP server (/metrics, local API and /stream), empty = disabled
+		Token  string // Bearer token required to manage sensors, empty = read only
+	}
+
+	Sinks []sink.Config
+}
+
+// Default returns the built-in settings
+func Default() *Settings {
+	s := &Settings{
+		APIURL:           DefaultAPIURL,
+		APIKeyFile:       "~/.insectius-monitor",
+		SensorsFile:      "authorized_sensors.json",
+		DataDir:          "data",
+		SendInterval:     5 * time.Minute,
+		OnlineTimeout:    2 * time.Minute,
+		RegistrationScan: 10 * time.Second,
+		RegistrationMode: "select",
+		TapRSSI:          -40,
+		FirstSyncDelay:   10 * time.Second,
+		Sinks:            []sink.Config{{Type: "api", Name: "api", Options: map[string]string{}}},
+	}
+	s.Bluetooth.EnableRetries = 10
+	s.Bluetooth.ScanRetries = 5
+	s.Bluetooth.ReadyDelay = 10 * time.Second
+	s.Scanner.Backend = "tinygo"
+	s.Scanner.ReplaySpeed = 1
+	s.MQTT.TopicPrefix = mqtt.DefaultTopicPrefix
+	s.MQTT.DiscoveryPrefix = mqtt.DefaultDiscoveryPrefix
+	s.Retry.MinBackoff = sink.MinBackoff
+	s.Retry.MaxBackoff = sink.MaxBackoff
+	s.Retry.BreakerThreshold = sink.BreakerThreshold
+	s.Retry.BreakerCooldown = sink.BreakerCooldown
+	s.History.Enabled = true
+	s.History.RawRetention = 72 * time.Hour
+	s.History.MinuteRetention = 30 * 24 * time.Hour
+	s.History.HourRetention = 365 * 24 * time.Hour
+	return s
+}
+
+// setting describes one scalar setting: its key in the file, the flag
+// that overrides it and how to reach its field
+type setting struct {
+	key    string // Dotted key, e.g. "mqtt.broker"
+	flag   string // Command-line flag, empty if none
+	usage  string
+	secret bool // Masked by Write
+	value  func(*Settings) value
+}
+
+// settings lists every scalar setting in file order
+var settings = []setting{
+	{"api_url", "api-url", "URL de la API de Larvai", false, func(s *Settings) value { return (*stringValue)(&s.APIURL) }},
+	{"api_key", "", "API key (por defecto se lee de api_key_file)", true, func(s *Settings) value { return (*stringValue)(&s.APIKey) }},
+	{"api_key_file", "api-key-file", "Archivo con la API key", false, func(s *Settings) value { return (*stringValue)(&s.APIKeyFile) }},
+	{"sensors_file", "sensors-file", "Archivo de sensores autorizados", false, func(s *Settings) value { return (*stringValue)(&s.SensorsFile) }},
+	{"data_dir", "data-dir", "Directorio para datos persistentes (colas de envío)", false, func(s *Settings) value { return (*stringValue)(&s.DataDir) }},
+	{"send_interval", "send-interval", "Intervalo de agregación y envío", false, func(s *Settings) value { return (*durationValue)(&s.SendInterval) }},
+	{"online_timeout", "online-timeout", "Un sensor pasa a offline si no se ve durante este tiempo", false, func(s *Settings) value { return (*durationValue)(&s.OnlineTimeout) }},
+	{"registration_scan", "registration-scan", "Duración del escaneo de registro de sensores", false, func(s *Settings) value { return (*durationValue)(&s.RegistrationScan) }},
+	{"registration_mode", "registration-mode", "Registro de sensores: all (todos los encontrados), select (elegir de una lista) o tap (elegir solo los confirmados moviéndolos o acercándolos)", false, func(s *Settings) value { return (*stringValue)(&s.RegistrationMode) }},
+	{"registration_tap_rssi", "registration-tap-rssi", "Modo tap: RSSI (dBm) a partir del cual un sensor cuenta como acercado", false, func(s *Settings) value { return (*intValue)(&s.TapRSSI) }},
+	{"first_sync_delay", "first-sync-delay", "Espera antes de la primera sincronización", false, func(s *Settings) value { return (*durationValue)(&s.FirstSyncDelay) }},
+
+	{"bluetooth.enable_retries", "", "Intentos de activar el adaptador (backend tinygo)", false, func(s *Settings) value { return (*intValue)(&s.Bluetooth.EnableRetries) }},
+	{"bluetooth.scan_retries", "", "Intentos de iniciar el escaneo (backend tinygo)", false, func(s *Settings) value { return (*intValue)(&s.Bluetooth.ScanRetries) }},
+	{"bluetooth.ready_delay", "", "Espera tras activar el adaptador (backend tinygo)", false, func(s *Settings) value { return (*durationValue)(&s.Bluetooth.ReadyDelay) }},
+
+	{"scanner.backend", "backend", fmt.Sprintf("Backend de escaneo BLE: %s", strings.Join(scanner.Backends, ", ")), false, func(s *Settings) value { return (*stringValue)(&s.Scanner.Backend) }},
+	{"scanner.hci_device", "hci-device", "Índice del adaptador para el backend hci (0 = hci0)", false, func(s *Settings) value { return (*intValue)(&s.Scanner.HCIDevice) }},
+	{"scanner.hci_user_channel", "hci-user-channel", "Backend hci: tomar el control exclusivo del adaptador (sin bluetoothd)", false, func(s *Settings) value { return (*boolValue)(&s.Scanner.HCIUserChannel) }},
+	{"scanner.replay", "replay", "Reproducir una captura btsnoop/pcap o una grabación JSONL en lugar de escanear (implica -backend replay)", false, func(s *Settings) value { return (*stringValue)(&s.Scanner.Replay) }},
+	{"scanner.replay_speed", "replay-speed", "Velocidad de reproducción: 1 = tiempo real, 10 = diez veces más rápido, 0 = sin esperas", false, func(s *Settings) value { return (*floatValue)(&s.Scanner.ReplaySpeed) }},
+	{"scanner.ingest", "ingest", fmt.Sprintf("Backend ruuvi-json: archivo escrito por ruuvi_scanner.py (por defecto %s) o - para JSON lines por stdin", scanner.DefaultIngestFile), false, func(s *Settings) value { return (*stringValue)(&s.Scanner.Ingest) }},
+	{"scanner.record", "record", "Grabar los anuncios recibidos en un archivo JSONL para reproducirlos después", false, func(s *Settings) value { return (*stringValue)(&s.Scanner.Record) }},
+
+	{"gateway.listen", "gateway-listen", "Dirección HTTP para recibir datos de Ruuvi Gateway (ej. :8081); vacío = desactivado", false, func(s *Settings) value { return (*stringValue)(&s.Gateway.Listen) }},
+	{"gateway.token", "gateway-token", "Token Bearer que debe enviar el Ruuvi Gateway (opcional)", true, func(s *Settings) value { return (*stringValue)(&s.Gateway.Token) }},
+
+	{"mqtt.broker", "mqtt-broker", "Broker MQTT (ej. localhost:1883) para publicar lecturas; vacío = desactivado", false, func(s *Settings) value { return (*stringValue)(&s.MQTT.Broker) }},
+	{"mqtt.user", "mqtt-user", "Usuario MQTT", false, func(s *Settings) value { return (*stringValue)(&s.MQTT.User) }},
+	{"mqtt.password", "mqtt-password", "Contraseña MQTT", true, func(s *Settings) value { return (*stringValue)(&s.MQTT.Password) }},
+	{"mqtt.topic_prefix", "mqtt-topic-prefix", "Prefijo de los topics MQTT", false, func(s *Settings) value { return (*stringValue)(&s.MQTT.TopicPrefix) }},
+	{"mqtt.discovery_prefix", "mqtt-discovery-prefix", "Prefijo de descubrimiento de Home Assistant; vacío = sin descubrimiento", false, func(s *Settings) value { return (*stringValue)(&s.MQTT.DiscoveryPrefix) }},
+
+	{"retry.min_backoff", "", "Primera espera tras un envío fallido", false, func(s *Settings) value { return (*durationValue)(&s.Retry.MinBackoff) }},
+	{"retry.max_backoff", "", "Espera máxima entre reintentos", false, func(s *Settings) value { return (*durationValue)(&s.Retry.MaxBackoff) }},
+	{"retry.breaker_threshold", "", "Fallos seguidos que pausan los envíos a un destino", false, func(s *Settings) value { return (*intValue)(&s.Retry.BreakerThreshold) }},
+	{"retry.breaker_cooldown", "", "Duración de la pausa antes de volver a probar", false, func(s *Settings) value { return (*durationValue)(&s.Retry.BreakerCooldown) }},
+
+	{"history.enabled", "history", "Guardar el histórico local de lecturas en data_dir", false, func(s *Settings) value { return (*boolValue)(&s.History.Enabled) }},
+	{"history.raw_retention", "", "Tiempo que se guardan las lecturas sin agregar (0 = siempre)", false, func(s *Settings) value { return (*durationValue)(&s.History.RawRetention) }},
+	{"history.minute_retention", "", "Tiempo que se guardan los agregados por minuto (0 = siempre)", false, func(s *Settings) value { return (*durationValue)(&s.History.MinuteRetention) }},
+	{"history.hour_retention", "", "Tiempo que se guardan los agregados por hora (0 = siempre)", false, func(s *Settings) value { return (*durationValue)(&s.History.HourRetention) }},
+
+	{"http.listen", "http-listen", "Dirección del servidor HTTP local con las métricas de Prometheus, la API local y los eventos en vivo (ej. :9100); vacío = desactivado", false, func(s *Settings) value { return (*stringValue)(&s.HTTP.Listen) }},
+	{"http.token", "http-token", "Token Bearer para añadir y retirar sensores con la API local; vacío = solo lectura", true, func(s *Settings) value { return (*stringValue)(&s.HTTP.Token) }},
+}
+
+// lookup returns the setting with the given key
+func lookup(key string) (setting, bool) {
+	for _, st := range settings {
+		if st.key == key {
+			return st, true
+		}
+	}
+	return setting{}, false
+}
+
+// envName returns the environment variable of a setting key
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Load returns the defaults overridden by the file at path and then by
+// the environment (as returned by os.Environ). A missing file is an error
+// only if required.
+func Load(path string, required bool, environ []string) (*Settings, error) {
+	s := Default()
+
+	if path != "" {
+		f, err := os.Open(path)
+		switch {
+		case err == nil:
+			err = s.readFile(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		case !os.IsNotExist(err) || required:
+			return nil, err
+		}
+	}
+
+	if err := s.applyEnv(environ); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// readFile applies the settings of a configuration file
+func (s *Settings) readFile(r io.Reader) error {
+	doc, err := parseTOML(r)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range doc.entries {
+		st, ok := lookup(e.key)
+		if !ok {
+			return fmt.Errorf("line %d: unknown setting %q", e.line, e.key)
+		}
+		if err := st.value(s).Set(e.value); err != nil {
+			return fmt.Errorf("line %d: %s: %w", e.line, e.key, err)
+		}
+	}
+
+	if doc.sinks != nil {
+		s.Sinks = nil
+		for _, entries := range doc.sinks {
+			cfg, err := sinkFromEntries(entries)
+			if err != nil {
+				return err
+			}
+			s.Sinks = append(s.Sinks, cfg)
+		}
+	}
+	return nil
+}
+
+// sinkFromEntries builds the sink of a [[sink]] table
+func sinkFromEntries(entries []entry) (sink.Config, error) {
+	spec := make([]string, 0, len(entries))
+	var sinkType string
+	for _, e := range entries {
+		if strings.Contains(e.value, ",") {
+			return sink.Config{}, fmt.Errorf("line %d: %s: commas are not allowed in sink settings", e.line, e.key)
+		}
+		if e.key == "type" {
+			sinkType = e.value
+			continue
+		}
+		spec = append(spec, e.key+"="+e.value)
+	}
+	if sinkType == "" {
+		return sink.Config{}, errors.New("[[sink]] without type")
+	}
+
+	return sink.ParseSpec(strings.Join(append([]string{sinkType}, spec...), ","))
+}
+
+// applyEnv applies the SENSORGO_* variables. SENSORGO_SINKS holds sinks
+// as in -sink, separated by semicolons; an unknown variable is an error so
+// that typos do not go unnoticed.
+func (s *Settings) applyEnv(environ []string) error {
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+
+		if name == EnvPrefix+"SINKS" {
+			sinks, err := parseSinkList(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			s.Sinks = sinks
+			continue
+		}
+
+		if name == EnvConfig {
+			continue
+		}
+
+		known := false
+		for _, st := range settings {
+			if envName(st.key) == name {
+				known = true
+				if err := st.value(s).Set(value); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		}
+		if !known {
+			return fmt.Errorf("%s: unknown setting", name)
+		}
+	}
+	return nil
+}
+
+func parseSinkList(list string) ([]sink.Config, error) {
+	var sinks []sink.Config
+	for _, spec := range strings.Split(list, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		cfg, err := sink.ParseSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, cfg)
+	}
+	return sinks, nil
+}
+
+// Validate checks the settings, reporting every problem found
+func (s *Settings) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	u, err := url.Parse(s.APIURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"api_url: %q is not an http(s) URL", s.APIURL)
+	check(s.SensorsFile != "", "sensors_file: must not be empty")
+	check(s.DataDir != "", "data_dir: must not be empty")
+	check(s.SendInterval >= 10*time.Second, "send_interval: %v is too short (minimum 10s)", s.SendInterval)
+	check(s.OnlineTimeout > 0, "online_timeout: must be positive")
+	check(s.RegistrationScan > 0, "registration_scan: must be positive")
+	mode := false
+	for _, m := range RegistrationModes {
+		mode = mode || m == s.RegistrationMode
+	}
+	check(mode, "registration_mode: unknown mode %q (available: %s)", s.RegistrationMode, strings.Join(RegistrationModes, ", "))
+	check(s.TapRSSI >= -100 && s.TapRSSI <= 0, "registration_tap_rssi: %d dBm is out of range (-100 to 0)", s.TapRSSI)
+	check(s.FirstSyncDelay >= 0, "first_sync_delay: must not be negative")
+
+	check(s.Bluetooth.EnableRetries >= 1, "bluetooth.enable_retries: must be at least 1")
+	check(s.Bluetooth.ScanRetries >= 1, "bluetooth.scan_retries: must be at least 1")
+	check(s.Bluetooth.ReadyDelay >= 0, "bluetooth.ready_delay: must not be negative")
+
+	known := false
+	for _, b := range scanner.Backends {
+		known = known || b == s.Scanner.Backend
+	}
+	check(known, "scanner.backend: unknown backend %q (available: %s)", s.Scanner.Backend, strings.Join(scanner.Backends, ", "))
+	check(s.Scanner.Backend != "replay" || s.Scanner.Replay != "", "scanner.replay: the replay backend needs a file")
+	check(s.Scanner.HCIDevice >= 0, "scanner.hci_device: must not be negative")
+	check(s.Scanner.ReplaySpeed >= 0, "scanner.replay_speed: must not be negative")
+
+	check(s.MQTT.Broker == "" || s.MQTT.TopicPrefix != "", "mqtt.topic_prefix: must not be empty")
+
+	check(s.Retry.MinBackoff > 0, "retry.min_backoff: must be positive")
+	check(s.Retry.MaxBackoff >= s.Retry.MinBackoff, "retry.max_backoff: must not be less than retry.min_backoff")
+	check(s.Retry.BreakerThreshold >= 1, "retry.breaker_threshold: must be at least 1")
+	check(s.Retry.BreakerCooldown >= 0, "retry.breaker_cooldown: must not be negative")
+
+	check(s.History.RawRetention >= 0, "history.raw_retention: must not be negative")
+	check(s.History.MinuteRetention >= 0, "history.minute_retention: must not be negative")
+	check(s.History.HourRetention >= 0, "history.hour_retention: must not be negative")
+
+	check(len(s.Sinks) > 0, "sink: at least one sink is required")
+	names := make(map[string]bool)
+	for _, cfg := range s.Sinks {
+		check(!names[cfg.Name], "sink %s: duplicate name", cfg.Name)
+		names[cfg.Name] = true
+		if _, _, err := sink.New(cfg, sink.Env{APIURL: s.APIURL}); err != nil {
+			errs = append(errs, err)
+		}
+		// Replayed readings are stamped as just received: they must not
+		// reach the production API as current data
+		if s.Scanner.Backend == "replay" && cfg.Type == "api" {
+			apiURL := cfg.Options["url"]
+			if apiURL == "" {
+				apiURL = s.APIURL
+			}
+			check(!isDefaultAPI(apiURL), "sink %s: replayed readings would be posted to the production API; use -sink stdout, csv or influx, or an api sink with another url", cfg.Name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// isDefaultAPI reports whether raw points to DefaultAPIURL, whatever its
+// scheme, port, query, host case or trailing slash
+func isDefaultAPI(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		// Reported by sink.New
+		return false
+	}
+	def, _ := url.Parse(DefaultAPIURL)
+	return strings.EqualFold(u.Hostname(), def.Hostname()) &&
+		strings.TrimRight(u.Path, "/") == strings.TrimRight(def.Path, "/")
+}
+
+// HistoryDir returns the directory of the local history store
+func (s *Settings) HistoryDir() string {
+	return filepath.Join(s.DataDir, "history")
+}
+
+// KeyFile returns APIKeyFile with a leading ~ expanded
+func (s *Settings) KeyFile() (string, error) {
+	if s.APIKeyFile != "~" && !strings.HasPrefix(s.APIKeyFile, "~/") {
+		return s.APIKeyFile, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return home + strings.TrimPrefix(s.APIKeyFile, "~"), nil
+}
@@ -0,0 +1,207 @@
+package settings
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sensorsgo/sink"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFile = `
+# Granja norte
+send_interval = "1m"   # agregación de un minuto
+data_dir = '/var/lib/sensorgo'
+
+[scanner]
+backend = "hci"
+hci_device = 1
+
+[mqtt]
+broker = "localhost:1883"
+
+[[sink]]
+type = "api"
+mode = "batch"
+
+[[sink]]
+type = "csv"
+name = "diario"
+dir = "/srv/csv"
+batch = 50
+`
+
+// TestLoadPrecedence tests that the file overrides the defaults, the
+// environment the file, and flags the environment
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sensorgo.toml")
+	if err := os.WriteFile(path, []byte(testFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cmdline := Bind(fs)
+	if err := fs.Parse([]string{"-hci-device", "2", "-hci-user-channel"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	s, err := Load(path, true, []string{"SENSORGO_MQTT_BROKER=broker:1883", "SENSORGO_SCANNER_HCI_DEVICE=3", "HOME=/root"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := cmdline.Apply(s); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	if s.SendInterval != time.Minute || s.DataDir != "/var/lib/sensorgo" || s.OnlineTimeout != 2*time.Minute {
+		t.Errorf("file settings = %v, %q, %v", s.SendInterval, s.DataDir, s.OnlineTimeout)
+	}
+	if s.MQTT.Broker != "broker:1883" {
+		t.Errorf("mqtt.broker = %q, want the environment value", s.MQTT.Broker)
+	}
+	if s.Scanner.Backend != "hci" || s.Scanner.HCIDevice != 2 || !s.Scanner.HCIUserChannel {
+		t.Errorf("scanner = %+v, want the flag values", s.Scanner)
+	}
+	if len(s.Sinks) != 2 || s.Sinks[0].Options["mode"] != "batch" || s.Sinks[1].Name != "diario" || s.Sinks[1].Batch != 50 {
+		t.Errorf("sinks = %+v", s.Sinks)
+	}
+}
+
+// TestLoadErrors tests the messages for mistakes in the file
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"send_intervall = \"1m\"", `line 1: unknown setting "send_intervall"`},
+		{"[mqtt]\nbroker = localhost", "line 2: broker: invalid value localhost (strings must be quoted)"},
+		{"send_interval = \"5 minutes\"", `line 1: send_interval: invalid duration "5 minutes"`},
+		{"[[sinks]]", "line 1: unknown array of tables [[sinks]]"},
+		{"[[sink]]\nname = \"x\"", "[[sink]] without type"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "sensorgo.toml")
+		os.WriteFile(path, []byte(tt.file), 0644)
+		_, err := Load(path, true, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Load(%q) = %v, want %q", tt.file, err, tt.want)
+		}
+	}
+
+	if _, err := Load("", false, []string{"SENSORGO_HCI_DEVICE=1"}); err == nil || !strings.Contains(err.Error(), "SENSORGO_HCI_DEVICE: unknown setting") {
+		t.Errorf("unknown variable: %v", err)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.toml"), false, nil); err != nil {
+		t.Errorf("optional missing file: %v", err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.toml"), true, nil); err == nil {
+		t.Error("required missing file: expected error")
+	}
+}
+
+// TestValidate tests that every problem is reported
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+
+	s := Default()
+	s.SendInterval = time.Second
+	s.Scanner.Backend = "bluez"
+	s.Retry.MaxBackoff = time.Millisecond
+	err := s.Validate()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{"send_interval", "scanner.backend", "retry.max_backoff"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %s in %v", want, err)
+		}
+	}
+
+	// Replaying needs a sink other than the production API
+	s = Default()
+	s.Scanner.Backend = "replay"
+	s.Scanner.Replay = "session.jsonl"
+	if err := s.Validate(); err == nil || !strings.Contains(err.Error(), "production API") {
+		t.Errorf("replay to the production API: %v", err)
+	}
+	for _, variant := range []string{
+		"https://go.larvai.com/api/v1/sensors/",
+		"https://GO.Larvai.com/api/v1/sensors",
+		"https://go.larvai.com/api/v1/sensors?source=replay",
+		"http://go.larvai.com/api/v1/sensors",
+		"https://go.larvai.com:443/api/v1/sensors",
+	} {
+		s.Sinks = []sink.Config{{Type: "api", Name: "api", Options: map[string]string{"url": variant}}}
+		if err := s.Validate(); err == nil || !strings.Contains(err.Error(), "production API") {
+			t.Errorf("replay to %s: %v", variant, err)
+		}
+	}
+	s.Sinks = []sink.Config{{Type: "api", Name: "api", Options: map[string]string{"url": "http://localhost:8080/sensors"}}, {Type: "stdout", Name: "stdout"}}
+	if err := s.Validate(); err != nil {
+		t.Errorf("replay to a local API: %v", err)
+	}
+}
+
+// TestWriteRoundTrip tests that the printed configuration loads back to
+// the same settings, with secrets masked
+func TestWriteRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sensorgo.toml")
+	os.WriteFile(path, []byte(testFile), 0644)
+	s, err := Load(path, true, []string{"SENSORGO_MQTT_PASSWORD=secreto"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if strings.Contains(buf.String(), "secreto") || !strings.Contains(buf.String(), `password = "********"`) {
+		t.Errorf("password not masked:\n%s", buf.String())
+	}
+
+	printed := filepath.Join(t.TempDir(), "printed.toml")
+	os.WriteFile(printed, buf.Bytes(), 0644)
+	again, err := Load(printed, true, nil)
+	if err != nil {
+		t.Fatalf("Load(printed): %v\n%s", err, buf.String())
+	}
+	again.MQTT.Password = s.MQTT.Password
+
+	var first, second bytes.Buffer
+	s.Write(&first)
+	again.Write(&second)
+	if first.String() != second.String() {
+		t.Errorf("round trip differs:\n%s\n---\n%s", first.String(), second.String())
+	}
+}
+
+// TestDiff tests that Diff reports changed settings and sinks in file
+// order with secrets masked
+func TestDiff(t *testing.T) {
+	old := Default()
+	new := Default()
+	new.SendInterval = 2 * time.Minute
+	new.MQTT.Password = "secreto"
+	new.Sinks = append(new.Sinks, sink.Config{Type: "influx", Options: map[string]string{"url": "http://db", "token": "t"}})
+
+	got := fmt.Sprint(Diff(old, new))
+	want := "[{send_interval 5m0s 2m0s} {mqtt.password  ********} {sink api api; influx,token=********,url=http://db}]"
+	if got != want {
+		t.Errorf("Diff = %s, want %s", got, want)
+	}
+	if changes := Diff(old, Default()); len(changes) != 0 {
+		t.Errorf("Diff of equal settings = %v", changes)
+	}
+}
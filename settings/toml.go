@@ -0,0 +1,213 @@
+package settings
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// entry is one key = value line of a configuration file. Keys are dotted
+// with their table ("mqtt.broker"); value is the decoded scalar.
+type entry struct {
+	key   string
+	value string
+	line  int
+}
+
+// document is a parsed configuration file
+type document struct {
+	entries []entry
+	sinks   [][]entry // One list per [[sink]] table, keys without the prefix
+}
+
+// parseTOML parses the subset of TOML used by the configuration file:
+// comments, [table] and [[sink]] headers, and key = value pairs whose
+// value is a string, integer, float or boolean
+func parseTOML(r io.Reader) (*document, error) {
+	doc := &document{}
+	table := ""
+	inSink := false
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(text, "[["):
+			if !strings.HasSuffix(text, "]]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", line)
+			}
+			name := strings.TrimSpace(text[2 : len(text)-2])
+			if name != "sink" {
+				return nil, fmt.Errorf("line %d: unknown array of tables [[%s]] (only [[sink]] is supported)", line, name)
+			}
+			doc.sinks = append(doc.sinks, nil)
+			inSink = true
+			table = name
+			continue
+		case strings.HasPrefix(text, "["):
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", line)
+			}
+			table = strings.TrimSpace(text[1 : len(text)-1])
+			if table == "" || strings.ContainsAny(table, " \t.") {
+				return nil, fmt.Errorf("line %d: invalid table name [%s]", line, table)
+			}
+			inSink = false
+			continue
+		}
+
+		key, raw, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		key = strings.TrimSpace(key)
+		if !validKey(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", line, key)
+		}
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", line, key, err)
+		}
+
+		if inSink {
+			doc.sinks[len(doc.sinks)-1] = append(doc.sinks[len(doc.sinks)-1], entry{key: key, value: value, line: line})
+			continue
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		doc.entries = append(doc.entries, entry{key: key, value: value, line: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// stripComment removes a # comment that is not inside a string
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++ // Skip the escaped character
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue decodes a scalar value: "basic" and 'literal' strings are
+// unquoted, numbers and booleans are returned as written
+func parseValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", errors.New("missing value")
+	case strings.HasPrefix(raw, `"`):
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return value, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") || strings.Contains(raw[1:len(raw)-1], "'") {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "["), strings.HasPrefix(raw, "{"):
+		return "", errors.New("arrays and inline tables are not supported")
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+
+	number := strings.ReplaceAll(raw, "_", "")
+	if _, err := strconv.ParseFloat(number, 64); err != nil {
+		return "", fmt.Errorf("invalid value %s (strings must be quoted)", raw)
+	}
+	return number, nil
+}
+
+// quoteTOML formats a string as a TOML basic string
+func quoteTOML(s string) string {
+	return strconv.Quote(s)
+}
+
+// masked replaces secrets in Write output
+const masked = "********"
+
+// Write prints s as a configuration file, with secrets masked
+func (s *Settings) Write(w io.Writer) error {
+	b := &strings.Builder{}
+	table := ""
+	for _, st := range settings {
+		section, key, ok := strings.Cut(st.key, ".")
+		if !ok {
+			section, key = "", st.key
+		}
+		if section != table {
+			fmt.Fprintf(b, "\n[%s]\n", section)
+			table = section
+		}
+
+		v := st.value(s)
+		text := v.String()
+		switch v.(type) {
+		case *stringValue, *durationValue:
+			if st.secret && text != "" {
+				text = masked
+			}
+			text = quoteTOML(text)
+		}
+		fmt.Fprintf(b, "%s = %s\n", key, text)
+	}
+
+	for _, cfg := range s.Sinks {
+		fmt.Fprintf(b, "\n[[sink]]\ntype = %s\nname = %s\n", quoteTOML(cfg.Type), quoteTOML(cfg.Name))
+		if cfg.Batch > 0 {
+			fmt.Fprintf(b, "batch = %d\n", cfg.Batch)
+		}
+		keys := make([]string, 0, len(cfg.Options))
+		for key := range cfg.Options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := cfg.Options[key]
+			if secretOption(key) {
+				value = masked
+			}
+			fmt.Fprintf(b, "%s = %s\n", key, quoteTOML(value))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,257 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// batchRetryInterval is how long the API sink posts readings one by one
+// after finding that the server has no batch endpoint, before trying the
+// batch endpoint again
+const batchRetryInterval = time.Hour
+
+// APISink posts readings to the Larvai API, either each one to
+// <url>/<mac> or, with a batch URL, all of them in one gzip-compressed
+// request. The HTTP client is shared so that connections are reused.
+type APISink struct {
+	name     string
+	url      string
+	key      string
+	batchURL string // Empty to post each reading on its own
+	client   *http.Client
+
+	// Log receives the delivery messages, os.Stdout if nil
+	Log io.Writer
+
+	mu          sync.Mutex
+	singleUntil time.Time // Batch endpoint unsupported, post one by one until then
+}
+
+// NewAPISink creates a sink for the Larvai API
+func NewAPISink(name, url, key string) *APISink {
+	return &APISink{
+		name:   name,
+		url:    url,
+		key:    key,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewAPIBatchSink creates a sink for the Larvai API that sends every batch
+// to batchURL in a single request, falling back to one request per
+// reading if the server does not support it
+func NewAPIBatchSink(name, url, key, batchURL string) *APISink {
+	s := NewAPISink(name, url, key)
+	s.batchURL = batchURL
+	s.client.Timeout = 60 * time.Second
+	return s
+}
+
+// printf writes a delivery message to Log
+func (s *APISink) printf(format string, args ...interface{}) {
+	w := s.Log
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// Name returns the sink name
+func (s *APISink) Name() string { return s.name }
+
+// Write posts the readings, in one request if the sink has a batch URL
+// the server supports, otherwise one by one stopping at the first failure
+func (s *APISink) Write(ctx context.Context, readings []Reading) (int, error) {
+	if s.useBatch() {
+		n, err := s.sendBatch(ctx, readings)
+		if err != errBatchUnsupported {
+			return n, err
+		}
+		s.printf("⚠️  %s: el servidor no admite envío por lotes, enviando lectura a lectura\n", s.name)
+		s.mu.Lock()
+		s.singleUntil = time.Now().Add(batchRetryInterval)
+		s.mu.Unlock()
+	}
+
+	for i, reading := range readings {
+		if err := s.Send(ctx, reading.MAC, reading.Payload); err != nil {
+			return i, err
+		}
+	}
+	return len(readings), nil
+}
+
+// Send posts one reading. A rejected key is a misconfiguration and 400,
+// 413 and 422 responses are permanent errors.
+func (s *APISink) Send(ctx context.Context, mac string, payload Payload) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return Permanent(fmt.Errorf("error serializando datos: %w", err))
+	}
+
+	url := fmt.Sprintf("%s/%s", s.url, mac)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return Permanent(fmt.Errorf("error creando request HTTP: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.key))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error de conexión: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.printf("⚠️  Error leyendo response body: %v\n", err)
+	}
+	bodyString := string(bodyBytes)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		s.printf("✅ Datos enviados para sensor %s\n", mac)
+		// Mostrar response si hay contenido
+		if len(bodyString) > 0 && bodyString != "{}" {
+			s.printf("   Response: %s\n", bodyString)
+		}
+		return nil
+	}
+
+	// Error HTTP - mostrar detalles completos
+	s.printf("\n❌ Error HTTP %d al enviar datos para %s\n", resp.StatusCode, mac)
+	s.printf("   URL: %s\n", url)
+	s.printf("   Payload enviado: %s\n", string(jsonData))
+	s.printf("   Response body: %s\n", bodyString)
+
+	// Solo 400, 413 y 422 rechazan la lectura; con 401/403 hay que
+	// revisar la API key
+	return httpError(resp, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errorMessage(bodyBytes)))
+}
+
+// errorMessage extracts a short message from an error response: the
+// "message" field of a JSON body, or the body itself truncated
+func errorMessage(body []byte) string {
+	var errorResponse map[string]interface{}
+	if err := json.Unmarshal(body, &errorResponse); err == nil {
+		if msg, ok := errorResponse["message"].(string); ok {
+			return msg
+		}
+		return "ver consola para detalles"
+	}
+
+	truncated := string(body)
+	if len(truncated) > 100 {
+		truncated = truncated[:100] + "..."
+	}
+	return truncated
+}
+
+// errBatchUnsupported means the server answered the batch request with a
+// status showing it has no such endpoint
+var errBatchUnsupported = errors.New("batch endpoint not supported")
+
+// batchRequest is the body of a batch upload
+type batchRequest struct {
+	Readings []batchItem `json:"readings"`
+}
+
+type batchItem struct {
+	MAC     string  `json:"mac"`
+	Payload Payload `json:"payload"`
+}
+
+// batchResponse reports the outcome of each reading, in request order
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+type batchResult struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (s *APISink) useBatch() bool {
+	if s.batchURL == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().After(s.singleUntil)
+}
+
+// sendBatch posts the readings in one gzip-compressed request. Readings
+// the server rejects permanently are logged and dropped; the count stops
+// at the first reading that should be retried.
+func (s *APISink) sendBatch(ctx context.Context, readings []Reading) (int, error) {
+	request := batchRequest{Readings: make([]batchItem, len(readings))}
+	for i, reading := range readings {
+		request.Readings[i] = batchItem{MAC: reading.MAC, Payload: reading.Payload}
+	}
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(request); err != nil {
+		return 0, Permanent(fmt.Errorf("error serializando lote: %w", err))
+	}
+	if err := gz.Close(); err != nil {
+		return 0, Permanent(fmt.Errorf("error comprimiendo lote: %w", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.batchURL, &body)
+	if err != nil {
+		return 0, Permanent(fmt.Errorf("error creando request HTTP: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.key))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error de conexión: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented, http.StatusUnsupportedMediaType:
+		return 0, errBatchUnsupported
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, httpError(resp, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errorMessage(bodyBytes)))
+	}
+
+	// Without per-item results the whole batch was accepted
+	var response batchResponse
+	if err := json.Unmarshal(bodyBytes, &response); err != nil || len(response.Results) != len(readings) {
+		s.printf("✅ %d lectura(s) enviadas en un lote\n", len(readings))
+		return len(readings), nil
+	}
+
+	for i, result := range response.Results {
+		switch {
+		case result.Status >= 200 && result.Status < 300:
+		case isPermanentStatus(result.Status):
+			s.printf("🗑️  Lectura de %s rechazada (HTTP %d): %s\n", readings[i].MAC, result.Status, result.Error)
+		default:
+			// Later readings are sent again with this one; the API
+			// deduplicates by sensor and measured_at
+			if i > 0 {
+				s.printf("✅ %d lectura(s) enviadas en un lote\n", i)
+			}
+			return i, fmt.Errorf("lectura de %s: HTTP %d: %s", readings[i].MAC, result.Status, result.Error)
+		}
+	}
+	s.printf("✅ %d lectura(s) enviadas en un lote\n", len(readings))
+	return len(readings), nil
+}
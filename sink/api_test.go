@@ -0,0 +1,138 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestAPIBatch tests the gzip batch request and per-item results
+func TestAPIBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sensors/batch" || r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("request %s, Content-Encoding %q", r.URL.Path, r.Header.Get("Content-Encoding"))
+		}
+		if r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip: %v", err)
+			return
+		}
+		var request batchRequest
+		if err := json.NewDecoder(gz).Decode(&request); err != nil {
+			t.Errorf("decoding batch: %v", err)
+			return
+		}
+		if len(request.Readings) != 4 || request.Readings[1].MAC != "B" {
+			t.Errorf("readings = %+v", request.Readings)
+		}
+
+		// A accepted, B rejected, C failed temporarily, D accepted
+		w.WriteHeader(http.StatusMultiStatus)
+		json.NewEncoder(w).Encode(batchResponse{Results: []batchResult{
+			{Status: 201}, {Status: 422, Error: "invalid"}, {Status: 503}, {Status: 201},
+		}})
+	}))
+	defer server.Close()
+
+	s := NewAPIBatchSink("api", server.URL+"/sensors", "key", server.URL+"/sensors/batch")
+	n, err := s.Write(context.Background(), []Reading{{MAC: "A"}, {MAC: "B"}, {MAC: "C"}, {MAC: "D"}})
+	if n != 2 || err == nil || IsPermanent(err) {
+		t.Errorf("Write = %d, %v; want 2 and a temporary error", n, err)
+	}
+}
+
+// TestAPIBatchFallback tests that a server without the batch endpoint
+// gets one request per reading
+func TestAPIBatchFallback(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/batch") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	s := NewAPIBatchSink("api", server.URL+"/sensors", "key", server.URL+"/sensors/batch")
+	for i := 0; i < 2; i++ {
+		if n, err := s.Write(context.Background(), []Reading{{MAC: "A"}, {MAC: "B"}}); n != 2 || err != nil {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+
+	// The batch endpoint is only tried once
+	want := "[/sensors/batch /sensors/A /sensors/B /sensors/A /sensors/B]"
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(paths, " "); "["+got+"]" != want {
+		t.Errorf("requests = [%s], want %s", got, want)
+	}
+}
+
+// TestAPIErrorClass tests that only errors tied to the reading discard
+// it, and that a rejected key pauses the sink
+func TestAPIErrorClass(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The status to answer is the last path element
+		status, _ := strconv.Atoi(path.Base(r.URL.Path))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	s := NewAPISink("api", server.URL, "expired")
+	tests := []struct {
+		status                   string
+		permanent, misconfigured bool
+	}{
+		{"400", true, false},
+		{"413", true, false},
+		{"422", true, false},
+		{"401", false, true},
+		{"403", false, true},
+		{"404", false, false},
+		{"409", false, false},
+		{"503", false, false},
+	}
+	for _, tt := range tests {
+		err := s.Send(context.Background(), tt.status, Payload{})
+		if err == nil || IsPermanent(err) != tt.permanent || IsMisconfigured(err) != tt.misconfigured {
+			t.Errorf("HTTP %s: Send = %v, want permanent %v, misconfigured %v", tt.status, err, tt.permanent, tt.misconfigured)
+		}
+	}
+}
+
+// TestAPILog tests that the messages of an api sink go to Env.Console and
+// never to the stdout sink's stream
+func TestAPILog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var console, stdout bytes.Buffer
+	env := Env{APIKey: "key", Stdout: &stdout, Console: &console}
+	s, _, err := New(Config{Type: "api", Name: "api", Options: map[string]string{"url": server.URL}}, env)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := s.Write(context.Background(), []Reading{{MAC: "A"}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !strings.Contains(console.String(), "Datos enviados para sensor A") || stdout.Len() != 0 {
+		t.Errorf("console %q, stdout %q", console.String(), stdout.String())
+	}
+}
@@ -0,0 +1,94 @@
+package sink
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sensorsgo/sensor"
+	"strconv"
+)
+
+// CSVSink appends readings to one CSV file per day, named after the local
+// date of the measurement: <dir>/sensorgo-2006-01-02.csv
+type CSVSink struct {
+	name string
+	dir  string
+}
+
+// NewCSVSink creates a CSV sink writing to dir
+func NewCSVSink(name, dir string) *CSVSink {
+	return &CSVSink{name: name, dir: dir}
+}
+
+// Name returns the sink name
+func (s *CSVSink) Name() string { return s.name }
+
+// csvHeader returns the columns of every file
+func csvHeader() []string {
+	header := []string{"measured_at", "mac", "name", "model"}
+	for _, f := range sensor.Fields {
+		header = append(header, f.Key)
+	}
+	return header
+}
+
+// Write appends the readings, grouped into their day's file
+func (s *CSVSink) Write(ctx context.Context, readings []Reading) (int, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for written < len(readings) {
+		// Consecutive readings of the same day go to the same file
+		day := readings[written].Payload.Time().Local().Format("2006-01-02")
+		end := written + 1
+		for end < len(readings) && readings[end].Payload.Time().Local().Format("2006-01-02") == day {
+			end++
+		}
+
+		if err := s.append(filepath.Join(s.dir, fmt.Sprintf("sensorgo-%s.csv", day)), readings[written:end]); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// append writes rows to path, starting the file with the header
+func (s *CSVSink) append(path string, readings []Reading) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		w.Write(csvHeader())
+	}
+	for _, reading := range readings {
+		values := reading.Payload.Values()
+		row := []string{reading.Payload.MeasuredAt, reading.MAC, reading.Name, reading.Payload.Model}
+		for _, field := range sensor.Fields {
+			if v, ok := values[field.Key]; ok {
+				row = append(row, strconv.FormatFloat(v, 'f', -1, 64))
+			} else {
+				row = append(row, "")
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,56 @@
+package sink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sensorsgo/sensor"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCSVSink tests daily rotation and that the header is written once
+func TestCSVSink(t *testing.T) {
+	dir := t.TempDir()
+	s := NewCSVSink("csv", dir)
+
+	reading := func(at time.Time, temperature float64) Reading {
+		data := &sensor.RuuviData{Model: "ruuvi", Temperature: &temperature, Timestamp: at}
+		return Reading{MAC: "AA:BB:CC:DD:EE:FF", Name: "Sala 1", Payload: NewPayload(data, "pi")}
+	}
+	day1 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+
+	for _, batch := range [][]Reading{
+		{reading(day1, 20), reading(day1.Add(time.Minute), 20.5)},
+		{reading(day1.Add(2*time.Minute), 21), reading(day2, 22)},
+	} {
+		if n, err := s.Write(context.Background(), batch); err != nil || n != len(batch) {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "sensorgo-2026-03-01.csv"))
+	if err != nil {
+		t.Fatalf("day 1 file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("day 1 has %d lines, want header + 3:\n%s", len(lines), content)
+	}
+	if !strings.HasPrefix(lines[0], "measured_at,mac,name,model,temperature,") {
+		t.Errorf("header = %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[2], day1.Add(time.Minute).UTC().Format(time.RFC3339)+",AA:BB:CC:DD:EE:FF,Sala 1,ruuvi,20.5,") {
+		t.Errorf("row = %q", lines[2])
+	}
+
+	content, err = os.ReadFile(filepath.Join(dir, "sensorgo-2026-03-02.csv"))
+	if err != nil {
+		t.Fatalf("day 2 file: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(content)), "\n"); len(lines) != 2 {
+		t.Errorf("day 2 has %d lines, want 2", len(lines))
+	}
+}
@@ -0,0 +1,158 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sensorsgo/sensor"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxSink writes readings to InfluxDB in line protocol over HTTP. The
+// URL is the write endpoint, e.g. http://localhost:8086/write?db=farm
+// (1.x) or http://localhost:8086/api/v2/write?org=o&bucket=b (2.x).
+type InfluxSink struct {
+	name        string
+	url         string
+	token       string
+	measurement string
+	hostname    string
+	client      *http.Client
+}
+
+// NewInfluxSink creates an InfluxDB sink; timestamps are sent in seconds
+func NewInfluxSink(name, writeURL, token, measurement, hostname string) (*InfluxSink, error) {
+	if writeURL == "" {
+		return nil, errors.New("url is required")
+	}
+	u, err := url.Parse(writeURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	query := u.Query()
+	query.Set("precision", "s")
+	u.RawQuery = query.Encode()
+
+	return &InfluxSink{
+		name:        name,
+		url:         u.String(),
+		token:       token,
+		measurement: measurement,
+		hostname:    hostname,
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Name returns the sink name
+func (s *InfluxSink) Name() string { return s.name }
+
+// Write sends the whole batch in one request
+func (s *InfluxSink) Write(ctx context.Context, readings []Reading) (int, error) {
+	var body bytes.Buffer
+	for _, reading := range readings {
+		body.WriteString(s.line(reading))
+	}
+	if body.Len() == 0 {
+		return len(readings), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return 0, Permanent(err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return len(readings), nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	if resp.StatusCode == http.StatusNotFound {
+		// The database or bucket does not exist
+		return 0, Misconfigured(err)
+	}
+	return 0, httpError(resp, err)
+}
+
+// line formats a reading as one line protocol point, or "" if it has no
+// numeric fields. All fields are floats so that their type never changes.
+func (s *InfluxSink) line(reading Reading) string {
+	values := reading.Payload.Values()
+	if len(values) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(escapeInflux(s.measurement, ", "))
+	b.WriteString(",mac=" + escapeInflux(reading.MAC, ",= "))
+	if reading.Name != "" {
+		b.WriteString(",name=" + escapeInflux(reading.Name, ",= "))
+	}
+	if reading.Payload.Model != "" {
+		b.WriteString(",model=" + escapeInflux(reading.Payload.Model, ",= "))
+	}
+	host := reading.Payload.Hostname
+	if host == "" {
+		host = s.hostname
+	}
+	if host != "" {
+		b.WriteString(",host=" + escapeInflux(host, ",= "))
+	}
+
+	for i, key := range sortedKeys(values) {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(key + "=" + formatInfluxFloat(values[key]))
+	}
+
+	// Interval statistics as <field>_min, <field>_max, ...
+	for _, field := range sensor.Fields {
+		stats, ok := reading.Payload.Stats[field.Key]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, ",%[1]s_min=%[2]s,%[1]s_max=%[3]s,%[1]s_mean=%[4]s,%[1]s_stddev=%[5]s,%[1]s_count=%[6]d",
+			field.Key, formatInfluxFloat(stats.Min), formatInfluxFloat(stats.Max),
+			formatInfluxFloat(stats.Mean), formatInfluxFloat(stats.StdDev), stats.Count)
+	}
+	if reading.Payload.Samples > 0 {
+		fmt.Fprintf(&b, ",samples=%d", reading.Payload.Samples)
+	}
+
+	fmt.Fprintf(&b, " %d\n", reading.Payload.Time().Unix())
+	return b.String()
+}
+
+func formatInfluxFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// escapeInflux backslash-escapes the given special characters
+func escapeInflux(s, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sensorsgo/sensor"
+	"testing"
+	"time"
+)
+
+// TestInfluxLine tests the line protocol formatting and escaping
+func TestInfluxLine(t *testing.T) {
+	s, err := NewInfluxSink("influx", "http://localhost:8086/write?db=farm", "", "sensor", "pi")
+	if err != nil {
+		t.Fatalf("NewInfluxSink: %v", err)
+	}
+	if s.url != "http://localhost:8086/write?db=farm&precision=s" {
+		t.Errorf("url = %q", s.url)
+	}
+
+	temperature, humidity := 21.5, 40.0
+	battery := uint16(2900)
+	data := &sensor.RuuviData{
+		Model:       "ruuvi",
+		Temperature: &temperature,
+		Humidity:    &humidity,
+		Battery:     &battery,
+		Timestamp:   time.Unix(1767225600, 0),
+	}
+	reading := Reading{MAC: "AA:BB:CC:DD:EE:FF", Name: "Sala 1, norte", Payload: NewPayload(data, "")}
+
+	want := "sensor,mac=AA:BB:CC:DD:EE:FF,name=Sala\\ 1\\,\\ norte,model=ruuvi,host=pi temperature=21.5,humidity=40,battery=2900 1767225600\n"
+	if line := s.line(reading); line != want {
+		t.Errorf("line =\n%q\nwant\n%q", line, want)
+	}
+
+	agg := sensor.NewAggregate()
+	for _, temperature := range []float64{20, 22} {
+		temperature := temperature
+		agg.Add(&sensor.RuuviData{Model: "ruuvi", Temperature: &temperature, Timestamp: time.Unix(1767225600, 0)})
+	}
+	reading = Reading{MAC: "AA:BB:CC:DD:EE:FF", Payload: NewAggregatePayload(agg, "pi")}
+	want = "sensor,mac=AA:BB:CC:DD:EE:FF,model=ruuvi,host=pi temperature=22," +
+		"temperature_min=20,temperature_max=22,temperature_mean=21,temperature_stddev=1,temperature_count=2,samples=2 1767225600\n"
+	if line := s.line(reading); line != want {
+		t.Errorf("aggregate line =\n%q\nwant\n%q", line, want)
+	}
+
+	if line := s.line(Reading{MAC: "AA:BB:CC:DD:EE:FF"}); line != "" {
+		t.Errorf("reading without values: line = %q", line)
+	}
+}
+
+// TestInfluxMissingDatabase tests that a missing database keeps the
+// readings and pauses the sink
+func TestInfluxMissingDatabase(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"database not found: \"farm\""}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	s, err := NewInfluxSink("influx", server.URL+"/write?db=farm", "", "sensor", "pi")
+	if err != nil {
+		t.Fatalf("NewInfluxSink: %v", err)
+	}
+	temperature := 21.5
+	n, err := s.Write(context.Background(), []Reading{{MAC: "A", Payload: Payload{Temperature: &temperature}}})
+	if n != 0 || !IsMisconfigured(err) {
+		t.Errorf("Write = %d, %v; want 0 and a misconfiguration", n, err)
+	}
+}
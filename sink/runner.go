@@ -0,0 +1,415 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sensorsgo/queue"
+	"sync"
+	"time"
+)
+
+// Defaults of the retry policy of a Runner
+const (
+	MinBackoff = 5 * time.Second
+	MaxBackoff = 5 * time.Minute
+	// MaxRetryAfter caps the wait a server can ask for with Retry-After
+	MaxRetryAfter = time.Hour
+	// BreakerThreshold is the number of consecutive failures that pauses
+	// uploads
+	BreakerThreshold = 5
+	// BreakerCooldown is how long uploads stay paused before a single
+	// reading is sent to probe the destination
+	BreakerCooldown = 10 * time.Minute
+)
+
+// State is the delivery state of a sink, as shown in the UI
+type State string
+
+// States of a Runner. Retrying and Paused mean the last write failed;
+// Paused is the open state of the circuit breaker, and Probing its
+// half-open state where a single reading is sent.
+const (
+	StateOK       State = "ok"
+	StateRetrying State = "retrying"
+	StatePaused   State = "paused"
+	StateProbing  State = "probing"
+)
+
+// Status is the delivery state of a sink
+type Status struct {
+	Name        string
+	State       State
+	Pending     int       // Readings waiting in the sink's queue
+	Failures    int       // Consecutive failed writes
+	Writes      int       // Successful writes since startup
+	WriteErrors int       // Failed writes since startup, including rejected readings
+	LastError   error     // Error of the last write, nil if it succeeded
+	LastSuccess time.Time // Time of the last successful write
+	RetryAt     time.Time // Next attempt while Retrying or Paused
+}
+
+// Policy is the retry policy of a Runner
+type Policy struct {
+	MinBackoff       time.Duration
+	MaxBackoff       time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// DefaultPolicy returns the retry policy built from the package defaults
+func DefaultPolicy() Policy {
+	return Policy{
+		MinBackoff:       MinBackoff,
+		MaxBackoff:       MaxBackoff,
+		BreakerThreshold: BreakerThreshold,
+		BreakerCooldown:  BreakerCooldown,
+	}
+}
+
+// Runner feeds a sink from its own durable queue, in batches, retrying
+// with exponential backoff and jitter while the sink fails, and pausing
+// uploads after BreakerThreshold consecutive failures
+type Runner struct {
+	sink  Sink
+	queue *queue.Queue
+	batch int
+
+	// Logf reports deliveries and failures, including queue errors, e.g.
+	// to the UI log
+	Logf func(format string, args ...interface{})
+	// OnStatus is called after every write attempt
+	OnStatus func(Status)
+
+	notify chan struct{}
+
+	mu     sync.Mutex
+	status Status
+	policy Policy
+}
+
+// NewRunner creates a runner delivering the readings queued in q to s,
+// up to batch readings per write
+func NewRunner(s Sink, q *queue.Queue, batch int) *Runner {
+	if batch < 1 {
+		batch = 1
+	}
+	return &Runner{
+		sink:   s,
+		queue:  q,
+		batch:  batch,
+		Logf:   func(string, ...interface{}) {},
+		notify: make(chan struct{}, 1),
+		status: Status{Name: s.Name(), State: StateOK, Pending: q.Len()},
+		policy: DefaultPolicy(),
+	}
+}
+
+// QueueDir returns the queue directory of the named sink. The api sink
+// keeps the directory used before sinks existed, so that readings queued
+// by older versions are still delivered.
+func QueueDir(dataDir, name string) string {
+	if name == "api" {
+		return filepath.Join(dataDir, "queue")
+	}
+	return filepath.Join(dataDir, "queue-"+name)
+}
+
+// Open creates the runner of every configured sink, each with its queue
+// under dataDir
+func Open(configs []Config, env Env, dataDir string) ([]*Runner, error) {
+	runners := make([]*Runner, 0, len(configs))
+	names := make(map[string]bool)
+
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			cfg.Name = cfg.Type
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("duplicate sink name %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		s, batch, err := New(cfg, env)
+		if err != nil {
+			return nil, err
+		}
+		q, err := queue.Open(QueueDir(dataDir, cfg.Name))
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", cfg.Name, err)
+		}
+		runners = append(runners, NewRunner(s, q, batch))
+	}
+	return runners, nil
+}
+
+// Name returns the name of the sink
+func (r *Runner) Name() string {
+	return r.sink.Name()
+}
+
+// Sink returns the sink fed by the runner
+func (r *Runner) Sink() Sink {
+	return r.sink
+}
+
+// Enqueue stores readings durably for delivery. The runner is woken once
+// all of them are stored, so readings enqueued together go in one batch.
+func (r *Runner) Enqueue(readings ...Reading) error {
+	for _, reading := range readings {
+		entry, err := json.Marshal(reading)
+		if err != nil {
+			return err
+		}
+		if err := r.queue.Append(entry); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	r.status.Pending = r.queue.Len()
+	r.mu.Unlock()
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Status returns the current delivery state
+func (r *Runner) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Policy returns the current retry policy
+func (r *Runner) Policy() Policy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.policy
+}
+
+// SetPolicy replaces the retry policy. It may be called while Run is
+// running and applies from the next write attempt.
+func (r *Runner) SetPolicy(p Policy) {
+	r.mu.Lock()
+	r.policy = p
+	r.mu.Unlock()
+}
+
+// Close closes the queue
+func (r *Runner) Close() error {
+	return r.queue.Close()
+}
+
+// Run delivers queued readings until ctx is cancelled. A batch rejected
+// with a permanent error is retried one reading at a time, so that only
+// the offending reading is discarded. A misconfigured sink keeps its
+// readings and is paused like an open breaker.
+func (r *Runner) Run(ctx context.Context) {
+	backoff := r.Policy().MinBackoff
+	isolate := 0 // Readings left to send one at a time
+
+	for ctx.Err() == nil {
+		size := r.batch
+		if isolate > 0 || r.Status().State == StateProbing {
+			size = 1
+		}
+
+		entries, err := r.queue.Peek(size)
+		if err != nil {
+			r.Logf("⚠️  %s: error leyendo la cola: %v", r.Name(), err)
+			if !r.sleep(ctx, backoff) {
+				return
+			}
+			continue
+		}
+		if len(entries) == 0 {
+			select {
+			case <-r.notify:
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		readings := make([]Reading, 0, len(entries))
+		for _, entry := range entries {
+			var reading Reading
+			if err := json.Unmarshal(entry, &reading); err != nil {
+				break
+			}
+			readings = append(readings, reading)
+		}
+		if len(readings) == 0 {
+			r.Logf("⚠️  %s: descartando entrada inválida de la cola", r.Name())
+			r.ack(1)
+			continue
+		}
+
+		n, err := r.sink.Write(ctx, readings)
+		r.ack(n)
+		if isolate > 0 {
+			isolate -= n
+		}
+
+		switch {
+		case err == nil:
+			backoff = r.Policy().MinBackoff
+			if state := r.Status().State; state == StatePaused || state == StateProbing {
+				r.Logf("▶️  %s: envíos reanudados", r.Name())
+			}
+			r.succeeded()
+			if n > 0 {
+				r.Logf("✅ %s: %d lectura(s) enviadas", r.Name(), n)
+			}
+		case IsPermanent(err) && len(readings)-n > 1:
+			// Find the offending reading
+			isolate = len(readings) - n
+		case IsPermanent(err):
+			// The destination answered, so it is reachable
+			r.ack(1)
+			if isolate > 0 {
+				isolate--
+			}
+			backoff = r.Policy().MinBackoff
+			r.rejected(err)
+			r.Logf("🗑️  %s: lectura descartada: %v", r.Name(), err)
+		default:
+			if ctx.Err() != nil {
+				return
+			}
+			delay, state := r.failed(err, backoff)
+			r.Logf("❌ %s: %v", r.Name(), err)
+			switch {
+			case IsMisconfigured(err):
+				r.Logf("⏸️  %s: revisa la configuración del destino, envíos en pausa %v", r.Name(), delay.Round(time.Second))
+			case state == StatePaused:
+				r.Logf("⏸️  %s: %d fallos seguidos, envíos en pausa %v", r.Name(), r.Status().Failures, delay.Round(time.Second))
+			default:
+				r.Logf("⏳ %s: reintentando en %v", r.Name(), delay.Round(time.Second))
+			}
+			if !r.sleep(ctx, delay) {
+				return
+			}
+			backoff *= 2
+			if max := r.Policy().MaxBackoff; backoff > max {
+				backoff = max
+			}
+			if state == StatePaused {
+				r.setState(StateProbing)
+			}
+		}
+	}
+}
+
+// succeeded records a successful write
+func (r *Runner) succeeded() {
+	r.mu.Lock()
+	r.status.State = StateOK
+	r.status.Failures = 0
+	r.status.LastError = nil
+	r.status.Writes++
+	r.status.LastSuccess = time.Now()
+	r.status.RetryAt = time.Time{}
+	r.mu.Unlock()
+	r.report()
+}
+
+// rejected records a reading discarded with a permanent error. The state
+// and the breaker are left as they are: only a delivered reading shows
+// that the sink works.
+func (r *Runner) rejected(err error) {
+	r.mu.Lock()
+	r.status.WriteErrors++
+	r.status.LastError = err
+	r.mu.Unlock()
+	r.report()
+}
+
+// failed records a failed write and returns how long to wait before the
+// next one: the server's Retry-After if given, otherwise backoff with
+// jitter, or the breaker cooldown once too many writes failed in a row or
+// right away if the sink is misconfigured
+func (r *Runner) failed(err error, backoff time.Duration) (time.Duration, State) {
+	delay := jitter(backoff)
+	if after, ok := RetryAfter(err); ok {
+		delay = after
+		if delay > MaxRetryAfter {
+			delay = MaxRetryAfter
+		}
+	}
+
+	r.mu.Lock()
+	r.status.Failures++
+	r.status.WriteErrors++
+	r.status.LastError = err
+	r.status.State = StateRetrying
+	if r.status.Failures >= r.policy.BreakerThreshold || IsMisconfigured(err) {
+		r.status.State = StatePaused
+		if delay < r.policy.BreakerCooldown {
+			delay = r.policy.BreakerCooldown
+		}
+	}
+	r.status.RetryAt = time.Now().Add(delay)
+	state := r.status.State
+	r.mu.Unlock()
+
+	r.report()
+	return delay, state
+}
+
+func (r *Runner) setState(state State) {
+	r.mu.Lock()
+	r.status.State = state
+	r.mu.Unlock()
+	r.report()
+}
+
+// jitter returns a random duration between d/2 and d, so that sinks and
+// devices failing together do not retry in lockstep
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// ack removes n delivered readings from the queue
+func (r *Runner) ack(n int) {
+	if n <= 0 {
+		return
+	}
+	if err := r.queue.Ack(n); err != nil {
+		r.Logf("⚠️  %s: error confirmando entradas de la cola: %v", r.Name(), err)
+	}
+	r.mu.Lock()
+	r.status.Pending = r.queue.Len()
+	r.mu.Unlock()
+}
+
+// report notifies OnStatus of the current state
+func (r *Runner) report() {
+	r.mu.Lock()
+	r.status.Pending = r.queue.Len()
+	status := r.status
+	r.mu.Unlock()
+
+	if r.OnStatus != nil {
+		r.OnStatus(status)
+	}
+}
+
+// sleep waits for d, returning false if ctx is cancelled first. New
+// readings do not shorten the wait.
+func (r *Runner) sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,291 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sensorsgo/queue"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink records the batches it receives and rejects the readings of
+// sensors listed in reject
+type fakeSink struct {
+	mu      sync.Mutex
+	batches [][]string
+	reject  map[string]bool
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Write(ctx context.Context, readings []Reading) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var macs []string
+	for _, r := range readings {
+		macs = append(macs, r.MAC)
+	}
+	s.batches = append(s.batches, macs)
+
+	for i, r := range readings {
+		if s.reject[r.MAC] {
+			return i, Permanent(errors.New("rejected"))
+		}
+	}
+	return len(readings), nil
+}
+
+// TestRunnerPermanentError tests batching and that a permanent error only
+// discards the offending reading
+func TestRunnerPermanentError(t *testing.T) {
+	q, err := queue.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("queue.Open: %v", err)
+	}
+	defer q.Close()
+
+	s := &fakeSink{reject: map[string]bool{"B": true}}
+	r := NewRunner(s, q, 3)
+	for _, mac := range []string{"A", "B", "C", "D"} {
+		if err := r.Enqueue(Reading{MAC: mac}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if q.Len() != 0 {
+		t.Fatalf("%d readings left in the queue", q.Len())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A is delivered in the first batch, then B and C are sent one at a
+	// time, then D in a new batch
+	want := [][]string{{"A", "B", "C"}, {"B"}, {"C"}, {"D"}}
+	if fmt.Sprint(s.batches) != fmt.Sprint(want) {
+		t.Errorf("batches = %v, want %v", s.batches, want)
+	}
+}
+
+// failingSink fails its first failures writes with a temporary error
+type failingSink struct {
+	mu       sync.Mutex
+	failures int
+	sizes    []int
+}
+
+func (s *failingSink) Name() string { return "failing" }
+
+func (s *failingSink) Write(ctx context.Context, readings []Reading) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizes = append(s.sizes, len(readings))
+	if s.failures > 0 {
+		s.failures--
+		return 0, errors.New("connection refused")
+	}
+	return len(readings), nil
+}
+
+// TestRunnerBreaker tests that repeated failures pause uploads and that a
+// single reading probes the sink before batches resume
+func TestRunnerBreaker(t *testing.T) {
+	q, err := queue.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("queue.Open: %v", err)
+	}
+	defer q.Close()
+
+	s := &failingSink{failures: 2}
+	r := NewRunner(s, q, 10)
+	r.SetPolicy(Policy{
+		MinBackoff:       time.Millisecond,
+		MaxBackoff:       MaxBackoff,
+		BreakerThreshold: 2,
+		BreakerCooldown:  20 * time.Millisecond,
+	})
+
+	var mu sync.Mutex
+	var states []State
+	r.OnStatus = func(status Status) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(states) == 0 || states[len(states)-1] != status.State {
+			states = append(states, status.State)
+		}
+	}
+
+	r.Enqueue(Reading{MAC: "A"}, Reading{MAC: "B"}, Reading{MAC: "C"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if q.Len() != 0 {
+		t.Fatalf("%d readings left in the queue", q.Len())
+	}
+	for r.Status().Writes < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status := r.Status(); status.Writes != 2 || status.WriteErrors != 2 {
+		t.Errorf("writes = %d, errors = %d; want 2 and 2", status.Writes, status.WriteErrors)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []State{StateRetrying, StatePaused, StateProbing, StateOK}
+	if fmt.Sprint(states) != fmt.Sprint(want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fmt.Sprint(s.sizes) != "[3 3 1 2]" {
+		t.Errorf("write sizes = %v, want [3 3 1 2]", s.sizes)
+	}
+}
+
+// TestRetryAfter tests that the server's Retry-After overrides the backoff
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if d, ok := parseRetryAfter("120", now); !ok || d != 2*time.Minute {
+		t.Errorf("parseRetryAfter(120) = %v, %v", d, ok)
+	}
+	if d, ok := parseRetryAfter("Sun, 01 Mar 2026 12:00:30 GMT", now); !ok || d != 30*time.Second {
+		t.Errorf("parseRetryAfter(date) = %v, %v", d, ok)
+	}
+	if _, ok := parseRetryAfter("soon", now); ok {
+		t.Error("parseRetryAfter(soon): expected failure")
+	}
+
+	q, err := queue.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("queue.Open: %v", err)
+	}
+	defer q.Close()
+	r := NewRunner(&failingSink{}, q, 1)
+
+	delay, state := r.failed(&RetryAfterError{Err: errors.New("HTTP 429"), After: 42 * time.Second}, time.Second)
+	if delay != 42*time.Second || state != StateRetrying {
+		t.Errorf("failed(Retry-After 42s) = %v, %v", delay, state)
+	}
+	if delay, _ := r.failed(errors.New("timeout"), 10*time.Second); delay < 5*time.Second || delay > 10*time.Second {
+		t.Errorf("jittered delay = %v, want 5s-10s", delay)
+	}
+}
+
+// unauthorizedSink rejects its key on the first failures writes
+type unauthorizedSink struct {
+	failingSink
+}
+
+func (s *unauthorizedSink) Write(ctx context.Context, readings []Reading) (int, error) {
+	if _, err := s.failingSink.Write(ctx, readings); err != nil {
+		return 0, Misconfigured(errors.New("HTTP 401: invalid key"))
+	}
+	return len(readings), nil
+}
+
+// TestRunnerMisconfigured tests that a rejected key pauses uploads at
+// once and keeps every reading
+func TestRunnerMisconfigured(t *testing.T) {
+	q, err := queue.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("queue.Open: %v", err)
+	}
+	defer q.Close()
+
+	s := &unauthorizedSink{failingSink{failures: 2}}
+	r := NewRunner(s, q, 10)
+	r.SetPolicy(Policy{
+		MinBackoff:       time.Millisecond,
+		MaxBackoff:       MaxBackoff,
+		BreakerThreshold: BreakerThreshold,
+		BreakerCooldown:  20 * time.Millisecond,
+	})
+
+	var mu sync.Mutex
+	var states []State
+	r.OnStatus = func(status Status) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(states) == 0 || states[len(states)-1] != status.State {
+			states = append(states, status.State)
+		}
+	}
+
+	r.Enqueue(Reading{MAC: "A"}, Reading{MAC: "B"}, Reading{MAC: "C"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Status().Writes < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if q.Len() != 0 {
+		t.Fatalf("%d readings left in the queue", q.Len())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The failed probe pauses uploads again
+	want := []State{StatePaused, StateProbing, StatePaused, StateProbing, StateOK}
+	if fmt.Sprint(states) != fmt.Sprint(want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Every reading is delivered once the key is accepted again
+	if fmt.Sprint(s.sizes) != "[3 1 1 2]" {
+		t.Errorf("write sizes = %v, want [3 1 1 2]", s.sizes)
+	}
+}
+
+// TestRunnerRejected tests that a discarded reading is not reported as a
+// successful write
+func TestRunnerRejected(t *testing.T) {
+	q, err := queue.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("queue.Open: %v", err)
+	}
+	defer q.Close()
+
+	r := NewRunner(&fakeSink{reject: map[string]bool{"A": true}}, q, 1)
+	r.Enqueue(Reading{MAC: "A"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Status().WriteErrors == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+
+	status := r.Status()
+	if status.Writes != 0 || status.WriteErrors != 1 || status.LastError == nil || !status.LastSuccess.IsZero() {
+		t.Errorf("status = %+v, want one write error and no success", status)
+	}
+	if q.Len() != 0 {
+		t.Errorf("%d readings left in the queue", q.Len())
+	}
+}
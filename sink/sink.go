@@ -0,0 +1,403 @@
+// Package sink delivers readings to their destinations (the Larvai API,
+// InfluxDB, CSV files, stdout). Each destination is a Sink fed by a Runner
+// that keeps its own durable queue, so a slow or failing destination never
+// holds back the others.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sensorsgo/sensor"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sink is a destination for readings
+type Sink interface {
+	// Name identifies the sink in logs and the UI
+	Name() string
+	// Write delivers readings in order and returns how many of them, from
+	// the start, were delivered. An error wrapping a PermanentError means
+	// the next reading was rejected and retrying it would not help; one
+	// wrapping a MisconfiguredError means no reading will get through
+	// until the settings are fixed.
+	Write(ctx context.Context, readings []Reading) (int, error)
+}
+
+// Reading is one sensor reading as queued for the sinks
+type Reading struct {
+	MAC     string  `json:"mac"`
+	Name    string  `json:"name,omitempty"` // Registered sensor name
+	Payload Payload `json:"payload"`
+}
+
+// Payload is a reading in the format of the Larvai API
+type Payload struct {
+	Model               string   `json:"model,omitempty"`
+	DataFormat          uint8    `json:"data_format,omitempty"`
+	Temperature         *float64 `json:"temperature,omitempty"`
+	Humidity            *float64 `json:"humidity,omitempty"`
+	Pressure            *float64 `json:"pressure,omitempty"`
+	Battery             *uint16  `json:"battery,omitempty"`
+	BatteryPercent      *uint8   `json:"battery_percent,omitempty"`
+	TxPower             *int8    `json:"tx_power,omitempty"`
+	AccelerationX       *float64 `json:"acceleration_x,omitempty"`
+	AccelerationY       *float64 `json:"acceleration_y,omitempty"`
+	AccelerationZ       *float64 `json:"acceleration_z,omitempty"`
+	MovementCounter     *uint8   `json:"movement_counter,omitempty"`
+	MeasurementSequence *uint32  `json:"measurement_sequence,omitempty"`
+	CO2                 *uint16  `json:"co2,omitempty"`
+	PM1_0               *float64 `json:"pm1_0,omitempty"`
+	PM2_5               *float64 `json:"pm2_5,omitempty"`
+	PM4_0               *float64 `json:"pm4_0,omitempty"`
+	PM10_0              *float64 `json:"pm10_0,omitempty"`
+	VOCIndex            *uint16  `json:"voc_index,omitempty"`
+	NOxIndex            *uint16  `json:"nox_index,omitempty"`
+	Luminosity          *float64 `json:"luminosity,omitempty"`
+	SoundLevel          *float64 `json:"sound_level,omitempty"`
+	Hostname            string   `json:"hostname"`
+	MeasuredAt          string   `json:"measured_at"` // RFC3339, when the measurement was taken
+
+	// Statistics of each field over the send interval; the fields above
+	// hold the last value
+	Stats         map[string]sensor.Stats `json:"stats,omitempty"`
+	Samples       int                     `json:"samples,omitempty"`        // Distinct measurements in the interval
+	IntervalStart string                  `json:"interval_start,omitempty"` // RFC3339, first measurement of the interval
+}
+
+// NewPayload builds the API payload of a reading
+func NewPayload(data *sensor.RuuviData, hostname string) Payload {
+	measuredAt := data.Timestamp
+	if measuredAt.IsZero() {
+		measuredAt = time.Now()
+	}
+
+	return Payload{
+		Model:               data.Model,
+		DataFormat:          data.DataFormat,
+		Temperature:         data.Temperature,
+		Humidity:            data.Humidity,
+		Pressure:            data.Pressure,
+		Battery:             data.Battery,
+		BatteryPercent:      data.BatteryPercent,
+		TxPower:             data.TxPower,
+		AccelerationX:       data.AccelerationX,
+		AccelerationY:       data.AccelerationY,
+		AccelerationZ:       data.AccelerationZ,
+		MovementCounter:     data.MovementCounter,
+		MeasurementSequence: data.Sequence,
+		CO2:                 data.CO2,
+		PM1_0:               data.PM1_0,
+		PM2_5:               data.PM2_5,
+		PM4_0:               data.PM4_0,
+		PM10_0:              data.PM10_0,
+		VOCIndex:            data.VOCIndex,
+		NOxIndex:            data.NOxIndex,
+		Luminosity:          data.Luminosity,
+		SoundLevel:          data.SoundLevel,
+		Hostname:            hostname,
+		MeasuredAt:          measuredAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// NewAggregatePayload builds the API payload of an interval: the last
+// reading plus the statistics of every field
+func NewAggregatePayload(agg *sensor.Aggregate, hostname string) Payload {
+	p := NewPayload(agg.Last, hostname)
+	p.Stats = agg.Stats()
+	p.Samples = agg.Samples()
+	if !agg.Start.IsZero() {
+		p.IntervalStart = agg.Start.UTC().Format(time.RFC3339)
+	}
+	return p
+}
+
+// Values returns the numeric fields present in the payload, keyed by
+// their JSON name as listed in sensor.Fields
+func (p Payload) Values() map[string]float64 {
+	values := make(map[string]float64)
+
+	encoded, err := json.Marshal(p)
+	if err != nil {
+		return values
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return values
+	}
+
+	for _, f := range sensor.Fields {
+		if v, ok := fields[f.Key].(float64); ok {
+			values[f.Key] = v
+		}
+	}
+	return values
+}
+
+// Time parses MeasuredAt, falling back to the current time
+func (p Payload) Time() time.Time {
+	t, err := time.Parse(time.RFC3339, p.MeasuredAt)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
+// PermanentError marks a failure that retrying will not fix, such as a
+// rejected payload
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps err in a PermanentError
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err wraps a PermanentError
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// MisconfiguredError marks a failure of every reading until the user
+// fixes the sink settings, such as a rejected key or a missing database.
+// Readings are kept and uploads pause as if the breaker had opened.
+type MisconfiguredError struct {
+	Err error
+}
+
+func (e *MisconfiguredError) Error() string { return e.Err.Error() }
+func (e *MisconfiguredError) Unwrap() error { return e.Err }
+
+// Misconfigured wraps err in a MisconfiguredError
+func Misconfigured(err error) error {
+	return &MisconfiguredError{Err: err}
+}
+
+// IsMisconfigured reports whether err wraps a MisconfiguredError
+func IsMisconfigured(err error) bool {
+	var misconfigured *MisconfiguredError
+	return errors.As(err, &misconfigured)
+}
+
+// RetryAfterError is a temporary failure for which the destination said
+// when to try again (HTTP 429 or 503 with Retry-After)
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string { return e.Err.Error() }
+func (e *RetryAfterError) Unwrap() error { return e.Err }
+
+// RetryAfter returns the wait requested by a RetryAfterError in err
+func RetryAfter(err error) (time.Duration, bool) {
+	var retry *RetryAfterError
+	if errors.As(err, &retry) {
+		return retry.After, true
+	}
+	return 0, false
+}
+
+// parseRetryAfter parses a Retry-After header, either delay seconds or an
+// HTTP date
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// httpError builds the error of a failed HTTP request: misconfigured for
+// 401 and 403, permanent for the statuses of isPermanentStatus, otherwise
+// temporary, carrying Retry-After when the server sent it
+func httpError(resp *http.Response, err error) error {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return Misconfigured(err)
+	case isPermanentStatus(resp.StatusCode):
+		return Permanent(err)
+	}
+	if after, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		return &RetryAfterError{Err: err, After: after}
+	}
+	return err
+}
+
+// isPermanentStatus reports whether an HTTP error status rejects the
+// readings sent rather than the request: 400 (invalid), 413 (too large)
+// and 422 (unprocessable). Other 4xx may be fixed on the server or in the
+// settings, so the readings are kept.
+func isPermanentStatus(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// Config describes one sink of a deployment
+type Config struct {
+	Type    string            // api, influx, csv or stdout
+	Name    string            // Unique name, defaults to Type
+	Batch   int               // Readings per write, 0 for the type's default
+	Options map[string]string // Type specific settings
+}
+
+// Types lists the sink types accepted by New
+var Types = []string{"api", "influx", "csv", "stdout"}
+
+// ParseSpec parses a sink given on the command line as
+// "type[,key=value...]", e.g. "influx,url=http://localhost:8086/write?db=farm,batch=500".
+// The name and batch keys set Config.Name and Config.Batch.
+func ParseSpec(spec string) (Config, error) {
+	parts := strings.Split(spec, ",")
+	cfg := Config{Type: strings.TrimSpace(parts[0]), Options: make(map[string]string)}
+	if cfg.Type == "" {
+		return cfg, errors.New("empty sink type")
+	}
+
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return cfg, fmt.Errorf("sink %s: expected key=value, got %q", cfg.Type, part)
+		}
+		key = strings.TrimSpace(key)
+		switch key {
+		case "name":
+			cfg.Name = value
+		case "batch":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return cfg, fmt.Errorf("sink %s: invalid batch %q", cfg.Type, value)
+			}
+			cfg.Batch = n
+		default:
+			cfg.Options[key] = value
+		}
+	}
+
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+	return cfg, nil
+}
+
+// Env holds the settings sinks take from the deployment rather than
+// from their own configuration
+type Env struct {
+	APIURL   string // Default Larvai API endpoint
+	APIKey   string
+	Hostname string
+	Stdout   io.Writer // Destination of the stdout sink, os.Stdout if nil
+	Console  io.Writer // Messages of the api sink, os.Stdout if nil
+}
+
+// New creates the sink described by cfg and returns it with its batch size
+func New(cfg Config, env Env) (Sink, int, error) {
+	option := func(key, def string) string {
+		if v, ok := cfg.Options[key]; ok {
+			return v
+		}
+		return def
+	}
+	batch := func(def int) int {
+		if cfg.Batch > 0 {
+			return cfg.Batch
+		}
+		return def
+	}
+
+	var s Sink
+	var err error
+	var size int
+	switch cfg.Type {
+	case "api":
+		url, key := option("url", env.APIURL), option("key", env.APIKey)
+		switch mode := option("mode", "single"); mode {
+		case "single":
+			api := NewAPISink(cfg.Name, url, key)
+			api.Log = env.Console
+			s, size = api, batch(1)
+		case "batch":
+			api := NewAPIBatchSink(cfg.Name, url, key, option("batch_url", url+"/batch"))
+			api.Log = env.Console
+			s, size = api, batch(500)
+		default:
+			return nil, 0, fmt.Errorf("sink %s: invalid mode %q (single or batch)", cfg.Name, mode)
+		}
+	case "influx":
+		s, err = NewInfluxSink(cfg.Name, option("url", ""), option("token", ""), option("measurement", "sensor"), env.Hostname)
+		size = batch(500)
+	case "csv":
+		s, size = NewCSVSink(cfg.Name, option("dir", "csv")), batch(100)
+	case "stdout":
+		s, size = NewStdoutSink(cfg.Name, env.Stdout), batch(100)
+	default:
+		return nil, 0, fmt.Errorf("unknown sink type %q (available: %s)", cfg.Type, strings.Join(Types, ", "))
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("sink %s: %w", cfg.Name, err)
+	}
+
+	for key := range cfg.Options {
+		if !knownOption(cfg.Type, key) {
+			return nil, 0, fmt.Errorf("sink %s: unknown option %q", cfg.Name, key)
+		}
+	}
+	return s, size, nil
+}
+
+// options lists the settings each sink type understands
+var options = map[string][]string{
+	"api":    {"url", "key", "mode", "batch_url"},
+	"influx": {"url", "token", "measurement"},
+	"csv":    {"dir"},
+	"stdout": {},
+}
+
+func knownOption(sinkType, key string) bool {
+	for _, k := range options[sinkType] {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of values in sensor.Fields order
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	order := make(map[string]int, len(sensor.Fields))
+	for i, f := range sensor.Fields {
+		order[f.Key] = i
+	}
+	sort.Slice(keys, func(i, j int) bool { return order[keys[i]] < order[keys[j]] })
+	return keys
+}
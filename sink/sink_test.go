@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"testing"
+)
+
+// TestParseSpec tests parsing of -sink flags
+func TestParseSpec(t *testing.T) {
+	cfg, err := ParseSpec("influx,url=http://localhost:8086/write?db=farm,batch=50,name=local")
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+	if cfg.Type != "influx" || cfg.Name != "local" || cfg.Batch != 50 {
+		t.Errorf("ParseSpec = %+v", cfg)
+	}
+	if cfg.Options["url"] != "http://localhost:8086/write?db=farm" {
+		t.Errorf("url = %q", cfg.Options["url"])
+	}
+
+	cfg, err = ParseSpec("stdout")
+	if err != nil || cfg.Name != "stdout" {
+		t.Errorf("ParseSpec(stdout) = %+v, %v", cfg, err)
+	}
+
+	for _, spec := range []string{"", "csv,dir", "api,batch=0"} {
+		if _, err := ParseSpec(spec); err == nil {
+			t.Errorf("ParseSpec(%q): expected error", spec)
+		}
+	}
+}
+
+// TestNew tests sink creation, default batch sizes and option checking
+func TestNew(t *testing.T) {
+	env := Env{APIURL: "https://example.com/api", APIKey: "key"}
+
+	s, batch, err := New(Config{Type: "api", Name: "api"}, env)
+	if err != nil {
+		t.Fatalf("New(api): %v", err)
+	}
+	if api := s.(*APISink); api.url != env.APIURL || api.key != "key" || batch != 1 {
+		t.Errorf("api sink = %q %q, batch %d", api.url, api.key, batch)
+	}
+
+	_, batch, err = New(Config{Type: "csv", Name: "csv", Batch: 10}, env)
+	if err != nil || batch != 10 {
+		t.Errorf("New(csv) batch = %d, %v", batch, err)
+	}
+
+	if _, _, err := New(Config{Type: "influx", Name: "influx"}, env); err == nil {
+		t.Error("influx without url: expected error")
+	}
+	if _, _, err := New(Config{Type: "csv", Name: "csv", Options: map[string]string{"url": "x"}}, env); err == nil {
+		t.Error("unknown option: expected error")
+	}
+	if _, _, err := New(Config{Type: "kafka", Name: "kafka"}, env); err == nil {
+		t.Error("unknown type: expected error")
+	}
+}
@@ -0,0 +1,36 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// StdoutSink writes each reading as a JSON line, for piping into other
+// tools
+type StdoutSink struct {
+	name string
+	enc  *json.Encoder
+}
+
+// NewStdoutSink creates a sink writing to w, or to os.Stdout if w is nil
+func NewStdoutSink(name string, w io.Writer) *StdoutSink {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &StdoutSink{name: name, enc: json.NewEncoder(w)}
+}
+
+// Name returns the sink name
+func (s *StdoutSink) Name() string { return s.name }
+
+// Write encodes the readings in the queue format: {"mac", "name", "payload"}
+func (s *StdoutSink) Write(ctx context.Context, readings []Reading) (int, error) {
+	for i, reading := range readings {
+		if err := s.enc.Encode(reading); err != nil {
+			return i, err
+		}
+	}
+	return len(readings), nil
+}
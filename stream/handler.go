@@ -0,0 +1,83 @@
+package stream
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// writeTimeout disconnects a client that does not accept an event
+	// for this long
+	writeTimeout = 10 * time.Second
+	// keepAlive is how often an idle connection is pinged, so that
+	// proxies keep it open and dead clients are noticed
+	keepAlive = 30 * time.Second
+)
+
+// Handler serves the events of h: a WebSocket if the request asks for an
+// upgrade, Server-Sent Events otherwise. The query parameters "type" and
+// "sensor" take comma separated lists to filter the events.
+func (h *Hub) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		filter := ParseFilter(query.Get("type"), query.Get("sensor"))
+
+		if isWebSocketUpgrade(r) {
+			h.serveWebSocket(w, r, filter)
+			return
+		}
+		h.serveSSE(w, r, filter)
+	})
+}
+
+// serveSSE streams events as text/event-stream until the client goes
+// away. Events are unnamed, so that EventSource.onmessage receives all of
+// them; their type is in the JSON.
+func (h *Hub) serveSSE(w http.ResponseWriter, r *http.Request, filter Filter) {
+	rc := http.NewResponseController(w)
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // Do not buffer behind nginx
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	sub := h.subscribe(filter, DefaultBuffer)
+	defer h.unsubscribe(sub)
+
+	ping := time.NewTicker(keepAlive)
+	defer ping.Stop()
+
+	write := func(format string, args ...interface{}) bool {
+		rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			if !write(": ping\n\n") {
+				return
+			}
+		case msg := <-sub.ch:
+			for _, m := range sub.withDropped(msg) {
+				if !write("data: %s\n\n", m) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitClients waits until h has n subscribers
+func waitClients(t *testing.T, h *Hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Clients() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Clients = %d, want %d", h.Clients(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestSSE tests that filtered events are streamed as Server-Sent Events
+func TestSSE(t *testing.T) {
+	h := NewHub()
+	server := httptest.NewServer(h.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream?type=reading")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	waitClients(t, h, 1)
+
+	h.Publish(Event{Type: "sync"})
+	h.Publish(Event{Type: "reading", MAC: "C4:7C:8D:6A:1B:2E"})
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading stream: %v", err)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil || e.Type != "reading" {
+		t.Errorf("first event = %q (%v), want the reading", line, err)
+	}
+}
+
+// TestWebSocket tests the opening handshake, text frames with events and
+// the answer to a close frame
+func TestWebSocket(t *testing.T) {
+	h := NewHub()
+	server := httptest.NewServer(h.Handler())
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("GET /stream?sensor=C4:7C:8D:6A:1B:2E HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	// Example key and accept value from RFC 6455, section 1.3
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake = %d %v", resp.StatusCode, resp.Header)
+	}
+	waitClients(t, h, 1)
+
+	h.Publish(Event{Type: "reading", MAC: "AA:BB:CC:DD:EE:FF"})
+	h.Publish(Event{Type: "reading", MAC: "C4:7C:8D:6A:1B:2E"})
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != 0x80|opText {
+		t.Fatalf("frame header = %x, %v", header, err)
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("frame payload: %v", err)
+	}
+	var e Event
+	if err := json.Unmarshal(payload, &e); err != nil || e.MAC != "C4:7C:8D:6A:1B:2E" {
+		t.Errorf("event = %s (%v)", payload, err)
+	}
+
+	// Masked close frame with status 1000 and the mask 0x01020304
+	conn.Write([]byte{0x80 | opClose, 0x80 | 2, 1, 2, 3, 4, 0x03 ^ 1, 0xE8 ^ 2})
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != 0x80|opClose {
+		t.Errorf("close answer = %x, %v", header, err)
+	}
+	waitClients(t, h, 0)
+}
@@ -0,0 +1,175 @@
+// Package stream pushes live events (readings, sensor state changes,
+// upload results) to HTTP clients over Server-Sent Events or WebSocket.
+// Publishing never blocks: a client that does not keep up loses its
+// oldest events and is told how many it missed.
+package stream
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuffer is the number of events queued per client
+const DefaultBuffer = 64
+
+// Event is a message pushed to clients, sent as JSON
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	MAC  string      `json:"mac,omitempty"`  // Sensor of the event, if any
+	Name string      `json:"name,omitempty"` // Registered name of that sensor
+	Data interface{} `json:"data,omitempty"`
+}
+
+// DroppedType is the type of the event sent to a client after it missed
+// events because it was too slow; its data is {"count": n}
+const DroppedType = "dropped"
+
+// Filter selects the events a client receives. An empty set matches
+// everything; events without a sensor pass the Sensors filter.
+type Filter struct {
+	Types   map[string]bool // Event types
+	Sensors map[string]bool // MAC addresses or names, lower case
+}
+
+// ParseFilter builds a filter from comma separated lists of types and of
+// sensor MACs or names
+func ParseFilter(types, sensors string) Filter {
+	f := Filter{Types: make(map[string]bool), Sensors: make(map[string]bool)}
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			f.Types[t] = true
+		}
+	}
+	for _, s := range strings.Split(sensors, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			f.Sensors[normalize(s)] = true
+		}
+	}
+	return f
+}
+
+// Match reports whether e passes the filter
+func (f Filter) Match(e Event) bool {
+	if len(f.Types) > 0 && !f.Types[e.Type] {
+		return false
+	}
+	if len(f.Sensors) > 0 && e.MAC != "" {
+		return f.Sensors[normalize(e.MAC)] || (e.Name != "" && f.Sensors[normalize(e.Name)])
+	}
+	return true
+}
+
+// normalize lowers a MAC or name, accepting dashes in MAC addresses
+func normalize(s string) string {
+	s = strings.ToLower(s)
+	if len(s) == 17 && strings.Count(s, "-") == 5 {
+		s = strings.ReplaceAll(s, "-", ":")
+	}
+	return s
+}
+
+// Hub fans events out to subscribers. It is safe for concurrent use.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]bool
+}
+
+// NewHub creates a hub without subscribers
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*subscriber]bool)}
+}
+
+// subscriber is a client receiving the events matching its filter
+type subscriber struct {
+	filter Filter
+	ch     chan []byte // Events encoded as JSON
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// subscribe registers a client queueing up to buffer events
+func (h *Hub) subscribe(f Filter, buffer int) *subscriber {
+	if buffer < 1 {
+		buffer = 1
+	}
+	s := &subscriber{filter: f, ch: make(chan []byte, buffer)}
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+	return s
+}
+
+// unsubscribe removes s from the hub
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// Clients returns the number of subscribers
+func (h *Hub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Publish sends e to every subscriber whose filter matches. It is encoded
+// once for all of them. A zero Time is set to now.
+func (h *Hub) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) == 0 {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	for s := range h.subs {
+		if s.filter.Match(e) {
+			s.push(data)
+		}
+	}
+}
+
+// push queues msg, dropping the oldest queued event if the client is
+// behind
+func (s *subscriber) push(msg []byte) {
+	for {
+		select {
+		case s.ch <- msg:
+			return
+		default:
+		}
+		select {
+		case <-s.ch:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		default:
+		}
+	}
+}
+
+// withDropped returns msg, preceded by a dropped event if events were
+// lost since the last call
+func (s *subscriber) withDropped(msg []byte) [][]byte {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+
+	if dropped == 0 {
+		return [][]byte{msg}
+	}
+	data, _ := json.Marshal(Event{Type: DroppedType, Time: time.Now(), Data: map[string]int{"count": dropped}})
+	return [][]byte{data, msg}
+}
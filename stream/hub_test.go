@@ -0,0 +1,61 @@
+package stream
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestFilter tests filtering by type and by sensor MAC or name
+func TestFilter(t *testing.T) {
+	f := ParseFilter("reading, online", "c4-7c-8d-6a-1b-2e,Bandeja 3")
+	tests := []struct {
+		event Event
+		want  bool
+	}{
+		{Event{Type: "reading", MAC: "C4:7C:8D:6A:1B:2E"}, true},
+		{Event{Type: "online", MAC: "AA:BB:CC:DD:EE:FF", Name: "bandeja 3"}, true},
+		{Event{Type: "reading", MAC: "AA:BB:CC:DD:EE:FF", Name: "Bandeja 4"}, false},
+		{Event{Type: "sync"}, false},
+		{Event{Type: "online"}, true}, // No sensor
+	}
+	for _, tt := range tests {
+		if got := f.Match(tt.event); got != tt.want {
+			t.Errorf("Match(%+v) = %v, want %v", tt.event, got, tt.want)
+		}
+	}
+	if !ParseFilter("", "").Match(Event{Type: "sync"}) {
+		t.Error("empty filter should match everything")
+	}
+}
+
+// TestSlowClient tests that a client that falls behind keeps the newest
+// events and is told how many it missed
+func TestSlowClient(t *testing.T) {
+	h := NewHub()
+	sub := h.subscribe(Filter{}, 2)
+	for i := 1; i <= 5; i++ {
+		h.Publish(Event{Type: "reading", Data: i})
+	}
+
+	msgs := sub.withDropped(<-sub.ch)
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want a dropped event and the next one", len(msgs))
+	}
+	var dropped, first Event
+	json.Unmarshal(msgs[0], &dropped)
+	json.Unmarshal(msgs[1], &first)
+	if dropped.Type != DroppedType || dropped.Data.(map[string]interface{})["count"] != 3.0 {
+		t.Errorf("dropped event = %s", msgs[0])
+	}
+	if first.Data != 4.0 {
+		t.Errorf("first event kept = %v, want 4", first.Data)
+	}
+	if msgs := sub.withDropped(<-sub.ch); len(msgs) != 1 {
+		t.Errorf("got %d messages, want 1", len(msgs))
+	}
+
+	h.unsubscribe(sub)
+	if h.Clients() != 0 {
+		t.Errorf("Clients = %d after unsubscribing", h.Clients())
+	}
+}
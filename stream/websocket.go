@@ -0,0 +1,228 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client key to compute the accept key
+// (RFC 6455, section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes used by the server
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+const (
+	// maxControlPayload is the largest payload of a control frame
+	maxControlPayload = 125
+	// maxClientFrame limits the frames read from clients, whose data
+	// frames are discarded
+	maxClientFrame = 1 << 16
+)
+
+var errFrameTooLarge = errors.New("websocket frame too large")
+
+// isWebSocketUpgrade reports whether r asks to switch to WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// headerContains reports whether a comma separated header lists token
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value for a client key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsConn writes frames to a WebSocket client. Writes are serialized
+// because events, pings and pongs come from different goroutines.
+type wsConn struct {
+	conn net.Conn
+	mu   sync.Mutex
+	bw   *bufio.Writer
+}
+
+// writeFrame writes one unfragmented, unmasked frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var header [10]byte
+	header[0] = 0x80 | opcode // FIN
+	n := 2
+	switch size := len(payload); {
+	case size <= 125:
+		header[1] = byte(size)
+	case size <= 0xFFFF:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(size))
+		n = 4
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(size))
+		n = 10
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	c.bw.Write(header[:n])
+	c.bw.Write(payload)
+	return c.bw.Flush()
+}
+
+// close sends a close frame with a status code
+func (c *wsConn) close(code uint16) {
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], code)
+	c.writeFrame(opClose, payload[:])
+}
+
+// serveWebSocket performs the opening handshake and sends events as text
+// frames until the client closes the connection or stops answering
+func (h *Hub) serveWebSocket(w http.ResponseWriter, r *http.Request, filter Filter) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return
+	}
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	defer netConn.Close()
+
+	conn := &wsConn{conn: netConn, bw: brw.Writer}
+	netConn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		return
+	}
+	netConn.SetDeadline(time.Time{})
+
+	sub := h.subscribe(filter, DefaultBuffer)
+	defer h.unsubscribe(sub)
+
+	// Read client frames: answer pings and stop on close or error
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.readLoop(brw.Reader)
+	}()
+
+	ping := time.NewTicker(keepAlive)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if conn.writeFrame(opPing, nil) != nil {
+				return
+			}
+		case msg := <-sub.ch:
+			for _, m := range sub.withDropped(msg) {
+				if conn.writeFrame(opText, m) != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// readLoop reads frames from the client until it closes the connection,
+// sends a malformed frame or is silent for longer than two pings
+func (c *wsConn) readLoop(r *bufio.Reader) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(2*keepAlive + writeTimeout))
+		opcode, payload, err := readFrame(r)
+		if err != nil {
+			if errors.Is(err, errFrameTooLarge) {
+				c.close(1009) // Message too big
+			}
+			return
+		}
+		switch opcode {
+		case opClose:
+			c.close(1000)
+			return
+		case opPing:
+			if c.writeFrame(opPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads one frame sent by a client and returns its unmasked
+// payload. Clients must mask their frames (RFC 6455, section 5.1).
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+
+	size := uint64(header[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > maxClientFrame || (opcode >= opClose && size > maxControlPayload) {
+		return 0, nil, errFrameTooLarge
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
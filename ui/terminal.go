@@ -2,9 +2,12 @@ package ui
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -20,14 +23,22 @@ type TerminalUI struct {
 	status      string
 	success     bool
 	sensors     string
+	pending     int
+	sinks       []SinkState
 	logs        []string
 	timestamp   string
 	mu          sync.Mutex
 	maxLogLines int
+	out         io.Writer
 }
 
-func NewTerminalUI() *TerminalUI {
+// NewTerminalUI creates a UI drawn on out, or on os.Stdout if out is nil
+func NewTerminalUI(out io.Writer) *TerminalUI {
+	if out == nil {
+		out = os.Stdout
+	}
 	return &TerminalUI{
+		out:         out,
 		status:      "Inicializando sistema...",
 		success:     false,
 		sensors:     "Sensores: --",
@@ -58,7 +69,7 @@ func (t *TerminalUI) Render() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	fmt.Print(Clear)
+	fmt.Fprint(t.out, Clear)
 
 	// Determine background color and icon
 	bg := Red
@@ -71,7 +82,7 @@ func (t *TerminalUI) Render() {
 	}
 
 	// Top border
-	fmt.Println("╔════════════════════════════════════════════════╗")
+	fmt.Fprintln(t.out, "╔════════════════════════════════════════════════╗")
 
 	// Header with sensor status
 	headerLeft := "Insectius Monitor"
@@ -80,24 +91,24 @@ func (t *TerminalUI) Render() {
 	if padding < 0 {
 		padding = 0
 	}
-	fmt.Printf("║ %s%s%s ║\n", headerLeft, strings.Repeat(" ", padding), headerRight)
+	fmt.Fprintf(t.out, "║ %s%s%s ║\n", headerLeft, strings.Repeat(" ", padding), headerRight)
 
 	// Separator
-	fmt.Println("╠════════════════════════════════════════════════╣")
+	fmt.Fprintln(t.out, "╠════════════════════════════════════════════════╣")
 
 	// Status section with colored background (centered)
-	fmt.Printf("║                                                ║\n")
+	fmt.Fprintf(t.out, "║                                                ║\n")
 
 	// Center the icon and status
 	iconLine := fmt.Sprintf("%s     %s     %s", bg+White, icon, Reset)
 	iconPadding := (48 - 11) / 2 // Account for ANSI codes visually
-	fmt.Printf("║%s%s%s║\n", strings.Repeat(" ", iconPadding), iconLine, strings.Repeat(" ", 48-iconPadding-11))
+	fmt.Fprintf(t.out, "║%s%s%s║\n", strings.Repeat(" ", iconPadding), iconLine, strings.Repeat(" ", 48-iconPadding-11))
 
 	statusLine := truncate(statusText, 20)
 	statusPadding := (48 - len(statusLine)) / 2
-	fmt.Printf("║%s%s%s║\n", strings.Repeat(" ", statusPadding), statusLine, strings.Repeat(" ", 48-statusPadding-len(statusLine)))
+	fmt.Fprintf(t.out, "║%s%s%s║\n", strings.Repeat(" ", statusPadding), statusLine, strings.Repeat(" ", 48-statusPadding-len(statusLine)))
 
-	fmt.Printf("║                                                ║\n")
+	fmt.Fprintf(t.out, "║                                                ║\n")
 
 	// Timestamp (centered)
 	tsLine := t.timestamp
@@ -105,19 +116,30 @@ func (t *TerminalUI) Render() {
 		tsLine = time.Now().Format("15:04:05 - 02/01/2006")
 	}
 	tsPadding := (48 - len(tsLine)) / 2
-	fmt.Printf("║%s%s%s║\n", strings.Repeat(" ", tsPadding), tsLine, strings.Repeat(" ", 48-tsPadding-len(tsLine)))
-
-	fmt.Printf("║                                                ║\n")
+	fmt.Fprintf(t.out, "║%s%s%s║\n", strings.Repeat(" ", tsPadding), tsLine, strings.Repeat(" ", 48-tsPadding-len(tsLine)))
+
+	// Upload queue (centered)
+	pendingLine := pendingText(t.pending)
+	pendingPadding := (48 - len(pendingLine)) / 2
+	fmt.Fprintf(t.out, "║%s%s%s║\n", strings.Repeat(" ", pendingPadding), pendingLine, strings.Repeat(" ", 48-pendingPadding-len(pendingLine)))
+
+	// Sink states (centered), with more than one sink or while one fails
+	if len(t.sinks) > 1 || (len(t.sinks) == 1 && !t.sinks[0].OK) {
+		sinksLine := truncate(sinksText(t.sinks), 46)
+		sinksWidth := utf8.RuneCountInString(sinksLine)
+		sinksPadding := (48 - sinksWidth) / 2
+		fmt.Fprintf(t.out, "║%s%s%s║\n", strings.Repeat(" ", sinksPadding), sinksLine, strings.Repeat(" ", 48-sinksPadding-sinksWidth))
+	}
 
 	// Activity section separator
-	fmt.Println("╠════════════════════════════════════════════════╣")
+	fmt.Fprintln(t.out, "╠════════════════════════════════════════════════╣")
 
 	// Activity title
 	activityTitle := "Actividad del Sistema"
 	activityPadding := (48 - len(activityTitle)) / 2
-	fmt.Printf("║%s%s%s%s║\n", strings.Repeat(" ", activityPadding), Bold, activityTitle, Reset+strings.Repeat(" ", 48-activityPadding-len(activityTitle)))
+	fmt.Fprintf(t.out, "║%s%s%s%s║\n", strings.Repeat(" ", activityPadding), Bold, activityTitle, Reset+strings.Repeat(" ", 48-activityPadding-len(activityTitle)))
 
-	fmt.Println("╠════════════════════════════════════════════════╣")
+	fmt.Fprintln(t.out, "╠════════════════════════════════════════════════╣")
 
 	// Activity logs
 	displayLogs := t.logs
@@ -126,16 +148,16 @@ func (t *TerminalUI) Render() {
 	}
 
 	for _, log := range displayLogs {
-		fmt.Printf("║ %-46s ║\n", truncate(log, 46))
+		fmt.Fprintf(t.out, "║ %-46s ║\n", truncate(log, 46))
 	}
 
 	// Fill remaining lines if needed
 	for i := len(displayLogs); i < t.maxLogLines; i++ {
-		fmt.Printf("║%s║\n", strings.Repeat(" ", 48))
+		fmt.Fprintf(t.out, "║%s║\n", strings.Repeat(" ", 48))
 	}
 
 	// Bottom border
-	fmt.Println("╚════════════════════════════════════════════════╝")
+	fmt.Fprintln(t.out, "╚════════════════════════════════════════════════╝")
 }
 
 func (t *TerminalUI) UpdateStatus(success bool, msg string) {
@@ -168,6 +190,57 @@ func (t *TerminalUI) UpdateSensors(online, total int) {
 	t.Render()
 }
 
+// UpdatePending sets the number of readings waiting in the upload queue
+func (t *TerminalUI) UpdatePending(n int) {
+	t.mu.Lock()
+	t.pending = n
+	t.mu.Unlock()
+	t.Render()
+}
+
+// SinkState is the delivery state of one output sink
+type SinkState struct {
+	Name   string
+	OK     bool
+	Paused bool   // Uploads paused after repeated failures
+	Note   string // Short detail, e.g. when the next retry is due
+}
+
+// UpdateSinks sets the state of each output sink
+func (t *TerminalUI) UpdateSinks(sinks []SinkState) {
+	t.mu.Lock()
+	t.sinks = append([]SinkState(nil), sinks...)
+	t.mu.Unlock()
+	t.Render()
+}
+
+func sinksText(sinks []SinkState) string {
+	parts := make([]string, len(sinks))
+	for i, s := range sinks {
+		icon := "✓"
+		if s.Paused {
+			icon = "⏸"
+		} else if !s.OK {
+			icon = "✗"
+		}
+		parts[i] = s.Name + " " + icon
+		if s.Note != "" {
+			parts[i] += " " + s.Note
+		}
+	}
+	return "Destinos: " + strings.Join(parts, " ")
+}
+
+func pendingText(n int) string {
+	if n == 0 {
+		return "Cola: sin pendientes"
+	}
+	if n == 1 {
+		return "Cola: 1 pendiente"
+	}
+	return fmt.Sprintf("Cola: %d pendientes", n)
+}
+
 func truncate(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
@@ -1,6 +1,8 @@
 package ui
 
 import (
+	"io"
+	"os"
 	"testing"
 	"time"
 )
@@ -12,7 +14,7 @@ func TestTerminalUIRender(t *testing.T) {
 		t.Skip("Skipping visual test in short mode")
 	}
 
-	ui := NewTerminalUI()
+	ui := NewTerminalUI(os.Stdout)
 
 	// Test error state
 	t.Log("Testing ERROR state (red background)...")
@@ -66,7 +68,7 @@ func TestTruncate(t *testing.T) {
 
 // TestUpdateSensors tests the sensor status formatting
 func TestUpdateSensors(t *testing.T) {
-	ui := NewTerminalUI()
+	ui := NewTerminalUI(io.Discard)
 
 	tests := []struct {
 		online   int
@@ -86,3 +88,34 @@ func TestUpdateSensors(t *testing.T) {
 		}
 	}
 }
+
+// TestPendingText tests the upload queue line formatting
+func TestPendingText(t *testing.T) {
+	tests := []struct {
+		pending  int
+		expected string
+	}{
+		{0, "Cola: sin pendientes"},
+		{1, "Cola: 1 pendiente"},
+		{42, "Cola: 42 pendientes"},
+	}
+
+	for _, tt := range tests {
+		if result := pendingText(tt.pending); result != tt.expected {
+			t.Errorf("pendingText(%d) = %q, want %q", tt.pending, result, tt.expected)
+		}
+	}
+}
+
+// TestSinksText tests the sink states line formatting
+func TestSinksText(t *testing.T) {
+	sinks := []SinkState{{Name: "api", OK: true}, {Name: "influx", OK: false}}
+	if result := sinksText(sinks); result != "Destinos: api ✓ influx ✗" {
+		t.Errorf("sinksText() = %q", result)
+	}
+
+	sinks = []SinkState{{Name: "api", Paused: true, Note: "hasta 15:40"}}
+	if result := sinksText(sinks); result != "Destinos: api ⏸ hasta 15:40" {
+		t.Errorf("sinksText(paused) = %q", result)
+	}
+}